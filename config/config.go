@@ -7,6 +7,7 @@ import (
 	"io"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -14,11 +15,28 @@ import (
 var Configures *Config
 
 var (
-	defaultHost     = "127.0.0.1"
-	defaultPort     = 6379
-	defaultLogDir   = "./"
-	defaultLogLevel = "info"
-	defaultShardNum = 1024
+	defaultHost                     = "127.0.0.1"
+	defaultPort                     = 6379
+	defaultLogDir                   = "./"
+	defaultLogLevel                 = "info"
+	defaultShardNum                 = 1024
+	defaultDatabases                = 16
+	defaultDir                      = "./"
+	defaultDbFilename               = "dump.tdb"
+	defaultAppendOnly               = false
+	defaultAppendFilename           = "appendonly.aof"
+	defaultAppendFsync              = "everysec"
+	defaultAutoAofRewritePercentage = 100
+	defaultAutoAofRewriteMinSize    = int64(64 << 20)
+	defaultShutdownTimeout          = 10
+	defaultActiveExpireCpuPercent   = 25
+	defaultMaxMemoryPolicy          = "noeviction"
+	defaultMaxMemorySamples         = 5
+	defaultHashMaxListpackEntries   = 128
+	defaultHashMaxListpackValue     = 64
+	defaultSetMaxIntsetEntries      = 512
+	defaultListMaxListpackSize      = -2
+	defaultListCompressDepth        = 0
 )
 
 type Config struct {
@@ -28,6 +46,44 @@ type Config struct {
 	LogDir   string
 	LogLevel string
 	ShardNum int
+	// Databases is the number of logical databases, clients select one by its index from 0 to Databases-1
+	Databases int
+	// Dir is the working directory where persistence files are stored
+	Dir        string
+	DbFilename string
+	// AppendOnly enables the append only file. AppendFsync is one of always, everysec and no
+	AppendOnly     bool
+	AppendFilename string
+	AppendFsync    string
+	// aof is rewritten automatically when it grows by AutoAofRewritePercentage percent since the last rewrite
+	// and is larger than AutoAofRewriteMinSize bytes. 0 percentage disables the automatic rewrite.
+	AutoAofRewritePercentage int
+	AutoAofRewriteMinSize    int64
+	// RdbFile is a native Redis RDB file loaded on startup instead of the snapshot or aof
+	RdbFile string
+	// ShutdownTimeout is the number of seconds to wait for running commands to finish on shutdown,
+	// the shutdown fails and the server keeps serving if they are not finished by then
+	ShutdownTimeout int
+	// ActiveExpireCpuPercent is the percent of cpu time the background expire cycle may use. 0 disables it.
+	ActiveExpireCpuPercent int
+	// NotifyKeyspaceEvents holds the classes of keyspace events published to subscribers, such as KEA. Empty disables it.
+	NotifyKeyspaceEvents string
+	// MaxMemory is the limit of memory used by keys in bytes, 0 means no limit.
+	// Keys are evicted by MaxMemoryPolicy when it is reached, the victims are chosen among MaxMemorySamples sampled keys.
+	MaxMemory        int64
+	MaxMemoryPolicy  string
+	MaxMemorySamples int
+	// a hash is packed into a listpack while it has at most HashMaxListpackEntries fields
+	// and no field or value is longer than HashMaxListpackValue bytes.
+	// a set of integers is an intset while it has at most SetMaxIntsetEntries members. 0 disables the compact encoding.
+	HashMaxListpackEntries int
+	HashMaxListpackValue   int
+	SetMaxIntsetEntries    int
+	// a list is a quicklist of listpack nodes. A positive ListMaxListpackSize limits the elements of a node,
+	// -1 to -5 limit the size of a node to 4kb, 8kb, 16kb, 32kb and 64kb.
+	// Nodes more than ListCompressDepth nodes away from both ends are compressed, 0 disables the compression.
+	ListMaxListpackSize int
+	ListCompressDepth   int
 }
 
 type CfgError struct {
@@ -44,6 +100,28 @@ func flagInit(cfg *Config) {
 	flag.IntVar(&(cfg.Port), "port", defaultPort, "Bind a listening port: default is 6379")
 	flag.StringVar(&(cfg.LogDir), "logdir", defaultLogDir, "Set log directory: default is /tmp")
 	flag.StringVar(&(cfg.LogLevel), "loglevel", defaultLogLevel, "Set log level: default is info")
+	flag.IntVar(&(cfg.Databases), "databases", defaultDatabases, "Set the number of databases: default is 16")
+	flag.StringVar(&(cfg.Dir), "dir", defaultDir, "Set the directory of persistence files: default is ./")
+	flag.StringVar(&(cfg.DbFilename), "dbfilename", defaultDbFilename, "Set the snapshot file name: default is dump.tdb")
+	flag.StringVar(&(cfg.RdbFile), "rdbfile", "", "Load a Redis RDB file on startup: such as /var/lib/redis/dump.rdb")
+	flag.BoolVar(&(cfg.AppendOnly), "appendonly", defaultAppendOnly, "Enable the append only file: default is false")
+	flag.StringVar(&(cfg.AppendFilename), "appendfilename", defaultAppendFilename, "Set the append only file name: default is appendonly.aof")
+	flag.StringVar(&(cfg.AppendFsync), "appendfsync", defaultAppendFsync, "Set the fsync policy of append only file, always|everysec|no: default is everysec")
+	flag.IntVar(&(cfg.ActiveExpireCpuPercent), "activeexpirecpu", defaultActiveExpireCpuPercent, "Set the cpu percent used to delete expired keys in background, 0 disables it: default is 25")
+	flag.StringVar(&(cfg.NotifyKeyspaceEvents), "notifykeyspaceevents", "", "Set the classes of keyspace events to notify, such as KEA: default is empty(disabled)")
+	flag.Func("maxmemory", "Set the memory limit of keys, such as 100mb or 1gb, 0 means no limit: default is 0", func(size string) error {
+		var err error
+		cfg.MaxMemory, err = ParseMemSize(size)
+		return err
+	})
+	flag.StringVar(&(cfg.MaxMemoryPolicy), "maxmemorypolicy", defaultMaxMemoryPolicy, "Set the eviction policy when maxmemory is reached: default is noeviction")
+	flag.IntVar(&(cfg.MaxMemorySamples), "maxmemorysamples", defaultMaxMemorySamples, "Set the number of keys sampled to choose an evicted key: default is 5")
+	flag.IntVar(&(cfg.HashMaxListpackEntries), "hashmaxlistpackentries", defaultHashMaxListpackEntries, "Set the max number of fields of a hash packed into a listpack: default is 128")
+	flag.IntVar(&(cfg.HashMaxListpackValue), "hashmaxlistpackvalue", defaultHashMaxListpackValue, "Set the max length of fields and values of a hash packed into a listpack: default is 64")
+	flag.IntVar(&(cfg.SetMaxIntsetEntries), "setmaxintsetentries", defaultSetMaxIntsetEntries, "Set the max number of members of an integer set stored as an intset: default is 512")
+	flag.IntVar(&(cfg.ListMaxListpackSize), "listmaxlistpacksize", defaultListMaxListpackSize, "Set the max number of elements of a list node, or -1 to -5 for 4kb to 64kb nodes: default is -2")
+	flag.IntVar(&(cfg.ListCompressDepth), "listcompressdepth", defaultListCompressDepth, "Set the number of uncompressed nodes at both ends of a list, 0 disables the compression: default is 0")
+	flag.IntVar(&(cfg.ShutdownTimeout), "shutdowntimeout", defaultShutdownTimeout, "Set the seconds to wait for running commands on shutdown: default is 10")
 }
 
 // Setup initialize configs and do some validation checking.
@@ -51,11 +129,28 @@ func flagInit(cfg *Config) {
 func Setup() (*Config, error) {
 
 	cfg := &Config{
-		Host:     defaultHost,
-		Port:     defaultPort,
-		LogDir:   defaultLogDir,
-		LogLevel: defaultLogLevel,
-		ShardNum: defaultShardNum,
+		Host:                     defaultHost,
+		Port:                     defaultPort,
+		LogDir:                   defaultLogDir,
+		LogLevel:                 defaultLogLevel,
+		ShardNum:                 defaultShardNum,
+		Databases:                defaultDatabases,
+		Dir:                      defaultDir,
+		DbFilename:               defaultDbFilename,
+		AppendOnly:               defaultAppendOnly,
+		AppendFilename:           defaultAppendFilename,
+		AppendFsync:              defaultAppendFsync,
+		AutoAofRewritePercentage: defaultAutoAofRewritePercentage,
+		AutoAofRewriteMinSize:    defaultAutoAofRewriteMinSize,
+		ShutdownTimeout:          defaultShutdownTimeout,
+		ActiveExpireCpuPercent:   defaultActiveExpireCpuPercent,
+		MaxMemoryPolicy:          defaultMaxMemoryPolicy,
+		MaxMemorySamples:         defaultMaxMemorySamples,
+		HashMaxListpackEntries:   defaultHashMaxListpackEntries,
+		HashMaxListpackValue:     defaultHashMaxListpackValue,
+		SetMaxIntsetEntries:      defaultSetMaxIntsetEntries,
+		ListMaxListpackSize:      defaultListMaxListpackSize,
+		ListCompressDepth:        defaultListCompressDepth,
 	}
 
 	flagInit(cfg)
@@ -77,6 +172,72 @@ func Setup() (*Config, error) {
 			}
 			return nil, portErr
 		}
+		if !validFsync(cfg.AppendFsync) {
+			fsyncErr := &CfgError{
+				message: fmt.Sprintf("appendfsync should be always, everysec or no, but %s is given.", cfg.AppendFsync),
+			}
+			return nil, fsyncErr
+		}
+		if cfg.ShutdownTimeout < 0 {
+			timeoutErr := &CfgError{
+				message: fmt.Sprintf("shutdowntimeout should not be negative, but %d is given.", cfg.ShutdownTimeout),
+			}
+			return nil, timeoutErr
+		}
+		if !validPercent(cfg.ActiveExpireCpuPercent) {
+			cpuErr := &CfgError{
+				message: fmt.Sprintf("activeexpirecpu should between 0 and 100, but %d is given.", cfg.ActiveExpireCpuPercent),
+			}
+			return nil, cpuErr
+		}
+		if !validMaxMemoryPolicy(cfg.MaxMemoryPolicy) {
+			policyErr := &CfgError{
+				message: fmt.Sprintf("maxmemorypolicy should be noeviction, allkeys-lru, allkeys-lfu, allkeys-random, volatile-lru, volatile-lfu, volatile-random or volatile-ttl, but %s is given.", cfg.MaxMemoryPolicy),
+			}
+			return nil, policyErr
+		}
+		if cfg.Databases <= 0 {
+			dbErr := &CfgError{
+				message: fmt.Sprintf("databases should be positive, but %d is given.", cfg.Databases),
+			}
+			return nil, dbErr
+		}
+		if cfg.MaxMemorySamples <= 0 {
+			samplesErr := &CfgError{
+				message: fmt.Sprintf("maxmemorysamples should be positive, but %d is given.", cfg.MaxMemorySamples),
+			}
+			return nil, samplesErr
+		}
+		if cfg.HashMaxListpackEntries < 0 {
+			entriesErr := &CfgError{
+				message: fmt.Sprintf("hashmaxlistpackentries should not be negative, but %d is given.", cfg.HashMaxListpackEntries),
+			}
+			return nil, entriesErr
+		}
+		if cfg.HashMaxListpackValue < 0 {
+			valueErr := &CfgError{
+				message: fmt.Sprintf("hashmaxlistpackvalue should not be negative, but %d is given.", cfg.HashMaxListpackValue),
+			}
+			return nil, valueErr
+		}
+		if cfg.SetMaxIntsetEntries < 0 {
+			intsetErr := &CfgError{
+				message: fmt.Sprintf("setmaxintsetentries should not be negative, but %d is given.", cfg.SetMaxIntsetEntries),
+			}
+			return nil, intsetErr
+		}
+		if cfg.ListMaxListpackSize == 0 || cfg.ListMaxListpackSize < -5 {
+			listErr := &CfgError{
+				message: fmt.Sprintf("listmaxlistpacksize should be a positive number or -1 to -5, but %d is given.", cfg.ListMaxListpackSize),
+			}
+			return nil, listErr
+		}
+		if cfg.ListCompressDepth < 0 {
+			depthErr := &CfgError{
+				message: fmt.Sprintf("listcompressdepth should not be negative, but %d is given.", cfg.ListCompressDepth),
+			}
+			return nil, depthErr
+		}
 	}
 	Configures = cfg
 	return cfg, nil
@@ -140,6 +301,115 @@ func (cfg *Config) Parse(cfgFile string) error {
 					fmt.Println("ShardNum should be a number. Get: ", fields[1])
 					panic(err)
 				}
+			} else if cfgName == "databases" {
+				cfg.Databases, err = strconv.Atoi(fields[1])
+				if err != nil || cfg.Databases <= 0 {
+					return &CfgError{
+						message: fmt.Sprintf("databases should be a positive number, but %s is given.", fields[1]),
+					}
+				}
+			} else if cfgName == "dir" {
+				cfg.Dir = fields[1]
+			} else if cfgName == "dbfilename" {
+				cfg.DbFilename = fields[1]
+			} else if cfgName == "appendonly" {
+				cfg.AppendOnly = strings.ToLower(fields[1]) == "yes"
+			} else if cfgName == "appendfilename" {
+				cfg.AppendFilename = fields[1]
+			} else if cfgName == "appendfsync" {
+				fsync := strings.ToLower(fields[1])
+				if !validFsync(fsync) {
+					fsyncErr := &CfgError{
+						message: fmt.Sprintf("appendfsync should be always, everysec or no, but %s is given.", fields[1]),
+					}
+					return fsyncErr
+				}
+				cfg.AppendFsync = fsync
+			} else if cfgName == "rdbfile" {
+				cfg.RdbFile = fields[1]
+			} else if cfgName == "auto-aof-rewrite-percentage" {
+				cfg.AutoAofRewritePercentage, err = strconv.Atoi(fields[1])
+				if err != nil || cfg.AutoAofRewritePercentage < 0 {
+					return &CfgError{
+						message: fmt.Sprintf("auto-aof-rewrite-percentage should be a positive number, but %s is given.", fields[1]),
+					}
+				}
+			} else if cfgName == "shutdown-timeout" {
+				cfg.ShutdownTimeout, err = strconv.Atoi(fields[1])
+				if err != nil || cfg.ShutdownTimeout < 0 {
+					return &CfgError{
+						message: fmt.Sprintf("shutdown-timeout should be a positive number, but %s is given.", fields[1]),
+					}
+				}
+			} else if cfgName == "active-expire-cpu-percent" {
+				cfg.ActiveExpireCpuPercent, err = strconv.Atoi(fields[1])
+				if err != nil || !validPercent(cfg.ActiveExpireCpuPercent) {
+					return &CfgError{
+						message: fmt.Sprintf("active-expire-cpu-percent should between 0 and 100, but %s is given.", fields[1]),
+					}
+				}
+			} else if cfgName == "notify-keyspace-events" {
+				cfg.NotifyKeyspaceEvents = strings.Trim(fields[1], "\"")
+			} else if cfgName == "maxmemory" {
+				cfg.MaxMemory, err = ParseMemSize(fields[1])
+				if err != nil {
+					return err
+				}
+			} else if cfgName == "maxmemory-policy" {
+				policy := strings.ToLower(fields[1])
+				if !validMaxMemoryPolicy(policy) {
+					return &CfgError{
+						message: fmt.Sprintf("maxmemory-policy should be noeviction, allkeys-lru, allkeys-lfu, allkeys-random, volatile-lru, volatile-lfu, volatile-random or volatile-ttl, but %s is given.", fields[1]),
+					}
+				}
+				cfg.MaxMemoryPolicy = policy
+			} else if cfgName == "maxmemory-samples" {
+				cfg.MaxMemorySamples, err = strconv.Atoi(fields[1])
+				if err != nil || cfg.MaxMemorySamples <= 0 {
+					return &CfgError{
+						message: fmt.Sprintf("maxmemory-samples should be a positive number, but %s is given.", fields[1]),
+					}
+				}
+			} else if cfgName == "hash-max-listpack-entries" || cfgName == "hash-max-ziplist-entries" {
+				cfg.HashMaxListpackEntries, err = strconv.Atoi(fields[1])
+				if err != nil || cfg.HashMaxListpackEntries < 0 {
+					return &CfgError{
+						message: fmt.Sprintf("%s should be a positive number, but %s is given.", cfgName, fields[1]),
+					}
+				}
+			} else if cfgName == "hash-max-listpack-value" || cfgName == "hash-max-ziplist-value" {
+				cfg.HashMaxListpackValue, err = strconv.Atoi(fields[1])
+				if err != nil || cfg.HashMaxListpackValue < 0 {
+					return &CfgError{
+						message: fmt.Sprintf("%s should be a positive number, but %s is given.", cfgName, fields[1]),
+					}
+				}
+			} else if cfgName == "set-max-intset-entries" {
+				cfg.SetMaxIntsetEntries, err = strconv.Atoi(fields[1])
+				if err != nil || cfg.SetMaxIntsetEntries < 0 {
+					return &CfgError{
+						message: fmt.Sprintf("set-max-intset-entries should be a positive number, but %s is given.", fields[1]),
+					}
+				}
+			} else if cfgName == "list-max-listpack-size" || cfgName == "list-max-ziplist-size" {
+				cfg.ListMaxListpackSize, err = strconv.Atoi(fields[1])
+				if err != nil || cfg.ListMaxListpackSize == 0 || cfg.ListMaxListpackSize < -5 {
+					return &CfgError{
+						message: fmt.Sprintf("%s should be a positive number or -1 to -5, but %s is given.", cfgName, fields[1]),
+					}
+				}
+			} else if cfgName == "list-compress-depth" {
+				cfg.ListCompressDepth, err = strconv.Atoi(fields[1])
+				if err != nil || cfg.ListCompressDepth < 0 {
+					return &CfgError{
+						message: fmt.Sprintf("list-compress-depth should be a positive number, but %s is given.", fields[1]),
+					}
+				}
+			} else if cfgName == "auto-aof-rewrite-min-size" {
+				cfg.AutoAofRewriteMinSize, err = ParseMemSize(fields[1])
+				if err != nil {
+					return err
+				}
 			}
 		}
 		if ioErr == io.EOF {
@@ -148,3 +418,53 @@ func (cfg *Config) Parse(cfgFile string) error {
 	}
 	return nil
 }
+
+// SnapshotPath returns the full path of the snapshot file
+func (cfg *Config) SnapshotPath() string {
+	return filepath.Join(cfg.Dir, cfg.DbFilename)
+}
+
+// AofPath returns the full path of the append only file
+func (cfg *Config) AofPath() string {
+	return filepath.Join(cfg.Dir, cfg.AppendFilename)
+}
+
+func validFsync(fsync string) bool {
+	return fsync == "always" || fsync == "everysec" || fsync == "no"
+}
+
+func validPercent(percent int) bool {
+	return percent >= 0 && percent <= 100
+}
+
+func validMaxMemoryPolicy(policy string) bool {
+	switch policy {
+	case "noeviction", "allkeys-lru", "allkeys-lfu", "allkeys-random",
+		"volatile-lru", "volatile-lfu", "volatile-random", "volatile-ttl":
+		return true
+	}
+	return false
+}
+
+// ParseMemSize parses a memory size such as 1024, 100kb, 64mb or 1gb to bytes
+func ParseMemSize(origin string) (int64, error) {
+	size := strings.ToLower(origin)
+	unit := int64(1)
+	for _, suffix := range []struct {
+		name string
+		unit int64
+	}{{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1}} {
+		if strings.HasSuffix(size, suffix.name) {
+			unit = suffix.unit
+			size = strings.TrimSuffix(size, suffix.name)
+			break
+		}
+	}
+	num, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || num < 0 {
+		return 0, &CfgError{
+			message: fmt.Sprintf("invalid memory size %s", origin),
+		}
+	}
+	return num * unit, nil
+}
@@ -26,4 +26,22 @@ func TestConfig_Parse(t *testing.T) {
 	if cfg.ShardNum != 1024 {
 		t.Error(fmt.Sprintf("cfg.ShardNum == %d, expect 1024", cfg.ShardNum))
 	}
+	if cfg.Databases != 4 {
+		t.Error(fmt.Sprintf("cfg.Databases == %d, expect 4", cfg.Databases))
+	}
+	if cfg.MaxMemory != 100<<20 {
+		t.Error(fmt.Sprintf("cfg.MaxMemory == %d, expect %d", cfg.MaxMemory, 100<<20))
+	}
+	if cfg.MaxMemoryPolicy != "allkeys-lru" {
+		t.Error(fmt.Sprintf("cfg.MaxMemoryPolicy == %s, expect allkeys-lru", cfg.MaxMemoryPolicy))
+	}
+	if cfg.HashMaxListpackEntries != 64 {
+		t.Error(fmt.Sprintf("cfg.HashMaxListpackEntries == %d, expect 64", cfg.HashMaxListpackEntries))
+	}
+	if cfg.SetMaxIntsetEntries != 256 {
+		t.Error(fmt.Sprintf("cfg.SetMaxIntsetEntries == %d, expect 256", cfg.SetMaxIntsetEntries))
+	}
+	if cfg.ListMaxListpackSize != 128 || cfg.ListCompressDepth != 1 {
+		t.Error(fmt.Sprintf("cfg.ListMaxListpackSize == %d, cfg.ListCompressDepth == %d, expect 128 and 1", cfg.ListMaxListpackSize, cfg.ListCompressDepth))
+	}
 }
@@ -30,10 +30,10 @@ const (
 
 var (
 	logFile            *os.File
-	logger             *log.Logger
+	logger             = log.New(os.Stdout, "", log.LstdFlags) // write to stdout until SetUp is called
 	logMu              sync.Mutex
 	levelLabels        = []string{"debug", "info", "warning", "error", "panic"}
-	logcfg             *LogConfig
+	logcfg             = &LogConfig{Level: INFO}
 	defaultCallerDepth = 2
 	logPrefix          = ""
 )
@@ -83,6 +83,7 @@ func setPrefix(level LogLevel) {
 	logger.SetPrefix(logPrefix)
 }
 
+// Debug, Info, Warning, Error and Panic print v like fmt.Println, they take no format string
 func Debug(v ...any) {
 	if logcfg.Level > DEBUG {
 		return
@@ -90,7 +91,7 @@ func Debug(v ...any) {
 	logMu.Lock()
 	defer logMu.Unlock()
 	setPrefix(DEBUG)
-	logger.Println(v)
+	logger.Println(v...)
 }
 
 func Info(v ...any) {
@@ -100,7 +101,7 @@ func Info(v ...any) {
 	logMu.Lock()
 	defer logMu.Unlock()
 	setPrefix(INFO)
-	logger.Println(v)
+	logger.Println(v...)
 }
 
 func Warning(v ...any) {
@@ -110,7 +111,7 @@ func Warning(v ...any) {
 	logMu.Lock()
 	defer logMu.Unlock()
 	setPrefix(WARNING)
-	logger.Println(v)
+	logger.Println(v...)
 }
 
 func Error(v ...any) {
@@ -120,7 +121,7 @@ func Error(v ...any) {
 	logMu.Lock()
 	defer logMu.Unlock()
 	setPrefix(ERROR)
-	logger.Println(v)
+	logger.Println(v...)
 }
 
 func Panic(v ...any) {
@@ -130,5 +131,18 @@ func Panic(v ...any) {
 	logMu.Lock()
 	defer logMu.Unlock()
 	setPrefix(PANIC)
-	logger.Println(v)
+	logger.Println(v...)
+}
+
+// Close closes the log file. Logs are written to stdout only after Close.
+func Close() error {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if logFile == nil {
+		return nil
+	}
+	logger.SetOutput(os.Stdout)
+	err := logFile.Close()
+	logFile = nil
+	return err
 }
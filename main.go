@@ -17,6 +17,13 @@ func init() {
 	memdb.RegisterListCommands()
 	memdb.RegisterSetCommands()
 	memdb.RegisterHashCommands()
+	memdb.RegisterZSetCommands()
+	memdb.RegisterSnapshotCommands()
+	memdb.RegisterAofCommands()
+	memdb.RegisterDebugCommands()
+	memdb.RegisterInfoCommands()
+	memdb.RegisterPubSubCommands()
+	memdb.RegisterMemoryCommands()
 }
 
 func main() {
@@ -30,7 +37,44 @@ func main() {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	err = server.Start(cfg)
+	if _, err = memdb.ParseNotifyClasses(cfg.NotifyKeyspaceEvents); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	dbs := memdb.NewDatabases(cfg.Databases)
+	// a given Redis RDB file takes precedence over the own persistence files.
+	// aof is more complete than snapshot, so load from aof if it is enabled
+	if cfg.RdbFile != "" {
+		err = memdb.LoadRdb(cfg.RdbFile, dbs)
+	} else if cfg.AppendOnly {
+		err = memdb.LoadAof(cfg.AofPath(), dbs)
+	} else {
+		err = memdb.LoadSnapshot(cfg.SnapshotPath(), dbs)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if cfg.AppendOnly {
+		aof, err := memdb.NewAof(cfg.AofPath(), cfg.AppendFsync)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		dbs.SetAof(aof)
+		// keys loaded from the RDB file are not in aof yet
+		if cfg.RdbFile != "" {
+			if err = aof.Rewrite(); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+	}
+	dbs.StartActiveExpire(cfg.ActiveExpireCpuPercent)
+	err = server.Start(cfg, dbs)
+	if closeErr := logger.Close(); closeErr != nil {
+		fmt.Println(closeErr)
+	}
 	if err != nil {
 		os.Exit(1)
 	}
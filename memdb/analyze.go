@@ -0,0 +1,247 @@
+package memdb
+
+import (
+	"container/heap"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VincentFF/thinredis/logger"
+	"github.com/VincentFF/thinredis/resp"
+)
+
+// analyze.go implements ANALYZE BIGKEYS|HOTKEYS [TOP n] [SAMPLES n], the server side counterparts of redis-cli --bigkeys and --hotkeys.
+// The keyspace is walked shard by shard and only a single key is locked at a time, so the analysis doesn't pause other clients.
+// With SAMPLES n only about n random keys are read instead of all keys.
+// Big keys are ranked by the number of elements and by the accounted memory, hot keys by the lfu counters kept in meta.
+
+const (
+	// analyzeTop is the number of keys reported by default
+	analyzeTop = 10
+	// histogramBuckets is the number of power of two buckets of key sizes, the last one holds all bigger keys
+	histogramBuckets = 40
+)
+
+type rankedKey struct {
+	key   string
+	score int64
+}
+
+// rankHeap implements heap.Interface, the key with the lowest score is on the top
+type rankHeap []rankedKey
+
+func (h rankHeap) Len() int { return len(h) }
+
+func (h rankHeap) Less(i, j int) bool { return h[i].score < h[j].score }
+
+func (h rankHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *rankHeap) Push(x any) { *h = append(*h, x.(rankedKey)) }
+
+func (h *rankHeap) Pop() any {
+	old := *h
+	k := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return k
+}
+
+// topKeys keeps the n keys with the highest scores
+type topKeys struct {
+	n    int
+	keys rankHeap
+}
+
+func (t *topKeys) add(key string, score int64) {
+	if len(t.keys) < t.n {
+		heap.Push(&t.keys, rankedKey{key, score})
+	} else if t.n > 0 && score > t.keys[0].score {
+		t.keys[0] = rankedKey{key, score}
+		heap.Fix(&t.keys, 0)
+	}
+}
+
+// reply returns the keys and their scores in a flat array, the highest score first
+func (t *topKeys) reply() resp.RedisData {
+	sorted := make(rankHeap, len(t.keys))
+	copy(sorted, t.keys)
+	res := make([]resp.RedisData, len(sorted)*2)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		k := heap.Pop(&sorted).(rankedKey)
+		res[i*2], res[i*2+1] = resp.MakeBulkData([]byte(k.key)), resp.MakeIntData(k.score)
+	}
+	return resp.MakeArrayData(res)
+}
+
+// typeAnalysis holds the big key statistics of a value type
+type typeAnalysis struct {
+	keys       int64
+	elements   int64
+	bytes      int64
+	byElements topKeys
+	byBytes    topKeys
+	histogram  [histogramBuckets]int64 // bucket i counts the keys of at most 1<<i bytes
+}
+
+func (a *typeAnalysis) add(key string, elements, size int64) {
+	a.keys++
+	a.elements += elements
+	a.bytes += size
+	a.byElements.add(key, elements)
+	a.byBytes.add(key, size)
+	bucket := 0
+	if size > 1 {
+		bucket = bits.Len64(uint64(size - 1))
+	}
+	if bucket >= histogramBuckets {
+		bucket = histogramBuckets - 1
+	}
+	a.histogram[bucket]++
+}
+
+func (a *typeAnalysis) reply() resp.RedisData {
+	histogram := make([]resp.RedisData, 0)
+	for i, count := range a.histogram {
+		if count > 0 {
+			histogram = append(histogram, resp.MakeBulkData([]byte("<="+humanSize(1<<i))), resp.MakeIntData(count))
+		}
+	}
+	return resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte("keys")), resp.MakeIntData(a.keys),
+		resp.MakeBulkData([]byte("elements")), resp.MakeIntData(a.elements),
+		resp.MakeBulkData([]byte("bytes")), resp.MakeIntData(a.bytes),
+		resp.MakeBulkData([]byte("biggest.elements")), a.byElements.reply(),
+		resp.MakeBulkData([]byte("biggest.bytes")), a.byBytes.reply(),
+		resp.MakeBulkData([]byte("histogram")), resp.MakeArrayData(histogram),
+	})
+}
+
+// valueLen returns the number of elements of val, the length of a string
+func valueLen(val any) int64 {
+	switch v := val.(type) {
+	case []byte:
+		return int64(len(v))
+	case *List:
+		return int64(v.Len)
+	case *Set:
+		return int64(v.Len())
+	case *Hash:
+		return int64(v.Len())
+	case *ZSet:
+		return int64(v.Len())
+	}
+	return 0
+}
+
+// walkKeys calls fn for all keys shard by shard, or for about samples random keys if samples is positive
+func (m *MemDb) walkKeys(samples int, fn func(key string)) {
+	if samples > 0 {
+		for _, key := range sampleKeys(m.db, samples) {
+			fn(key)
+		}
+		return
+	}
+	for pos := 0; pos < m.db.ShardNum(); pos++ {
+		for _, key := range m.db.ShardKeys(pos) {
+			fn(key)
+		}
+	}
+}
+
+// analyzeBigKeys returns the number of keys read and the big key statistics of each value type
+func (m *MemDb) analyzeBigKeys(top, samples int) (int64, [kindNum]*typeAnalysis) {
+	var types [kindNum]*typeAnalysis
+	for i := range types {
+		types[i] = &typeAnalysis{byElements: topKeys{n: top}, byBytes: topKeys{n: top}}
+	}
+	scanned := int64(0)
+	m.walkKeys(samples, func(key string) {
+		// reading a key for the analysis is not an access
+		if !m.alive(key) {
+			return
+		}
+		m.locks.RLock(key)
+		defer m.locks.RUnLock(key)
+		val, ok := m.db.Get(key)
+		if !ok {
+			return
+		}
+		var size int64
+		if tem, ok := m.meta.Get(key); ok {
+			size = tem.(*keyMeta).size.Load()
+		} else {
+			size = valueSize(val, memorySamples)
+		}
+		scanned++
+		types[valueKind(val)].add(key, valueLen(val), size)
+	})
+	return scanned, types
+}
+
+// analyzeHotKeys returns the number of keys read and the top keys ranked by their decayed lfu counters
+func (m *MemDb) analyzeHotKeys(top, samples int) (int64, *topKeys) {
+	hot := &topKeys{n: top}
+	scanned := int64(0)
+	now := time.Now().UnixMilli()
+	m.walkKeys(samples, func(key string) {
+		if !m.alive(key) {
+			return
+		}
+		if tem, ok := m.meta.Get(key); ok {
+			scanned++
+			hot.add(key, int64(lfuDecr(tem.(*keyMeta), now)))
+		}
+	})
+	return scanned, hot
+}
+
+// analyzeKeys handles ANALYZE BIGKEYS|HOTKEYS [TOP n] [SAMPLES n].
+// The lfu counters are always tracked, so HOTKEYS works with any maxmemory policy.
+func analyzeKeys(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "analyze" {
+		logger.Error("analyzeKeys Function: cmdName is not analyze")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 2 {
+		return resp.MakeErrorData("wrong number of arguments for 'analyze' command")
+	}
+	subCmd := strings.ToLower(string(cmd[1]))
+	if subCmd != "bigkeys" && subCmd != "hotkeys" {
+		return resp.MakeErrorData("error: unsupported analyze subcommand " + string(cmd[1]))
+	}
+	top, samples := analyzeTop, 0
+	for i := 2; i < len(cmd); i += 2 {
+		if i+1 >= len(cmd) {
+			return resp.MakeErrorData("error: syntax error")
+		}
+		n, err := strconv.Atoi(string(cmd[i+1]))
+		switch strings.ToLower(string(cmd[i])) {
+		case "top":
+			if err != nil || n <= 0 {
+				return resp.MakeErrorData("top value must be a positive integer")
+			}
+			top = n
+		case "samples":
+			if err != nil || n < 0 {
+				return resp.MakeErrorData("error: value is out of range, must be positive")
+			}
+			samples = n
+		default:
+			return resp.MakeErrorData("error: syntax error")
+		}
+	}
+
+	if subCmd == "hotkeys" {
+		scanned, hot := m.analyzeHotKeys(top, samples)
+		return resp.MakeArrayData([]resp.RedisData{
+			resp.MakeBulkData([]byte("keys.scanned")), resp.MakeIntData(scanned),
+			resp.MakeBulkData([]byte("hottest")), hot.reply(),
+		})
+	}
+	scanned, types := m.analyzeBigKeys(top, samples)
+	res := []resp.RedisData{resp.MakeBulkData([]byte("keys.scanned")), resp.MakeIntData(scanned)}
+	for i, name := range kindNames {
+		res = append(res, resp.MakeBulkData([]byte(name)), types[i].reply())
+	}
+	return resp.MakeArrayData(res)
+}
@@ -0,0 +1,86 @@
+package memdb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/VincentFF/thinredis/resp"
+)
+
+// replyMap turns a flat name/value array into a map
+func replyMap(res resp.RedisData) map[string]resp.RedisData {
+	data := res.(*resp.ArrayData).Data()
+	fields := make(map[string]resp.RedisData)
+	for i := 0; i+1 < len(data); i += 2 {
+		fields[string(data[i].ByteData())] = data[i+1]
+	}
+	return fields
+}
+
+func TestAnalyzeBigKeys(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "set s1 a", "set s2 "+strings.Repeat("a", 100), "set s3 abc",
+		"rpush l1 a b c", "rpush l2 a", "sadd set a b", "hset h f v")
+	res := analyzeKeys(memdb, [][]byte{[]byte("analyze"), []byte("bigkeys"), []byte("top"), []byte("2")})
+	fields := replyMap(res)
+	if fields["keys.scanned"].(*resp.IntData).Data() != 7 {
+		t.Errorf("scanned %d keys, expect 7", fields["keys.scanned"].(*resp.IntData).Data())
+	}
+
+	strs := replyMap(fields["string"])
+	if strs["keys"].(*resp.IntData).Data() != 3 || strs["elements"].(*resp.IntData).Data() != 104 {
+		t.Errorf("string statistics are %q", fields["string"].ToBytes())
+	}
+	biggest := strs["biggest.elements"].(*resp.ArrayData).Data()
+	if len(biggest) != 4 || string(biggest[0].ByteData()) != "s2" || string(biggest[2].ByteData()) != "s3" {
+		t.Errorf("biggest strings are %q, expect s2 and s3", strs["biggest.elements"].ToBytes())
+	}
+	tem, _ := memdb.meta.Get("s2")
+	if !bytes.Equal(strs["biggest.bytes"].(*resp.ArrayData).Data()[1].ToBytes(), resp.MakeIntData(tem.(*keyMeta).size.Load()).ToBytes()) {
+		t.Error("biggest string is not ranked by its accounted size")
+	}
+	histogram := strs["histogram"].(*resp.ArrayData).Data()
+	counted := int64(0)
+	for i := 1; i < len(histogram); i += 2 {
+		counted += histogram[i].(*resp.IntData).Data()
+	}
+	if counted != 3 {
+		t.Errorf("histogram counts %d strings, expect 3", counted)
+	}
+
+	lists := replyMap(fields["list"])
+	if lists["keys"].(*resp.IntData).Data() != 2 || lists["elements"].(*resp.IntData).Data() != 4 {
+		t.Errorf("list statistics are %q", fields["list"].ToBytes())
+	}
+
+	res = analyzeKeys(memdb, [][]byte{[]byte("analyze"), []byte("bigkeys"), []byte("samples"), []byte("3")})
+	if scanned := replyMap(res)["keys.scanned"].(*resp.IntData).Data(); scanned == 0 || scanned > 7 {
+		t.Errorf("scanned %d keys with 3 samples", scanned)
+	}
+	res = analyzeKeys(memdb, [][]byte{[]byte("analyze"), []byte("bigkeys"), []byte("top")})
+	if !bytes.Equal(res.ToBytes(), []byte("-error: syntax error\r\n")) {
+		t.Errorf("top without a value replies %q", res.ToBytes())
+	}
+}
+
+func TestAnalyzeHotKeys(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "set cold v", "set warm v", "set hot v")
+	for key, freq := range map[string]uint32{"cold": 1, "warm": 20, "hot": 100} {
+		tem, _ := memdb.meta.Get(key)
+		tem.(*keyMeta).freq.Store(freq)
+	}
+	res := analyzeKeys(memdb, [][]byte{[]byte("analyze"), []byte("hotkeys"), []byte("top"), []byte("2")})
+	fields := replyMap(res)
+	expect := resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte("hot")), resp.MakeIntData(100),
+		resp.MakeBulkData([]byte("warm")), resp.MakeIntData(20),
+	})
+	if !bytes.Equal(fields["hottest"].ToBytes(), expect.ToBytes()) {
+		t.Errorf("hottest keys are %q, expect hot and warm", fields["hottest"].ToBytes())
+	}
+	if fields["keys.scanned"].(*resp.IntData).Data() != 3 {
+		t.Error("all keys should be scanned")
+	}
+}
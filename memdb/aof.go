@@ -0,0 +1,634 @@
+package memdb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VincentFF/thinredis/config"
+	"github.com/VincentFF/thinredis/logger"
+	"github.com/VincentFF/thinredis/resp"
+)
+
+// aof.go implements the append only file.
+// Every successful write command executed by MemDb.ExecCommand is appended to the file in RESP format,
+// and the file is replayed through the same command table at startup.
+// Commands which depend on the time or on randomness are rewritten before appending,
+// so that replaying them gives the same result. For example, EXPIRE is rewritten to PEXPIREAT.
+//
+// A SELECT is written before the commands of a database other than the one of the previous command.
+//
+// BGREWRITEAOF compacts the file to the minimal commands which rebuild the current databases.
+// There is no fork to take a point-in-time copy of the db, so keys are dumped one by one while writes continue.
+// Replaying the writes made during the rewrite after the dump could apply them twice (INCR, RPUSH...),
+// so the keys they touch are recorded instead, and their final state is merged into the new file
+// while writes are paused for the atomic file swap.
+// Keys are recorded by their MemDb rather than their database index, because SWAPDB may change the index.
+// The swaps and flushes made during the rewrite are merged before the keys.
+
+const (
+	FsyncAlways   = "always"
+	FsyncEverySec = "everysec"
+	FsyncNo       = "no"
+)
+
+const aofRewriteItemsPerCmd = 64
+
+var errAofRewriting = errors.New("background append only file rewriting already in progress")
+
+// Aof is an append only file which records write commands
+type Aof struct {
+	file  *os.File
+	path  string
+	fsync string
+	dbs   *Databases
+	mu    sync.Mutex // protects file, the file sizes, selected and the rewrite records
+
+	selected int // database of the last command in the file, -1 if unknown
+
+	size     int64 // current file size
+	baseSize int64 // file size after the last rewrite, used by the automatic rewrite
+
+	// cmdMu is held by write commands from their execution to appending,
+	// and held exclusively when the rewritten file is swapped in.
+	cmdMu     sync.RWMutex
+	rewriteMu sync.Mutex // held during a rewrite
+
+	// the records of a rewrite, touched is nil if no rewrite is running
+	touched    map[*MemDb]map[string]struct{} // keys written during the rewrite
+	flushedAll bool                           // whether FLUSHALL is executed during the rewrite
+	flushed    map[*MemDb]bool                // databases flushed by FLUSHDB during the rewrite
+	swaps      [][][]byte                     // SWAPDB commands executed during the rewrite
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewAof opens or creates the append only file at path.
+// fsync is the fsync policy: always, everysec or no.
+func NewAof(path string, fsync string) (*Aof, error) {
+	fl, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fl.Stat()
+	if err != nil {
+		_ = fl.Close()
+		return nil, err
+	}
+	aof := &Aof{
+		file:     fl,
+		path:     path,
+		fsync:    fsync,
+		size:     info.Size(),
+		baseSize: info.Size(),
+		selected: -1,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go aof.syncEverySec()
+	return aof, nil
+}
+
+func (a *Aof) syncEverySec() {
+	defer close(a.done)
+	if a.fsync != FsyncEverySec {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.mu.Lock()
+			if err := a.file.Sync(); err != nil {
+				logger.Error("aof fsync error: ", err.Error())
+			}
+			a.mu.Unlock()
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// Append writes cmds executed on m to the file in RESP format. keys are the keys of m modified by cmds.
+// m is nil if cmds don't depend on the selected database, like FLUSHALL.
+func (a *Aof) Append(m *MemDb, keys []string, cmds ...[][]byte) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	buf := make([]byte, 0)
+	if m != nil {
+		if index := int(m.index.Load()); index != a.selected {
+			buf = append(buf, commandToResp(selectCommand(index)).ToBytes()...)
+			a.selected = index
+		}
+		a.touchLocked(m, keys...)
+	}
+	for _, cmd := range cmds {
+		buf = append(buf, commandToResp(cmd).ToBytes()...)
+	}
+	n, err := a.file.Write(buf)
+	a.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if a.needRewrite() {
+		if err = a.StartRewrite(); err != nil && err != errAofRewriting {
+			logger.Error("automatic aof rewrite error: ", err.Error())
+		}
+	}
+	if a.fsync == FsyncAlways {
+		return a.file.Sync()
+	}
+	return nil
+}
+
+// needRewrite checks if the file has grown enough for an automatic rewrite. a.mu must be held.
+func (a *Aof) needRewrite() bool {
+	percentage := config.Configures.AutoAofRewritePercentage
+	if percentage <= 0 || a.touched != nil || a.size < config.Configures.AutoAofRewriteMinSize {
+		return false
+	}
+	base := a.baseSize
+	if base == 0 {
+		base = 1
+	}
+	return (a.size-base)*100/base >= int64(percentage)
+}
+
+// StartRewrite starts a rewrite in background. It returns an error if a rewrite is already running.
+func (a *Aof) StartRewrite() error {
+	if !a.rewriteMu.TryLock() {
+		return errAofRewriting
+	}
+	go func() {
+		defer a.rewriteMu.Unlock()
+		if err := a.rewrite(); err != nil {
+			logger.Error("background aof rewrite error: ", err.Error())
+			return
+		}
+		logger.Info("background aof rewrite finished")
+	}()
+	return nil
+}
+
+// touchLocked records keys of m as written for the running rewrite. a.mu must be held.
+func (a *Aof) touchLocked(m *MemDb, keys ...string) {
+	if a.touched == nil || len(keys) == 0 {
+		return
+	}
+	touched, ok := a.touched[m]
+	if !ok {
+		touched = make(map[string]struct{})
+		a.touched[m] = touched
+	}
+	for _, key := range keys {
+		touched[key] = struct{}{}
+	}
+}
+
+// touch records key of m as written for the running rewrite.
+// It is used by the commands which write a key of another database than the one they are appended with.
+func (a *Aof) touch(m *MemDb, key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.touchLocked(m, key)
+}
+
+// AppendFlush writes cmd which flushes m, or all databases if m is nil, to the file.
+// The keys written before it are dropped from the running rewrite, which writes cmd before the keys written after it.
+func (a *Aof) AppendFlush(m *MemDb, cmd [][]byte) error {
+	a.mu.Lock()
+	if a.touched != nil {
+		if m == nil {
+			a.touched = make(map[*MemDb]map[string]struct{})
+			a.flushed = make(map[*MemDb]bool)
+			a.flushedAll = true
+		} else {
+			delete(a.touched, m)
+			a.flushed[m] = true
+		}
+	}
+	a.mu.Unlock()
+	return a.Append(m, nil, cmd)
+}
+
+// AppendSwap writes SWAPDB cmd to the file. The running rewrite replays it after the databases it has dumped.
+// The caller must hold cmdMu, so that the swap is not missed by a rewrite starting meanwhile.
+func (a *Aof) AppendSwap(cmd [][]byte) error {
+	a.mu.Lock()
+	if a.touched != nil {
+		a.swaps = append(a.swaps, cmd)
+	}
+	a.mu.Unlock()
+	return a.Append(nil, nil, cmd)
+}
+
+func selectCommand(index int) [][]byte {
+	return [][]byte{[]byte("select"), []byte(strconv.Itoa(index))}
+}
+
+// Rewrite rewrites the file and returns when it is done. It waits for the running rewrite first.
+func (a *Aof) Rewrite() error {
+	a.rewriteMu.Lock()
+	defer a.rewriteMu.Unlock()
+	return a.rewrite()
+}
+
+func (a *Aof) rewrite() error {
+	// the databases are listed while writes are paused, so that every later swap is recorded
+	a.cmdMu.Lock()
+	a.mu.Lock()
+	a.touched = make(map[*MemDb]map[string]struct{})
+	a.flushed = make(map[*MemDb]bool)
+	dbs := a.dbs.list()
+	a.mu.Unlock()
+	a.cmdMu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.touched, a.flushed, a.flushedAll, a.swaps = nil, nil, false, nil
+		a.mu.Unlock()
+	}()
+
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), "temp-rewrite-*.aof")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// remove the temp file if it is not renamed
+		_ = os.Remove(tmp.Name())
+	}()
+	writer := bufio.NewWriter(tmp)
+	selected := -1
+	// selectDb writes a SELECT if index is not selected yet
+	selectDb := func(index int) error {
+		if index == selected {
+			return nil
+		}
+		selected = index
+		return writeCommands(writer, [][][]byte{selectCommand(index)})
+	}
+	for i, m := range dbs {
+		keys := m.db.Keys()
+		if len(keys) == 0 {
+			continue
+		}
+		if err = selectDb(i); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		for _, key := range keys {
+			if err = writeCommands(writer, m.rewriteCommands(key)); err != nil {
+				_ = tmp.Close()
+				return err
+			}
+		}
+	}
+
+	// pause writes, then merge the keys written during the rewrite and swap the file
+	a.cmdMu.Lock()
+	defer a.cmdMu.Unlock()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	merged := a.swaps
+	if a.flushedAll {
+		merged = append(merged, [][]byte{[]byte("flushall")})
+	}
+	if err = writeCommands(writer, merged); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	for m := range a.flushed {
+		if err = selectDb(int(m.index.Load())); err == nil {
+			err = writeCommands(writer, [][][]byte{{[]byte("flushdb")}})
+		}
+		if err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	for m, keys := range a.touched {
+		if err = selectDb(int(m.index.Load())); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		for key := range keys {
+			cmds := append([][][]byte{{[]byte("del"), []byte(key)}}, m.rewriteCommands(key)...)
+			if err = writeCommands(writer, cmds); err != nil {
+				_ = tmp.Close()
+				return err
+			}
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), a.path); err != nil {
+		return err
+	}
+
+	fl, err := os.OpenFile(a.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := fl.Stat()
+	if err != nil {
+		_ = fl.Close()
+		return err
+	}
+	if err = a.file.Close(); err != nil {
+		logger.Error("close old aof file error: ", err.Error())
+	}
+	a.file = fl
+	a.selected = selected
+	a.size = info.Size()
+	a.baseSize = info.Size()
+	return nil
+}
+
+func writeCommands(w io.Writer, cmds [][][]byte) error {
+	for _, cmd := range cmds {
+		if _, err := w.Write(commandToResp(cmd).ToBytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteCommands returns the minimal commands which rebuild key and its ttl
+func (m *MemDb) rewriteCommands(key string) [][][]byte {
+	if !m.alive(key) {
+		return nil
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+	val, ok := m.db.Get(key)
+	if !ok {
+		return nil
+	}
+
+	var cmds [][][]byte
+	// batch collection items into commands of at most aofRewriteItemsPerCmd items
+	batch := func(cmdName string, items [][]byte, itemSize int) {
+		for start := 0; start < len(items); start += aofRewriteItemsPerCmd * itemSize {
+			end := start + aofRewriteItemsPerCmd*itemSize
+			if end > len(items) {
+				end = len(items)
+			}
+			cmd := append([][]byte{[]byte(cmdName), []byte(key)}, items[start:end]...)
+			cmds = append(cmds, cmd)
+		}
+	}
+	switch v := val.(type) {
+	case []byte:
+		cmds = append(cmds, [][]byte{[]byte("set"), []byte(key), v})
+	case *List:
+		batch("rpush", v.Range(0, -1), 1)
+	case *Set:
+		members := make([][]byte, 0, v.Len())
+		v.Range(func(member string) bool {
+			members = append(members, []byte(member))
+			return true
+		})
+		batch("sadd", members, 1)
+	case *Hash:
+		fields := make([][]byte, 0, v.Len()*2)
+		v.Range(func(field string, value []byte) bool {
+			fields = append(fields, []byte(field), value)
+			return true
+		})
+		batch("hset", fields, 2)
+	case *ZSet:
+		pairs := make([][]byte, 0, v.Len()*2)
+		v.Range(func(member string, score float64) bool {
+			pairs = append(pairs, []byte(formatScore(score)), []byte(member))
+			return true
+		})
+		batch("zadd", pairs, 2)
+	default:
+		logger.Error("rewriteCommands Function: unknown value type of key ", key)
+		return nil
+	}
+	return append(cmds, m.expireAtCommand(key)...)
+}
+
+// Close waits for the running rewrite, then flushes the file to disk and closes it.
+func (a *Aof) Close() error {
+	a.rewriteMu.Lock()
+	defer a.rewriteMu.Unlock()
+	close(a.stop)
+	<-a.done
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.file.Sync(); err != nil {
+		return err
+	}
+	return a.file.Close()
+}
+
+func commandToResp(cmd [][]byte) *resp.ArrayData {
+	data := make([]resp.RedisData, 0, len(cmd))
+	for _, arg := range cmd {
+		data = append(data, resp.MakeBulkData(arg))
+	}
+	return resp.MakeArrayData(data)
+}
+
+// execWithAof executes a write command and appends it to aof
+func (m *MemDb) execWithAof(c *command, cmd [][]byte) resp.RedisData {
+	m.aof.cmdMu.RLock()
+	defer m.aof.cmdMu.RUnlock()
+	res := c.executor(m, cmd)
+	if _, isErr := res.(*resp.ErrorData); !isErr {
+		if err := m.aof.Append(m, c.keys(cmd), m.aofCommands(cmd, res)...); err != nil {
+			logger.Error("append aof error: ", err.Error())
+		}
+	}
+	return res
+}
+
+// aofCommands returns the commands which should be appended to aof after cmd is executed with result res.
+// It must be called right after cmd is executed, because it reads the ttl of the key which cmd has set.
+func (m *MemDb) aofCommands(cmd [][]byte, res resp.RedisData) [][][]byte {
+	switch strings.ToLower(string(cmd[0])) {
+	case "expire", "pexpire", "expireat", "pexpireat":
+		if intRes, ok := res.(*resp.IntData); ok && intRes.Data() == 0 {
+			return nil
+		}
+		return m.expireAtCommand(string(cmd[1]))
+	case "setex", "psetex":
+		setCmd := [][]byte{[]byte("set"), cmd[1], cmd[3]}
+		return append([][][]byte{setCmd}, m.expireAtCommand(string(cmd[1]))...)
+	case "set":
+		// drop the relative or absolute ttl and the get option, then append the ttl in milliseconds
+		setCmd := [][]byte{cmd[0], cmd[1], cmd[2]}
+		expire := false
+		for i := 3; i < len(cmd); i++ {
+			switch strings.ToLower(string(cmd[i])) {
+			case "ex", "px", "exat", "pxat":
+				expire = true
+				i++
+			case "get":
+			default:
+				setCmd = append(setCmd, cmd[i])
+			}
+		}
+		if !expire {
+			return [][][]byte{setCmd}
+		}
+		return append([][][]byte{setCmd}, m.expireAtCommand(string(cmd[1]))...)
+	case "getex":
+		// getex without option only reads the key
+		if len(cmd) == 2 {
+			return nil
+		}
+		if bulk, ok := res.(*resp.BulkData); ok && bulk.Data() == nil {
+			return nil
+		}
+		if cmds := m.expireAtCommand(string(cmd[1])); cmds != nil {
+			return cmds
+		}
+		return [][][]byte{{[]byte("persist"), cmd[1]}}
+	case "restore":
+		// an absttl in the past deletes the key instead of restoring it
+		if _, ok := m.db.Get(string(cmd[1])); !ok {
+			return [][][]byte{{[]byte("del"), cmd[1]}}
+		}
+		// restore without ttl, then append an absolute ttl
+		restoreCmd := [][]byte{cmd[0], cmd[1], []byte("0"), cmd[3]}
+		for i := 4; i < len(cmd); i++ {
+			if strings.ToLower(string(cmd[i])) != "absttl" {
+				restoreCmd = append(restoreCmd, cmd[i])
+			}
+		}
+		return append([][][]byte{restoreCmd}, m.expireAtCommand(string(cmd[1]))...)
+	case "sort":
+		// sort only writes with the store option
+		keys := sortStoreKeys(cmd)
+		if len(keys) == 0 {
+			return nil
+		}
+		// the BY and GET lookups may read other values when replayed, so record the stored list instead
+		return append([][][]byte{{[]byte("del"), []byte(keys[0])}}, m.rewriteCommands(keys[0])...)
+	case "spop":
+		// spop removes random members, so record the removed members instead
+		remCmd := [][]byte{[]byte("srem"), cmd[1]}
+		switch popped := res.(type) {
+		case *resp.BulkData:
+			if popped.Data() != nil {
+				remCmd = append(remCmd, popped.Data())
+			}
+		case *resp.ArrayData:
+			for _, member := range popped.Data() {
+				remCmd = append(remCmd, member.ByteData())
+			}
+		}
+		if len(remCmd) == 2 {
+			return nil
+		}
+		return [][][]byte{remCmd}
+	}
+	return [][][]byte{cmd}
+}
+
+// expireAtCommand returns a PEXPIREAT command holding the current ttl of key, or nothing if key has no ttl
+func (m *MemDb) expireAtCommand(key string) [][][]byte {
+	ttl, ok := m.ttlKeys.Get(key)
+	if !ok {
+		return nil
+	}
+	return [][][]byte{{[]byte("pexpireat"), []byte(key), []byte(strconv.FormatInt(ttl.(int64), 10))}}
+}
+
+// LoadAof replays the commands in the append only file at path on d, starting in db 0.
+// It is a no-op if the file does not exist.
+// d must not have an aof set, otherwise the replayed commands are appended again.
+func LoadAof(path string, d *Databases) error {
+	fl, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			logger.Info("aof file ", path, " not exist, skip loading")
+			return nil
+		}
+		return err
+	}
+	defer func() {
+		if err := fl.Close(); err != nil {
+			logger.Error("close aof file error: ", err.Error())
+		}
+	}()
+
+	replayed, selected := 0, 0
+	ch := resp.ParseStream(fl)
+	for parsedRes := range ch {
+		if parsedRes.Err != nil {
+			if parsedRes.Err == io.EOF {
+				break
+			}
+			logger.Error("parse aof file error: ", parsedRes.Err.Error())
+			continue
+		}
+		arrayData, ok := parsedRes.Data.(*resp.ArrayData)
+		if !ok {
+			logger.Error("aof file contains a non array data")
+			continue
+		}
+		cmd := arrayData.TOCommand()
+		if len(cmd) == 2 && strings.ToLower(string(cmd[0])) == "select" {
+			index, err := d.ParseIndex(cmd[1])
+			if err != nil {
+				return fmt.Errorf("aof file selects db %s: %w, %d databases are configured", cmd[1], err, d.Len())
+			}
+			selected = index
+			replayed++
+			continue
+		}
+		res := d.ExecCommand(selected, cmd)
+		if errData, ok := res.(*resp.ErrorData); ok {
+			logger.Error("replay aof command error: ", errData.Error())
+		}
+		replayed++
+	}
+	logger.Info("replay ", replayed, " commands from aof ", path)
+	return nil
+}
+
+func bgRewriteAof(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "bgrewriteaof" {
+		logger.Error("bgRewriteAof Function: cmdName is not bgrewriteaof")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) != 1 {
+		return resp.MakeErrorData("wrong number of arguments for 'bgrewriteaof' command")
+	}
+	if m.aof == nil {
+		return resp.MakeErrorData("error: append only file is disabled")
+	}
+	if err := m.aof.StartRewrite(); err != nil {
+		return resp.MakeErrorData("error: " + err.Error())
+	}
+	return resp.MakeStringData("Background append only file rewriting started")
+}
+
+func RegisterAofCommands() {
+	RegisterCommand("bgrewriteaof", bgRewriteAof)
+}
@@ -0,0 +1,217 @@
+package memdb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VincentFF/thinredis/config"
+)
+
+func init() {
+	config.Configures = &config.Config{
+		ShardNum: 100,
+	}
+	RegisterKeyCommands()
+	RegisterStringCommands()
+	RegisterListCommands()
+	RegisterSetCommands()
+	RegisterHashCommands()
+	RegisterZSetCommands()
+}
+
+func execCommands(m *MemDb, cmds ...string) {
+	for _, cmd := range cmds {
+		m.ExecCommand(bytes.Fields([]byte(cmd)))
+	}
+}
+
+func TestAof(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := NewAof(path, FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMemDb()
+	m.dbs.SetAof(aof)
+	execCommands(m,
+		"set a 1",
+		"incr a",
+		"set b 1 ex 100",
+		"setex c 100 1",
+		"rpush l 1 2 3",
+		"lpop l",
+		"sadd s 1 2 3",
+		"spop s",
+		"hset h f v",
+		"expire l 100",
+		"get a",
+		"lpush a 1", // wrong type error is not appended
+	)
+	if err = aof.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("get")) || bytes.Contains(data, []byte("lpush")) || bytes.Contains(data, []byte("spop")) {
+		t.Error("aof contains commands which should not be appended")
+	}
+
+	loaded := NewMemDb()
+	if err = LoadAof(path, loaded.dbs); err != nil {
+		t.Fatal(err)
+	}
+	if val, ok := loaded.db.Get("a"); !ok || !bytes.Equal(val.([]byte), []byte("2")) {
+		t.Error("replay string error")
+	}
+	for _, key := range []string{"b", "c", "l"} {
+		origin, _ := m.ttlKeys.Get(key)
+		ttl, ok := loaded.ttlKeys.Get(key)
+		if !ok || ttl.(int64) != origin.(int64) || ttl.(int64)-time.Now().UnixMilli() > 100000 {
+			t.Errorf("replay ttl of %s error", key)
+		}
+	}
+	if val, ok := loaded.db.Get("l"); !ok || val.(*List).Len != 2 {
+		t.Error("replay list error")
+	}
+	origin, _ := m.db.Get("s")
+	if val, ok := loaded.db.Get("s"); !ok || val.(*Set).Len() != 2 || !val.(*Set).IsSubset(origin.(*Set)) {
+		t.Error("replay spop error")
+	}
+	if val, ok := loaded.db.Get("h"); !ok || !bytes.Equal(val.(*Hash).Get("f"), []byte("v")) {
+		t.Error("replay hash error")
+	}
+}
+
+func TestAofRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := NewAof(path, FsyncNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMemDb()
+	m.dbs.SetAof(aof)
+	for i := 0; i < 100; i++ {
+		execCommands(m, "incr counter", "rpush list "+strconv.Itoa(i), "hset hash f"+strconv.Itoa(i%10)+" "+strconv.Itoa(i))
+	}
+	execCommands(m, "set tmp 1", "del tmp", "sadd set a b c", "expire set 100")
+	before, _ := os.Stat(path)
+	if err = aof.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Errorf("rewritten aof size %d is not smaller than %d", after.Size(), before.Size())
+	}
+
+	// write concurrently while rewriting
+	var wg sync.WaitGroup
+	started := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			if i == 100 {
+				close(started)
+			}
+			execCommands(m, "incr counter", "rpush list x")
+		}
+	}()
+	<-started
+	if err = aof.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if err = aof.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewMemDb()
+	if err = LoadAof(path, loaded.dbs); err != nil {
+		t.Fatal(err)
+	}
+	if val, ok := loaded.db.Get("counter"); !ok || !bytes.Equal(val.([]byte), []byte("1100")) {
+		t.Errorf("replay counter error")
+	}
+	if val, ok := loaded.db.Get("list"); !ok || val.(*List).Len != 1100 {
+		t.Error("replay list error")
+	}
+	if val, ok := loaded.db.Get("hash"); !ok || val.(*Hash).Len() != 10 || !bytes.Equal(val.(*Hash).Get("f9"), []byte("99")) {
+		t.Error("replay hash error")
+	}
+	if _, ok := loaded.db.Get("tmp"); ok {
+		t.Error("deleted key is rewritten")
+	}
+	if _, ok := loaded.ttlKeys.Get("set"); !ok {
+		t.Error("replay ttl error")
+	}
+}
+
+func TestAofRestorePastAbsTTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := NewAof(path, FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMemDb()
+	m.dbs.SetAof(aof)
+	execCommands(m, "set src v", "set k old")
+	payload := m.ExecCommand([][]byte{[]byte("dump"), []byte("src")}).ByteData()
+	past := strconv.FormatInt(time.Now().UnixMilli()-1000, 10)
+	m.ExecCommand([][]byte{[]byte("restore"), []byte("k"), []byte(past), payload, []byte("replace"), []byte("absttl")})
+	if _, ok := m.db.Get("k"); ok {
+		t.Fatal("restore with an absttl in the past keeps the key")
+	}
+	if err = aof.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewMemDb()
+	if err = LoadAof(path, loaded.dbs); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.db.Get("k"); ok {
+		t.Error("key restored with an absttl in the past exists after replay")
+	}
+}
+
+func TestAofSortStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := NewAof(path, FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMemDb()
+	m.dbs.SetAof(aof)
+	// the weights and the GET values expire before the aof is replayed
+	execCommands(m, "rpush src a b c", "set w_a 3", "set w_b 1", "set w_c 2", "set g_a x", "set g_b y", "set g_c z",
+		"pexpire w_a 50", "pexpire w_b 50", "pexpire w_c 50", "pexpire g_a 50", "pexpire g_b 50", "pexpire g_c 50",
+		"sort src by w_* get g_* store dst", "sort nokey store empty")
+	expect := strings.Join(sortReply(t, m, "lrange dst 0 -1"), " ")
+	if expect != "y z x" {
+		t.Fatalf("sort store stores %q, expect y z x", expect)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err = aof.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewMemDb()
+	if err = LoadAof(path, loaded.dbs); err != nil {
+		t.Fatal(err)
+	}
+	if res := strings.Join(sortReply(t, loaded, "lrange dst 0 -1"), " "); res != expect {
+		t.Errorf("dst is %q after replay, expect %q", res, expect)
+	}
+	if _, ok := loaded.db.Get("empty"); ok {
+		t.Error("empty sort result is stored after replay")
+	}
+}
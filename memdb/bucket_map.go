@@ -0,0 +1,193 @@
+package memdb
+
+import "math/rand"
+
+const (
+	bucketMapLoad    = 8  // average number of keys per bucket before the buckets are doubled
+	bucketMapMaxBits = 24 // at most 1<<24 buckets
+)
+
+// bucketMap is the map encoding of a set or hash.
+// The keys are split into buckets by the top bits of their scan hashes, so the buckets are ordered by hash
+// and SSCAN and HSCAN only walk the buckets from the cursor on, see scan.go.
+// The buckets are doubled once they hold bucketMapLoad keys on average, bucket i is split into 2i and 2i+1,
+// and halved once they hold less than a quarter of that, buckets 2i and 2i+1 are merged into i.
+// Both keep the order of hashes, so a scan cursor stays valid.
+type bucketMap[V any] struct {
+	buckets   []map[string]V // a nil bucket holds no keys
+	bits      int            // len(buckets) is 1<<bits
+	len       int
+	maxBucket int // not less than the number of keys of any bucket, used by Random
+}
+
+func newBucketMap[V any](hint int) *bucketMap[V] {
+	b := &bucketMap[V]{maxBucket: 1}
+	for b.bits < bucketMapMaxBits && hint > bucketMapLoad<<b.bits {
+		b.bits++
+	}
+	b.buckets = make([]map[string]V, 1<<b.bits)
+	return b
+}
+
+// bucketOf returns the bucket holding the keys of hash h when there are 1<<bits buckets
+func bucketOf(h uint32, bits int) int {
+	if bits == 0 {
+		return 0
+	}
+	return int(h >> (32 - bits))
+}
+
+// bucketStart returns the smallest hash of bucket i when there are 1<<bits buckets
+func bucketStart(i, bits int) uint32 {
+	if bits == 0 {
+		return 0
+	}
+	return uint32(i) << (32 - bits)
+}
+
+func (b *bucketMap[V]) Len() int {
+	return b.len
+}
+
+func (b *bucketMap[V]) Get(key string) (V, bool) {
+	val, ok := b.buckets[bucketOf(scanHash(key), b.bits)][key]
+	return val, ok
+}
+
+// Set sets the value of key, it returns true if key is new
+func (b *bucketMap[V]) Set(key string, val V) bool {
+	i := bucketOf(scanHash(key), b.bits)
+	if b.buckets[i] == nil {
+		b.buckets[i] = make(map[string]V)
+	}
+	_, ok := b.buckets[i][key]
+	b.buckets[i][key] = val
+	if ok {
+		return false
+	}
+	b.len++
+	if len(b.buckets[i]) > b.maxBucket {
+		b.maxBucket = len(b.buckets[i])
+	}
+	if b.bits < bucketMapMaxBits && b.len > bucketMapLoad<<b.bits {
+		b.resize(b.bits + 1)
+	}
+	return true
+}
+
+// Delete removes key, it returns true if key existed
+func (b *bucketMap[V]) Delete(key string) bool {
+	i := bucketOf(scanHash(key), b.bits)
+	if _, ok := b.buckets[i][key]; !ok {
+		return false
+	}
+	delete(b.buckets[i], key)
+	b.len--
+	if b.bits >= 2 && b.len < bucketMapLoad<<(b.bits-2) {
+		b.resize(b.bits - 1)
+	}
+	return true
+}
+
+// resize rebuilds the buckets with 1<<bits buckets
+func (b *bucketMap[V]) resize(bits int) {
+	buckets := make([]map[string]V, 1<<bits)
+	b.maxBucket = 1
+	for _, bucket := range b.buckets {
+		for key, val := range bucket {
+			i := bucketOf(scanHash(key), bits)
+			if buckets[i] == nil {
+				buckets[i] = make(map[string]V)
+			}
+			buckets[i][key] = val
+			if len(buckets[i]) > b.maxBucket {
+				b.maxBucket = len(buckets[i])
+			}
+		}
+	}
+	b.buckets = buckets
+	b.bits = bits
+}
+
+// Range calls fn for every key and value until fn returns false, the buckets are walked in the order of hashes
+func (b *bucketMap[V]) Range(fn func(key string, val V) bool) {
+	for _, bucket := range b.buckets {
+		for key, val := range bucket {
+			if !fn(key, val) {
+				return
+			}
+		}
+	}
+}
+
+// Random returns a random key and its value, all keys are picked with the same probability. b must not be empty.
+// It picks a random bucket and a random slot of maxBucket slots in it, until the slot holds a key.
+func (b *bucketMap[V]) Random() (string, V) {
+	for {
+		bucket := b.buckets[rand.Intn(len(b.buckets))]
+		slot := rand.Intn(b.maxBucket)
+		if slot >= len(bucket) {
+			continue
+		}
+		for key, val := range bucket {
+			if slot == 0 {
+				return key, val
+			}
+			slot--
+		}
+	}
+}
+
+// RandomKeys returns random keys.
+// if count > 0, return min(len, count) distinct keys
+// if count < 0, return exactly -count keys which may repeat
+func (b *bucketMap[V]) RandomKeys(count int) []string {
+	res := make([]string, 0)
+	if count == 0 || b.len == 0 {
+		return res
+	}
+	if count < 0 {
+		for len(res) < -count {
+			key, _ := b.Random()
+			res = append(res, key)
+		}
+		return res
+	}
+	if count*2 > b.len {
+		// most keys are returned, shuffling all keys is cheaper than picking them one by one
+		b.Range(func(key string, _ V) bool {
+			res = append(res, key)
+			return true
+		})
+		rand.Shuffle(len(res), func(i, j int) { res[i], res[j] = res[j], res[i] })
+		if count < len(res) {
+			res = res[:count]
+		}
+		return res
+	}
+	picked := make(map[string]struct{}, count)
+	for len(res) < count {
+		key, _ := b.Random()
+		if _, ok := picked[key]; ok {
+			continue
+		}
+		picked[key] = struct{}{}
+		res = append(res, key)
+	}
+	return res
+}
+
+// Copy returns a copy of b, copy is called for every value
+func (b *bucketMap[V]) Copy(copy func(val V) V) *bucketMap[V] {
+	res := &bucketMap[V]{buckets: make([]map[string]V, len(b.buckets)), bits: b.bits, len: b.len, maxBucket: b.maxBucket}
+	for i, bucket := range b.buckets {
+		if bucket == nil {
+			continue
+		}
+		res.buckets[i] = make(map[string]V, len(bucket))
+		for key, val := range bucket {
+			res.buckets[i][key] = copy(val)
+		}
+	}
+	return res
+}
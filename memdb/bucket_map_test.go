@@ -0,0 +1,91 @@
+package memdb
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestBucketMapShrink(t *testing.T) {
+	table := newBucketMap[void](0)
+	for i := 0; i < 10000; i++ {
+		table.Set("m"+strconv.Itoa(i), void{})
+	}
+	grown := table.bits
+
+	// start a scan, then delete most keys, the cursor stays valid when the buckets are halved
+	seen := make(map[string]int)
+	members, cursor := scanMembers(table, 0, 100)
+	for _, member := range members {
+		seen[member]++
+	}
+	for i := 100; i < 10000; i++ {
+		table.Delete("m" + strconv.Itoa(i))
+	}
+	if table.bits >= grown-2 || table.Len() != 100 {
+		t.Fatalf("table of %d members keeps %d buckets, it had %d buckets with 10000 members", table.Len(), 1<<table.bits, 1<<grown)
+	}
+	if table.Len() < bucketMapLoad<<(table.bits-2) {
+		t.Errorf("table of %d members has %d buckets", table.Len(), 1<<table.bits)
+	}
+	for cursor != 0 {
+		members, cursor = scanMembers(table, cursor, 10)
+		for _, member := range members {
+			seen[member]++
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if count := seen["m"+strconv.Itoa(i)]; count != 1 {
+			t.Errorf("m%d is returned %d times, expect 1", i, count)
+		}
+	}
+}
+
+func TestBucketMapRandom(t *testing.T) {
+	table := newBucketMap[void](0)
+	for i := 0; i < 200; i++ {
+		table.Set("m"+strconv.Itoa(i), void{})
+	}
+	// 1000 picks of every key are expected, 800 to 1200 are about 6 standard deviations
+	check := func(name string, picks map[string]int) {
+		for i := 0; i < 200; i++ {
+			if count := picks["m"+strconv.Itoa(i)]; count < 800 || count > 1200 {
+				t.Errorf("%s picks m%d %d times, expect about 1000", name, i, count)
+			}
+		}
+	}
+	picks := make(map[string]int)
+	for i := 0; i < 200000; i++ {
+		key, _ := table.Random()
+		picks[key]++
+	}
+	check("Random", picks)
+
+	picks = make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		keys := table.RandomKeys(20)
+		for _, key := range keys {
+			picks[key]++
+		}
+		if len(keys) != 20 {
+			t.Fatalf("RandomKeys returns %d keys, expect 20", len(keys))
+		}
+	}
+	check("RandomKeys", picks)
+
+	for _, count := range []int{150, 300} {
+		distinct := make(map[string]struct{})
+		for _, key := range table.RandomKeys(count) {
+			distinct[key] = struct{}{}
+		}
+		expect := count
+		if expect > 200 {
+			expect = 200
+		}
+		if len(distinct) != expect {
+			t.Errorf("RandomKeys(%d) returns %d distinct keys, expect %d", count, len(distinct), expect)
+		}
+	}
+	if keys := table.RandomKeys(-500); len(keys) != 500 {
+		t.Errorf("RandomKeys(-500) returns %d keys, expect 500", len(keys))
+	}
+}
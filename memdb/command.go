@@ -9,6 +9,14 @@ type cmdExecutor func(m *MemDb, cmd [][]byte) resp.RedisData
 
 type command struct {
 	executor cmdExecutor
+	isWrite  bool // write commands may modify the db and are appended to aof
+	// keys modified by a write command are at positions firstKey, firstKey+keyStep, ... lastKey.
+	// A negative lastKey counts from the end of the command.
+	firstKey int
+	lastKey  int
+	keyStep  int
+	// keysOf returns the keys modified by cmd instead of the positions, for the commands whose keys are given by options
+	keysOf func(cmd [][]byte) []string
 }
 
 func RegisterCommand(cmdName string, executor cmdExecutor) {
@@ -16,3 +24,40 @@ func RegisterCommand(cmdName string, executor cmdExecutor) {
 		executor: executor,
 	}
 }
+
+// RegisterWriteCommand registers a command which may modify the db.
+// firstKey, lastKey and keyStep give the positions of the keys it modifies.
+func RegisterWriteCommand(cmdName string, executor cmdExecutor, firstKey, lastKey, keyStep int) {
+	CmdTable[cmdName] = &command{
+		executor: executor,
+		isWrite:  true,
+		firstKey: firstKey,
+		lastKey:  lastKey,
+		keyStep:  keyStep,
+	}
+}
+
+// RegisterWriteCommandKeys registers a command which may modify the db, keysOf returns the keys it modifies
+func RegisterWriteCommandKeys(cmdName string, executor cmdExecutor, keysOf func(cmd [][]byte) []string) {
+	CmdTable[cmdName] = &command{
+		executor: executor,
+		isWrite:  true,
+		keysOf:   keysOf,
+	}
+}
+
+// keys returns the keys which cmd may modify
+func (c *command) keys(cmd [][]byte) []string {
+	if c.keysOf != nil {
+		return c.keysOf(cmd)
+	}
+	last := c.lastKey
+	if last < 0 {
+		last = len(cmd) + last
+	}
+	keys := make([]string, 0)
+	for i := c.firstKey; i <= last && i < len(cmd); i += c.keyStep {
+		keys = append(keys, string(cmd[i]))
+	}
+	return keys
+}
@@ -1,7 +1,9 @@
 package memdb
 
 import (
+	"math/rand"
 	"sync"
+	"sync/atomic"
 
 	"github.com/VincentFF/thinredis/util"
 )
@@ -13,8 +15,8 @@ const MaxConSize = int(1<<31 - 1)
 // it supports maximum table size = MaxConSize
 type ConcurrentMap struct {
 	table []*shard
-	size  int // table size
-	count int // total number of keys
+	size  int          // table size
+	count atomic.Int64 // total number of keys
 }
 
 type shard struct {
@@ -30,7 +32,6 @@ func NewConcurrentMap(size int) *ConcurrentMap {
 	m := &ConcurrentMap{
 		table: make([]*shard, size),
 		size:  size,
-		count: 0,
 	}
 	for i := 0; i < size; i++ {
 		m.table[i] = &shard{mp: make(map[string]any), rwMu: &sync.RWMutex{}}
@@ -61,7 +62,7 @@ func (m *ConcurrentMap) Set(key string, value any) int {
 	defer shard.rwMu.Unlock()
 
 	if _, ok := shard.mp[key]; !ok {
-		m.count++
+		m.count.Add(1)
 		added = 1
 	}
 	shard.mp[key] = value
@@ -88,7 +89,7 @@ func (m *ConcurrentMap) SetIfNotExist(key string, value any) int {
 	defer shard.rwMu.Unlock()
 
 	if _, ok := shard.mp[key]; !ok {
-		m.count++
+		m.count.Add(1)
 		shard.mp[key] = value
 		return 1
 	}
@@ -113,30 +114,119 @@ func (m *ConcurrentMap) Delete(key string) int {
 
 	if _, ok := shard.mp[key]; ok {
 		delete(shard.mp, key)
-		m.count--
+		m.count.Add(-1)
 		return 1
 	}
 	return 0
 }
 
 func (m *ConcurrentMap) Len() int {
-	return m.count
+	return int(m.count.Load())
 }
 
-func (m *ConcurrentMap) Clear() {
-	*m = *NewConcurrentMap(m.size)
+// Clear removes all keys shard by shard and returns the old shard maps, so that the caller can release them later.
+// Keys set while the shards are walked may be kept.
+func (m *ConcurrentMap) Clear() []map[string]any {
+	old := make([]map[string]any, 0, len(m.table))
+	for _, shard := range m.table {
+		shard.rwMu.Lock()
+		if len(shard.mp) > 0 {
+			old = append(old, shard.mp)
+			m.count.Add(int64(-len(shard.mp)))
+			shard.mp = make(map[string]any)
+		}
+		shard.rwMu.Unlock()
+	}
+	return old
 }
 
 func (m *ConcurrentMap) Keys() []string {
-	keys := make([]string, m.count)
-	i := 0
+	// count may change while shards are walked, so it is only used as a capacity hint
+	keys := make([]string, 0, m.Len())
 	for _, shard := range m.table {
 		shard.rwMu.RLock()
 		for key := range shard.mp {
-			keys[i] = key
-			i++
+			keys = append(keys, key)
 		}
 		shard.rwMu.RUnlock()
 	}
 	return keys
 }
+
+// ShardNum returns the number of shards in the table
+func (m *ConcurrentMap) ShardNum() int {
+	return m.size
+}
+
+// ShardKeys returns the keys of the shard at pos
+func (m *ConcurrentMap) ShardKeys(pos int) []string {
+	shard := m.table[pos]
+	shard.rwMu.RLock()
+	defer shard.rwMu.RUnlock()
+
+	keys := make([]string, 0, len(shard.mp))
+	for key := range shard.mp {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// SampleShard returns at most count keys of the shard at pos.
+// Map iteration starts at a random position, so the keys are a cheap random sample of the shard.
+func (m *ConcurrentMap) SampleShard(pos, count int) []string {
+	shard := m.table[pos]
+	shard.rwMu.RLock()
+	defer shard.rwMu.RUnlock()
+
+	keys := make([]string, 0, count)
+	for key := range shard.mp {
+		if len(keys) == count {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// RandomKey returns a key chosen uniformly from all keys, or false if m is empty.
+// The rank of the key is drawn first, then the shards are skipped by their sizes, so only a single shard is iterated.
+func (m *ConcurrentMap) RandomKey() (string, bool) {
+	count := m.Len()
+	if count == 0 {
+		return "", false
+	}
+	rank := rand.Intn(count)
+	for _, shard := range m.table {
+		shard.rwMu.RLock()
+		if rank >= len(shard.mp) {
+			rank -= len(shard.mp)
+			shard.rwMu.RUnlock()
+			continue
+		}
+		for key := range shard.mp {
+			if rank == 0 {
+				shard.rwMu.RUnlock()
+				return key, true
+			}
+			rank--
+		}
+		shard.rwMu.RUnlock()
+	}
+	// keys are deleted while the shards are walked
+	return "", false
+}
+
+// Range calls fn for every key and value until fn returns false.
+// A shard is read locked while it is walked, so fn must not modify m.
+func (m *ConcurrentMap) Range(fn func(key string, val any) bool) {
+	for _, shard := range m.table {
+		shard.rwMu.RLock()
+		for key, val := range shard.mp {
+			if !fn(key, val) {
+				shard.rwMu.RUnlock()
+				return
+			}
+		}
+		shard.rwMu.RUnlock()
+	}
+}
@@ -0,0 +1,216 @@
+package memdb
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/VincentFF/thinredis/config"
+	"github.com/VincentFF/thinredis/logger"
+	"github.com/VincentFF/thinredis/pubsub"
+	"github.com/VincentFF/thinredis/resp"
+)
+
+// databases.go implements the numbered databases of the server and the SWAPDB command.
+// Every database is a MemDb, a client selects one by its index. The databases share the append only file,
+// the pub/sub hub and the maxmemory limit, which applies to the memory used by all of them.
+// SWAPDB swaps the MemDbs at two indexes, so the keys of a MemDb never move, only its index changes.
+
+// Databases holds the databases of the server
+// Commands are executed under the read lock of mu, SWAPDB takes the write lock, so the index of a MemDb
+// never changes while a command is running
+// usedMemory is the memory used by the keys of all databases, evictMu serializes the eviction
+type Databases struct {
+	mu  sync.RWMutex
+	dbs atomic.Pointer[[]*MemDb]
+	hub *pubsub.Hub
+	aof *Aof
+
+	usedMemory atomic.Int64
+	evictMu    sync.Mutex
+
+	// clientsMemory reports the number of clients and the memory of their buffers, it is set by the server
+	clientsMemory func() (int, int64)
+}
+
+// NewDatabases returns n empty databases, at least one
+func NewDatabases(n int) *Databases {
+	if n < 1 {
+		n = 1
+	}
+	d := &Databases{hub: pubsub.NewHub()}
+	dbs := make([]*MemDb, n)
+	for i := range dbs {
+		dbs[i] = newMemDb(d, i)
+	}
+	d.dbs.Store(&dbs)
+	return d
+}
+
+// list returns the databases in index order
+func (d *Databases) list() []*MemDb {
+	return *d.dbs.Load()
+}
+
+// Len returns the number of databases
+func (d *Databases) Len() int {
+	return len(d.list())
+}
+
+// Db returns the database at index
+func (d *Databases) Db(index int) *MemDb {
+	return d.list()[index]
+}
+
+// Hub returns the pub/sub hub which keyspace events of all databases are published to
+func (d *Databases) Hub() *pubsub.Hub {
+	return d.hub
+}
+
+// ParseIndex parses a db index argument, such as the index of SELECT and MOVE
+func (d *Databases) ParseIndex(arg []byte) (int, error) {
+	index, err := strconv.Atoi(string(arg))
+	if err != nil {
+		return 0, errors.New("value is not an integer or out of range")
+	}
+	if index < 0 || index >= d.Len() {
+		return 0, errors.New("DB index is out of range")
+	}
+	return index, nil
+}
+
+// ExecCommand executes cmd on the database at index
+func (d *Databases) ExecCommand(index int, cmd [][]byte) resp.RedisData {
+	if len(cmd) == 0 {
+		return nil
+	}
+	// SWAPDB changes the indexes, so it runs alone instead of in a database
+	if strings.ToLower(string(cmd[0])) == "swapdb" {
+		return d.swapDb(cmd)
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.Db(index).ExecCommand(cmd)
+}
+
+// SetAof makes all databases append their write commands to aof
+func (d *Databases) SetAof(aof *Aof) {
+	aof.dbs = d
+	d.aof = aof
+	for _, m := range d.list() {
+		m.aof = aof
+	}
+}
+
+// SetClientsMemory sets fn to report the number of clients and the memory used by their buffers
+func (d *Databases) SetClientsMemory(fn func() (int, int64)) {
+	d.clientsMemory = fn
+}
+
+// StartActiveExpire starts the background expire cycle of every database, see MemDb.StartActiveExpire
+func (d *Databases) StartActiveExpire(cpuPercent int) {
+	for _, m := range d.list() {
+		m.StartActiveExpire(cpuPercent)
+	}
+}
+
+// Close stops the active expiration and flushes and closes the append only file if it is set.
+// Write commands executed after Close are not persisted any more.
+func (d *Databases) Close() error {
+	for _, m := range d.list() {
+		m.stopActiveExpire()
+	}
+	if d.aof == nil {
+		return nil
+	}
+	return d.aof.Close()
+}
+
+// sum returns the sum of fn over all databases
+func (d *Databases) sum(fn func(m *MemDb) int64) int64 {
+	total := int64(0)
+	for _, m := range d.list() {
+		total += fn(m)
+	}
+	return total
+}
+
+// lockKeys locks key of m and otherKey of other, the database with the lower index first.
+// It returns the function which unlocks them.
+func lockKeys(m *MemDb, key string, other *MemDb, otherKey string) func() {
+	first, firstKey, second, secondKey := m, key, other, otherKey
+	if other.index.Load() < m.index.Load() {
+		first, firstKey, second, secondKey = other, otherKey, m, key
+	}
+	first.locks.Lock(firstKey)
+	second.locks.Lock(secondKey)
+	return func() {
+		second.locks.UnLock(secondKey)
+		first.locks.UnLock(firstKey)
+	}
+}
+
+// swapDb handles SWAPDB index1 index2. Clients which selected a database see the keys of the other one afterwards.
+func (d *Databases) swapDb(cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "swapdb" {
+		logger.Error("swapDb Function: cmdName is not swapdb")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) != 3 {
+		return resp.MakeErrorData("wrong number of arguments for 'swapdb' command")
+	}
+	i, err := d.ParseIndex(cmd[1])
+	if err != nil {
+		return resp.MakeErrorData("error: invalid first DB index, " + err.Error())
+	}
+	j, err := d.ParseIndex(cmd[2])
+	if err != nil {
+		return resp.MakeErrorData("error: invalid second DB index, " + err.Error())
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.aof != nil {
+		d.aof.cmdMu.RLock()
+		defer d.aof.cmdMu.RUnlock()
+	}
+	swapped := append([]*MemDb{}, d.list()...)
+	swapped[i], swapped[j] = swapped[j], swapped[i]
+	swapped[i].index.Store(int64(i))
+	swapped[j].index.Store(int64(j))
+	d.dbs.Store(&swapped)
+	if d.aof != nil {
+		if err = d.aof.AppendSwap(cmd); err != nil {
+			logger.Error("append swapdb to aof error: ", err.Error())
+		}
+	}
+	return resp.MakeStringData("OK")
+}
+
+// newMemDb returns the empty database at index of d
+func newMemDb(d *Databases, index int) *MemDb {
+	// the classes are validated when the config is loaded
+	notifyFlags, _ := ParseNotifyClasses(config.Configures.NotifyKeyspaceEvents)
+	samples := config.Configures.MaxMemorySamples
+	if samples <= 0 {
+		samples = memorySamples
+	}
+	m := &MemDb{
+		db:               NewConcurrentMap(config.Configures.ShardNum),
+		ttlKeys:          NewConcurrentMap(config.Configures.ShardNum),
+		expires:          newExpireIndex(),
+		locks:            NewLocks(config.Configures.ShardNum * 2),
+		dbs:              d,
+		aof:              d.aof,
+		hub:              d.hub,
+		notifyFlags:      notifyFlags,
+		meta:             NewConcurrentMap(config.Configures.ShardNum),
+		maxMemory:        config.Configures.MaxMemory,
+		maxMemoryPolicy:  config.Configures.MaxMemoryPolicy,
+		maxMemorySamples: samples,
+	}
+	m.index.Store(int64(index))
+	return m
+}
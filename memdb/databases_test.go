@@ -0,0 +1,157 @@
+package memdb
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/VincentFF/thinredis/resp"
+)
+
+// execIn executes cmds in the database at index of d
+func execIn(d *Databases, index int, cmds ...string) resp.RedisData {
+	var res resp.RedisData
+	for _, cmd := range cmds {
+		res = d.ExecCommand(index, bytes.Fields([]byte(cmd)))
+	}
+	return res
+}
+
+func TestSwapDb(t *testing.T) {
+	d := NewDatabases(4)
+	execIn(d, 0, "set a 1", "set b 1")
+	execIn(d, 2, "set c 1")
+	if size := execIn(d, 0, "dbsize").(*resp.IntData).Data(); size != 2 {
+		t.Errorf("dbsize of db 0 is %d, expect 2", size)
+	}
+
+	db0 := d.Db(0)
+	if res := execIn(d, 1, "swapdb 0 2"); !bytes.Equal(res.ToBytes(), []byte("+OK\r\n")) {
+		t.Fatalf("swapdb replies %q", res.ToBytes())
+	}
+	if d.Db(2) != db0 || db0.index.Load() != 2 {
+		t.Error("databases are not swapped")
+	}
+	if size := execIn(d, 0, "dbsize").(*resp.IntData).Data(); size != 1 {
+		t.Errorf("dbsize of db 0 is %d after swapdb, expect 1", size)
+	}
+	if res := execIn(d, 0, "swapdb 0 4"); !bytes.HasPrefix(res.ToBytes(), []byte("-error: invalid second DB index")) {
+		t.Errorf("swapdb with an out of range index replies %q", res.ToBytes())
+	}
+
+	info := string(infoServer(d.Db(0), [][]byte{[]byte("info"), []byte("keyspace")}).ByteData())
+	if !strings.Contains(info, "db0:keys=1,expires=0") || !strings.Contains(info, "db2:keys=2,expires=0") || strings.Contains(info, "db1") {
+		t.Errorf("keyspace info is %q", info)
+	}
+}
+
+func TestMoveCopyDb(t *testing.T) {
+	d := NewDatabases(2)
+	execIn(d, 0, "set a 1 ex 100", "set b 1")
+	execIn(d, 1, "set b 2")
+
+	if res := execIn(d, 0, "move a 1").(*resp.IntData).Data(); res != 1 {
+		t.Errorf("move replies %d, expect 1", res)
+	}
+	if execIn(d, 0, "exists a").(*resp.IntData).Data() != 0 || execIn(d, 1, "ttl a").(*resp.IntData).Data() <= 0 {
+		t.Error("key is not moved with its ttl")
+	}
+	if res := execIn(d, 0, "move b 1").(*resp.IntData).Data(); res != 0 {
+		t.Errorf("move to an existing key replies %d, expect 0", res)
+	}
+	if res := execIn(d, 0, "move b 0"); !bytes.Equal(res.ToBytes(), []byte("-error: source and destination objects are the same\r\n")) {
+		t.Errorf("move to the same db replies %q", res.ToBytes())
+	}
+
+	if res := execIn(d, 0, "copy b b db 1 replace").(*resp.IntData).Data(); res != 1 {
+		t.Errorf("copy to another db replies %d, expect 1", res)
+	}
+	if val := execIn(d, 1, "get b").ByteData(); string(val) != "1" {
+		t.Errorf("copied value is %q, expect 1", val)
+	}
+	if d.usedMemory.Load() != d.Db(0).usedMemory.Load()+d.Db(1).usedMemory.Load() {
+		t.Error("memory of databases doesn't sum to the total")
+	}
+
+	execIn(d, 1, "flushdb")
+	if d.Db(1).db.Len() != 0 || d.Db(0).db.Len() != 1 {
+		t.Error("flushdb removes keys of another db")
+	}
+	execIn(d, 0, "set c 1", "flushall")
+	if d.Db(0).db.Len() != 0 || d.usedMemory.Load() != 0 {
+		t.Error("flushall doesn't remove keys of all databases")
+	}
+}
+
+func TestDatabasesAof(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := NewAof(path, FsyncNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDatabases(3)
+	d.SetAof(aof)
+	execIn(d, 0, "set a 0", "rpush l 1 2")
+	execIn(d, 1, "set a 1", "move l 1")
+	execIn(d, 2, "set a 2", "swapdb 0 2", "set b 2")
+
+	// swaps and flushes made during a rewrite are merged before the keys
+	aof.mu.Lock()
+	aof.touched, aof.flushed = make(map[*MemDb]map[string]struct{}), make(map[*MemDb]bool)
+	aof.swaps = [][][]byte{}
+	aof.mu.Unlock()
+	execIn(d, 1, "swapdb 1 2", "incr a", "flushdb", "set c 1")
+	aof.mu.Lock()
+	if len(aof.swaps) != 1 || !aof.flushed[d.Db(1)] || len(aof.touched[d.Db(1)]) != 1 {
+		t.Error("swapdb and flushdb are not recorded for the running rewrite")
+	}
+	aof.touched, aof.flushed, aof.swaps = nil, nil, nil
+	aof.mu.Unlock()
+	if err = aof.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	execIn(d, 2, "incr a")
+	if err = aof.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewDatabases(3)
+	if err = LoadAof(path, loaded); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		expect, got := d.Db(i).db.Keys(), loaded.Db(i).db.Keys()
+		if len(expect) != len(got) {
+			t.Errorf("db %d has keys %v after replay, expect %v", i, got, expect)
+		}
+		for _, key := range expect {
+			if !bytes.Equal(execIn(d, i, "dump "+key).ToBytes(), execIn(loaded, i, "dump "+key).ToBytes()) {
+				t.Errorf("key %s of db %d is different after replay", key, i)
+			}
+		}
+	}
+	if err = LoadAof(path, NewDatabases(2)); err == nil {
+		t.Error("aof selecting a db out of range is loaded")
+	}
+}
+
+func TestDatabasesSnapshot(t *testing.T) {
+	d := NewDatabases(3)
+	execIn(d, 0, "set a 0")
+	execIn(d, 2, "set a 2", "sadd s x")
+	path := filepath.Join(t.TempDir(), "dump.tdb")
+	if err := SaveSnapshot(path, d); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewDatabases(3)
+	if err := LoadSnapshot(path, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Db(0).db.Len() != 1 || loaded.Db(1).db.Len() != 0 || loaded.Db(2).db.Len() != 2 {
+		t.Error("keys are not loaded into their databases")
+	}
+	if err := LoadSnapshot(path, NewDatabases(2)); err == nil {
+		t.Error("snapshot of a db out of range is loaded")
+	}
+}
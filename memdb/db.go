@@ -2,29 +2,59 @@ package memdb
 
 import (
 	"strings"
+	"sync/atomic"
 	"time"
 
-	"github.com/VincentFF/thinredis/config"
 	"github.com/VincentFF/thinredis/logger"
+	"github.com/VincentFF/thinredis/pubsub"
 	"github.com/VincentFF/thinredis/resp"
 )
 
-// MemDb is the memory cache database
+// MemDb is the memory cache database, it is one of the numbered databases in dbs
 // All key:value pairs are stored in db
-// All ttl keys are stored in ttlKeys
+// All ttl keys are stored in ttlKeys, and indexed by deadline in expires
 // locks is used to lock a key for db to ensure some atomic operations
+// Successful write commands are appended to aof if it is set
+// Expired keys are deleted in background after StartActiveExpire is called
+// Keyspace events enabled by notifyFlags are published to hub
+// Big values removed by UNLINK and FLUSHALL ASYNC are freed in background
+// meta holds the estimated memory and access statistics of keys, keys are evicted by them when the usedMemory of all databases exceeds maxMemory
 type MemDb struct {
 	db      *ConcurrentMap
 	ttlKeys *ConcurrentMap
+	expires *expireIndex
 	locks   *Locks
+	dbs     *Databases
+	index   atomic.Int64 // index of the database in dbs, it is changed by SWAPDB
+	aof     *Aof
+
+	hub         *pubsub.Hub
+	notifyFlags int
+
+	stats      expireStats
+	stopExpire chan struct{}
+	expireDone chan struct{}
+
+	meta             *ConcurrentMap
+	usedMemory       atomic.Int64
+	typeMemory       [kindNum]atomic.Int64 // used memory of each value type
+	maxMemory        int64
+	maxMemoryPolicy  string
+	maxMemorySamples int
+	evictedKeys      atomic.Int64
+
+	lazyfreePending atomic.Int64 // objects waiting for the background freer
+	lazyfreed       atomic.Int64 // objects freed by the background freer
 }
 
+// NewMemDb returns a single database, which is db 0 of its own Databases
 func NewMemDb() *MemDb {
-	return &MemDb{
-		db:      NewConcurrentMap(config.Configures.ShardNum),
-		ttlKeys: NewConcurrentMap(config.Configures.ShardNum),
-		locks:   NewLocks(config.Configures.ShardNum * 2),
-	}
+	return NewDatabases(1).Db(0)
+}
+
+// Hub returns the pub/sub hub which keyspace events are published to
+func (m *MemDb) Hub() *pubsub.Hub {
+	return m.hub
 }
 
 func (m *MemDb) ExecCommand(cmd [][]byte) resp.RedisData {
@@ -37,8 +67,20 @@ func (m *MemDb) ExecCommand(cmd [][]byte) resp.RedisData {
 	if !ok {
 		res = resp.MakeErrorData("error: unsupported command")
 	} else {
-		execFunc := command.executor
-		res = execFunc(m, cmd)
+		if command.isWrite && !m.freeMemory() && !oomAllowedCommands[cmdName] {
+			return resp.MakeErrorData("OOM command not allowed when used memory > 'maxmemory'.")
+		}
+		if command.isWrite && m.aof != nil {
+			res = m.execWithAof(command, cmd)
+		} else {
+			execFunc := command.executor
+			res = execFunc(m, cmd)
+		}
+		if command.isWrite {
+			for _, key := range command.keys(cmd) {
+				m.accountKey(key)
+			}
+		}
 	}
 	return res
 }
@@ -47,22 +89,28 @@ func (m *MemDb) ExecCommand(cmd [][]byte) resp.RedisData {
 // return false if key is expired, else true.
 // Attention: Don't lock this function because it has called locks.Lock(key) for atomic deleting expired key.
 // Otherwise, it will cause a deadlock.
+// An alive key is recorded as accessed for the lru and lfu eviction.
 func (m *MemDb) CheckTTL(key string) bool {
+	if !m.alive(key) {
+		return false
+	}
+	m.touch(key)
+	return true
+}
+
+// alive deletes key if it is expired like CheckTTL, but doesn't record an access of key.
+// It is used by commands which walk through the keys, such as KEYS.
+func (m *MemDb) alive(key string) bool {
 	ttl, ok := m.ttlKeys.Get(key)
 	if !ok {
 		return true
 	}
 	ttlTime := ttl.(int64)
-	now := time.Now().Unix()
+	now := time.Now().UnixMilli()
 	if ttlTime > now {
 		return true
 	}
-
-	m.locks.Lock(key)
-	defer m.locks.UnLock(key)
-	m.db.Delete(key)
-	m.ttlKeys.Delete(key)
-	return false
+	return !m.expireIfNeeded(key)
 }
 
 // SetTTL set ttl for key
@@ -74,9 +122,11 @@ func (m *MemDb) SetTTL(key string, value int64) int {
 		return 0
 	}
 	m.ttlKeys.Set(key, value)
+	m.expires.set(key, value)
 	return 1
 }
 
 func (m *MemDb) DelTTL(key string) int {
+	m.expires.remove(key)
 	return m.ttlKeys.Delete(key)
 }
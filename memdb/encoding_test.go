@@ -0,0 +1,142 @@
+package memdb
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/VincentFF/thinredis/config"
+)
+
+// compactEncodings enables the compact encodings of small hashes and sets during a test
+func compactEncodings(t *testing.T, hashEntries, hashValue, setEntries int) {
+	old := *config.Configures
+	config.Configures.HashMaxListpackEntries = hashEntries
+	config.Configures.HashMaxListpackValue = hashValue
+	config.Configures.SetMaxIntsetEntries = setEntries
+	t.Cleanup(func() { *config.Configures = old })
+}
+
+func encodingOf(m *MemDb, key string) string {
+	return string(objectKey(m, [][]byte{[]byte("object"), []byte("encoding"), []byte(key)}).ByteData())
+}
+
+func TestHashListpack(t *testing.T) {
+	compactEncodings(t, 4, 8, 0)
+	memdb := NewMemDb()
+	execCommands(memdb, "hset h a 1 b 2 c 3", "hset h b 22", "hdel h a", "hincrby h c 10", "hset h d 4")
+	if enc := encodingOf(memdb, "h"); enc != "listpack" {
+		t.Fatalf("encoding of a small hash is %s, expect listpack", enc)
+	}
+	expect := map[string]string{"b": "22", "c": "13", "d": "4"}
+	checkHash := func() {
+		tem, _ := memdb.db.Get("h")
+		hash := tem.(*Hash)
+		if hash.Len() != len(expect) {
+			t.Errorf("hash has %d fields, expect %d", hash.Len(), len(expect))
+		}
+		for field, value := range expect {
+			if string(hash.Get(field)) != value {
+				t.Errorf("field %s is %s, expect %s", field, hash.Get(field), value)
+			}
+		}
+	}
+	checkHash()
+
+	// a hash is converted once it has too many fields
+	execCommands(memdb, "hset h e 5 f 6")
+	expect["e"], expect["f"] = "5", "6"
+	if enc := encodingOf(memdb, "h"); enc != "hashtable" {
+		t.Errorf("encoding of a big hash is %s, expect hashtable", enc)
+	}
+	checkHash()
+
+	// or a long value
+	execCommands(memdb, "hset long f "+strings.Repeat("v", 9))
+	if enc := encodingOf(memdb, "long"); enc != "hashtable" {
+		t.Errorf("encoding of a hash with a long value is %s, expect hashtable", enc)
+	}
+
+	execCommands(memdb, "hset small f v")
+	tem, _ := memdb.db.Get("small")
+	if copied := tem.(*Hash).Copy(); copied.Encoding() != "listpack" || string(copied.Get("f")) != "v" {
+		t.Error("copy of a listpack hash is wrong")
+	}
+	for count, expect := range map[int]int{1: 2, 5: 2, -5: 10} {
+		if fields := tem.(*Hash).RandomWithValue(count); len(fields) != expect {
+			t.Errorf("random %d fields returns %d entries, expect %d", count, len(fields), expect)
+		}
+	}
+}
+
+func TestSetIntset(t *testing.T) {
+	compactEncodings(t, 0, 0, 4)
+	memdb := NewMemDb()
+	execCommands(memdb, "sadd s 3 -1 2 3", "srem s 2", "sadd t 1 2 3")
+	if enc := encodingOf(memdb, "s"); enc != "intset" {
+		t.Fatalf("encoding of an integer set is %s, expect intset", enc)
+	}
+	tem, _ := memdb.db.Get("s")
+	members := tem.(*Set).Members()
+	if strings.Join(members, " ") != "-1 3" {
+		t.Errorf("intset members are %v, expect [-1 3]", members)
+	}
+
+	execCommands(memdb, "sinterstore i s t")
+	if enc := encodingOf(memdb, "i"); enc != "intset" {
+		t.Errorf("encoding of an integer intersection is %s, expect intset", enc)
+	}
+
+	// a non canonical integer is a string member
+	execCommands(memdb, "sadd s 07")
+	if enc := encodingOf(memdb, "s"); enc != "hashtable" {
+		t.Errorf("encoding of a set with a string member is %s, expect hashtable", enc)
+	}
+	execCommands(memdb, "sadd big 1 2 3 4 5")
+	if enc := encodingOf(memdb, "big"); enc != "hashtable" {
+		t.Errorf("encoding of a big set is %s, expect hashtable", enc)
+	}
+	for _, key := range []string{"s", "big"} {
+		tem, _ := memdb.db.Get(key)
+		members := tem.(*Set).Members()
+		sort.Strings(members)
+		expect := map[string]string{"s": "-1 07 3", "big": "1 2 3 4 5"}[key]
+		if strings.Join(members, " ") != expect {
+			t.Errorf("members of %s are %v after conversion, expect %s", key, members, expect)
+		}
+	}
+
+	execCommands(memdb, "sadd p 1 2 3")
+	for i := 0; i < 3; i++ {
+		res := memdb.ExecCommand([][]byte{[]byte("spop"), []byte("p")})
+		if _, err := strconv.Atoi(string(res.ByteData())); err != nil {
+			t.Errorf("spop of an intset returns %q", res.ToBytes())
+		}
+	}
+	if _, ok := memdb.db.Get("p"); ok {
+		t.Error("empty intset should be deleted")
+	}
+}
+
+func TestCompactSnapshot(t *testing.T) {
+	compactEncodings(t, 4, 8, 4)
+	memdb := NewMemDb()
+	execCommands(memdb, "hset h a 1 b 2", "sadd s 1 2 3")
+	path := filepath.Join(t.TempDir(), "dump.tdb")
+	if err := SaveSnapshot(path, memdb.dbs); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewMemDb()
+	if err := LoadSnapshot(path, loaded.dbs); err != nil {
+		t.Fatal(err)
+	}
+	if encodingOf(loaded, "h") != "listpack" || encodingOf(loaded, "s") != "intset" {
+		t.Error("compact encodings should be kept after the snapshot is loaded")
+	}
+	tem, _ := loaded.db.Get("h")
+	if string(tem.(*Hash).Get("b")) != "2" {
+		t.Error("hash is not loaded from the snapshot")
+	}
+}
@@ -0,0 +1,180 @@
+package memdb
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/VincentFF/thinredis/logger"
+)
+
+// evict.go implements the maxmemory limit.
+// Before a write command is executed, keys are evicted by the maxmemory policy until the used memory is under maxmemory.
+// A victim is the best of some sampled keys, like the approximated lru and lfu of redis.
+
+// maxmemory policies, see maxmemory-policy in redis.conf
+const (
+	policyNoEviction     = "noeviction"
+	policyAllKeysLru     = "allkeys-lru"
+	policyAllKeysLfu     = "allkeys-lfu"
+	policyAllKeysRandom  = "allkeys-random"
+	policyVolatileLru    = "volatile-lru"
+	policyVolatileLfu    = "volatile-lfu"
+	policyVolatileRandom = "volatile-random"
+	policyVolatileTtl    = "volatile-ttl"
+)
+
+// oomAllowedCommands are the write commands which never add data, they are executed even if memory can't be freed
+var oomAllowedCommands = map[string]bool{
+	"del":       true,
+	"unlink":    true,
+	"expire":    true,
+	"pexpire":   true,
+	"expireat":  true,
+	"pexpireat": true,
+	"persist":   true,
+	"getdel":    true,
+	"lpop":      true,
+	"rpop":      true,
+	"lrem":      true,
+	"ltrim":     true,
+	"srem":      true,
+	"spop":      true,
+	"hdel":      true,
+	"zrem":      true,
+	"zpopmin":   true,
+	"zpopmax":   true,
+	"rename":    true,
+	"move":      true,
+}
+
+// freeMemory evicts keys from all databases until the memory used by them is not over maxmemory.
+// It returns false if the memory can't be freed by the policy.
+func (m *MemDb) freeMemory() bool {
+	d := m.dbs
+	if m.maxMemory <= 0 || d.usedMemory.Load() <= m.maxMemory {
+		return true
+	}
+	if m.maxMemoryPolicy == policyNoEviction || m.maxMemoryPolicy == "" {
+		return false
+	}
+
+	d.evictMu.Lock()
+	defer d.evictMu.Unlock()
+	for d.usedMemory.Load() > m.maxMemory {
+		db, key, ok := m.evictionVictim()
+		if !ok {
+			return false
+		}
+		db.evictKey(key)
+	}
+	return true
+}
+
+// evictionVictim returns the best key to evict among the keys sampled from every database, and its database
+func (m *MemDb) evictionVictim() (*MemDb, string, bool) {
+	dbs := m.dbs.list()
+	random := m.maxMemoryPolicy == policyAllKeysRandom || m.maxMemoryPolicy == policyVolatileRandom
+	start := 0
+	if random {
+		start = rand.Intn(len(dbs))
+	}
+	var victim *MemDb
+	best, bestScore := "", int64(0)
+	for i := range dbs {
+		db := dbs[(start+i)%len(dbs)]
+		key, score, ok := db.sampleVictim(m.maxMemoryPolicy, m.maxMemorySamples)
+		if !ok {
+			continue
+		}
+		if random {
+			return db, key, true
+		}
+		if victim == nil || score < bestScore {
+			victim, best, bestScore = db, key, score
+		}
+	}
+	return victim, best, victim != nil
+}
+
+// sampleVictim returns the key with the lowest score among the sampled keys of m and its score
+func (m *MemDb) sampleVictim(policy string, samples int) (string, int64, bool) {
+	volatile := policy == policyVolatileLru || policy == policyVolatileLfu ||
+		policy == policyVolatileRandom || policy == policyVolatileTtl
+	source := m.db
+	if volatile {
+		source = m.ttlKeys
+	}
+	if source.Len() == 0 {
+		return "", 0, false
+	}
+	keys := sampleKeys(source, samples)
+	if len(keys) == 0 {
+		return "", 0, false
+	}
+	if policy == policyAllKeysRandom || policy == policyVolatileRandom {
+		return keys[0], 0, true
+	}
+
+	// the key with the lowest score is evicted
+	now := time.Now().UnixMilli()
+	best, bestScore := "", int64(0)
+	for _, key := range keys {
+		var score int64
+		switch policy {
+		case policyVolatileTtl:
+			ttl, ok := m.ttlKeys.Get(key)
+			if !ok {
+				continue
+			}
+			score = ttl.(int64)
+		case policyAllKeysLfu, policyVolatileLfu:
+			if tem, ok := m.meta.Get(key); ok {
+				meta := tem.(*keyMeta)
+				// a less frequent key is evicted first, and then a less recent one
+				score = int64(lfuDecr(meta, now))<<48 | meta.access.Load()&(1<<48-1)
+			}
+		default:
+			if tem, ok := m.meta.Get(key); ok {
+				score = tem.(*keyMeta).access.Load()
+			}
+		}
+		if best == "" || score < bestScore {
+			best, bestScore = key, score
+		}
+	}
+	return best, bestScore, best != ""
+}
+
+// sampleKeys returns about count keys of cm, which are read from the shards after a random one
+func sampleKeys(cm *ConcurrentMap, count int) []string {
+	shardNum := cm.ShardNum()
+	pos := rand.Intn(shardNum)
+	keys := make([]string, 0, count)
+	for visited := 0; visited < shardNum && len(keys) < count; visited++ {
+		keys = append(keys, cm.SampleShard((pos+visited)%shardNum, count-len(keys))...)
+	}
+	return keys
+}
+
+// evictKey deletes key for maxmemory, the deletion is appended to aof as a DEL command
+func (m *MemDb) evictKey(key string) {
+	if m.aof != nil {
+		m.aof.cmdMu.RLock()
+		defer m.aof.cmdMu.RUnlock()
+	}
+	m.locks.Lock(key)
+	defer m.locks.UnLock(key)
+	deleted := m.db.Delete(key)
+	m.DelTTL(key)
+	m.forgetKey(key)
+	if deleted == 0 {
+		return
+	}
+	m.evictedKeys.Add(1)
+	m.notify(notifyEvicted, "evicted", key)
+	if m.aof != nil {
+		if err := m.aof.Append(m, []string{key}, [][]byte{[]byte("del"), []byte(key)}); err != nil {
+			logger.Error("append evicted key to aof error: ", err.Error())
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package memdb
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAccountKey(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "set a 12345678", "rpush l a b c d e f g h i j", "hset h f v")
+	if memdb.meta.Len() != 3 {
+		t.Fatalf("%d keys are accounted, expect 3", memdb.meta.Len())
+	}
+	used := memdb.usedMemory.Load()
+	execCommands(memdb, "rpush l k l m n o p q r s t")
+	if grown := memdb.usedMemory.Load(); grown <= used {
+		t.Error("used memory should grow with the list")
+	}
+	execCommands(memdb, "del a l h")
+	if memdb.usedMemory.Load() != 0 || memdb.meta.Len() != 0 {
+		t.Errorf("used memory is %d after all keys are deleted, expect 0", memdb.usedMemory.Load())
+	}
+
+	execCommands(memdb, "set b v px 1")
+	time.Sleep(5 * time.Millisecond)
+	if memdb.CheckTTL("b") || memdb.usedMemory.Load() != 0 {
+		t.Error("expired key should be forgotten")
+	}
+}
+
+func TestEvictLru(t *testing.T) {
+	memdb := NewMemDb()
+	memdb.maxMemorySamples = 1000 // sample all keys, so that the victim is exact
+	for i := 0; i < 10; i++ {
+		execCommands(memdb, "set key"+strconv.Itoa(i)+" value")
+	}
+	for i := 0; i < 10; i++ {
+		tem, _ := memdb.meta.Get("key" + strconv.Itoa(i))
+		tem.(*keyMeta).access.Store(int64(i))
+	}
+	memdb.CheckTTL("key0") // key0 becomes the most recent key
+
+	memdb.maxMemory = memdb.usedMemory.Load() - 1
+	memdb.maxMemoryPolicy = policyAllKeysLru
+	// keys are evicted before a write command
+	execCommands(memdb, "set new value")
+	if _, ok := memdb.db.Get("key1"); ok {
+		t.Error("the least recently used key should be evicted")
+	}
+	for _, key := range []string{"key0", "key2", "new"} {
+		if _, ok := memdb.db.Get(key); !ok {
+			t.Errorf("%s should not be evicted", key)
+		}
+	}
+	if memdb.evictedKeys.Load() != 1 {
+		t.Errorf("evicted keys is %d, expect 1", memdb.evictedKeys.Load())
+	}
+}
+
+func TestEvictLfu(t *testing.T) {
+	memdb := NewMemDb()
+	memdb.maxMemorySamples = 1000
+	execCommands(memdb, "set hot value", "set cold value")
+	for i := 0; i < 10; i++ {
+		memdb.CheckTTL("hot")
+	}
+
+	memdb.maxMemory = memdb.usedMemory.Load() - 1
+	memdb.maxMemoryPolicy = policyAllKeysLfu
+	execCommands(memdb, "set new value")
+	if _, ok := memdb.db.Get("cold"); ok {
+		t.Error("the least frequently used key should be evicted")
+	}
+	if _, ok := memdb.db.Get("hot"); !ok {
+		t.Error("the frequently used key should not be evicted")
+	}
+}
+
+func TestEvictVolatile(t *testing.T) {
+	memdb := NewMemDb()
+	memdb.maxMemorySamples = 1000
+	execCommands(memdb, "set persist value", "set soon value px 100000", "set later value px 200000")
+
+	memdb.maxMemory = memdb.usedMemory.Load() - 1
+	memdb.maxMemoryPolicy = policyVolatileTtl
+	execCommands(memdb, "set new value")
+	if _, ok := memdb.db.Get("soon"); ok {
+		t.Error("the key expiring soonest should be evicted")
+	}
+	// later is evicted next, and then nothing can be evicted
+	oom := false
+	for i := 0; i < 5 && !oom; i++ {
+		res := memdb.ExecCommand([][]byte{[]byte("set"), []byte("new" + strconv.Itoa(i)), []byte("value")})
+		oom = bytes.HasPrefix(res.ToBytes(), []byte("-OOM"))
+	}
+	if _, ok := memdb.db.Get("later"); !oom || ok {
+		t.Error("write should be rejected if no volatile key is left")
+	}
+	if _, ok := memdb.db.Get("persist"); !ok {
+		t.Error("volatile policy should not evict a key without ttl")
+	}
+}
+
+func TestNoEviction(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "set a value", "set b value", "zadd z 1 a 2 b 3 c", "set e value")
+	memdb.maxMemory = memdb.usedMemory.Load() - 1
+	memdb.maxMemoryPolicy = policyNoEviction
+
+	res := memdb.ExecCommand([][]byte{[]byte("set"), []byte("c"), []byte("value")})
+	if !bytes.Equal(res.ToBytes(), []byte("-OOM command not allowed when used memory > 'maxmemory'.\r\n")) {
+		t.Errorf("write is not rejected under noeviction: %q", res.ToBytes())
+	}
+	res = memdb.ExecCommand([][]byte{[]byte("get"), []byte("a")})
+	if !bytes.Equal(res.ToBytes(), []byte("$5\r\nvalue\r\n")) {
+		t.Error("read should be allowed under noeviction")
+	}
+	for _, cmd := range []string{"zrem z a", "zpopmin z", "zpopmax z", "expireat e 4000000000", "pexpireat e 4000000000000"} {
+		// every command frees memory, the limit is lowered so that the next one is still over it
+		memdb.maxMemory = memdb.usedMemory.Load() - 1
+		if res = memdb.ExecCommand(bytes.Fields([]byte(cmd))); !bytes.Equal(res.ToBytes(), []byte(":1\r\n")) &&
+			!bytes.HasPrefix(res.ToBytes(), []byte("*2\r\n")) {
+			t.Errorf("%s should be allowed under noeviction, replies %q", cmd, res.ToBytes())
+		}
+	}
+	res = memdb.ExecCommand([][]byte{[]byte("del"), []byte("a")})
+	if !bytes.Equal(res.ToBytes(), []byte(":1\r\n")) {
+		t.Error("del should be allowed under noeviction")
+	}
+	res = memdb.ExecCommand([][]byte{[]byte("set"), []byte("c"), []byte("value")})
+	if !bytes.Equal(res.ToBytes(), []byte("+OK\r\n")) {
+		t.Error("write should be allowed after memory is freed")
+	}
+}
+
+func TestLfuCounter(t *testing.T) {
+	meta := &keyMeta{}
+	meta.freq.Store(lfuInitVal)
+	if lfuLogIncr(lfuInitVal) != lfuInitVal+1 {
+		t.Error("lfu counter at the initial value should always be incremented")
+	}
+	if lfuLogIncr(255) != 255 {
+		t.Error("lfu counter should saturate at 255")
+	}
+	now := time.Now().UnixMilli()
+	meta.access.Store(now - 3*lfuDecayTime)
+	if counter := lfuDecr(meta, now); counter != lfuInitVal-3 {
+		t.Errorf("decayed lfu counter is %d, expect %d", counter, lfuInitVal-3)
+	}
+	meta.access.Store(now - 100*lfuDecayTime)
+	if lfuDecr(meta, now) != 0 {
+		t.Error("lfu counter should not decay below 0")
+	}
+}
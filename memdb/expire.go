@@ -0,0 +1,107 @@
+package memdb
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// expire.go implements the active expiration of keys.
+// Keys are expired lazily by CheckTTL when they are accessed, and a background cycle takes the expired keys
+// from the expire index to delete the keys which are never accessed again.
+
+const (
+	// activeExpireHz is the number of expire cycles per second
+	activeExpireHz = 10
+	// activeExpireKeysPerLoop is the number of expired keys deleted in a loop of the cycle
+	activeExpireKeysPerLoop = 20
+)
+
+// expireStats holds the counters of expiration
+type expireStats struct {
+	expiredKeys        atomic.Int64 // keys deleted because they are expired, by both lazy and active expiration
+	stalePercent       atomic.Int64 // percent of ttl keys which are expired but left by the last cycle
+	timeCapReached     atomic.Int64 // cycles stopped because they used up the cpu budget
+	cycleMicroseconds  atomic.Int64 // total time used by the cycles
+	activeExpireCycles atomic.Int64 // number of cycles run
+}
+
+// expireIfNeeded deletes key if it is expired and returns true if key is deleted
+func (m *MemDb) expireIfNeeded(key string) bool {
+	m.locks.Lock(key)
+	defer m.locks.UnLock(key)
+	ttl, ok := m.ttlKeys.Get(key)
+	if !ok || ttl.(int64) > time.Now().UnixMilli() {
+		return false
+	}
+	m.db.Delete(key)
+	m.DelTTL(key)
+	m.forgetKey(key)
+	m.stats.expiredKeys.Add(1)
+	m.notify(notifyExpired, "expired", key)
+	return true
+}
+
+// StartActiveExpire starts the background expire cycle. Each cycle uses at most cpuPercent percent
+// of its period, 0 cpuPercent disables active expiration. The cycle is stopped by Close.
+func (m *MemDb) StartActiveExpire(cpuPercent int) {
+	if cpuPercent <= 0 || m.stopExpire != nil {
+		return
+	}
+	period := time.Second / activeExpireHz
+	budget := period * time.Duration(cpuPercent) / 100
+	m.stopExpire = make(chan struct{})
+	m.expireDone = make(chan struct{})
+	go func() {
+		defer close(m.expireDone)
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.activeExpireCycle(budget)
+			case <-m.stopExpire:
+				return
+			}
+		}
+	}()
+}
+
+// stopActiveExpire stops the background expire cycle and waits for the running cycle to finish
+func (m *MemDb) stopActiveExpire() {
+	if m.stopExpire == nil {
+		return
+	}
+	close(m.stopExpire)
+	<-m.expireDone
+	m.stopExpire = nil
+}
+
+// activeExpireCycle deletes the expired keys in loops, until no expired key is left or budget is used up.
+func (m *MemDb) activeExpireCycle(budget time.Duration) {
+	if m.ttlKeys.Len() == 0 {
+		return
+	}
+	start := time.Now()
+	defer func() {
+		m.stats.activeExpireCycles.Add(1)
+		m.stats.cycleMicroseconds.Add(time.Since(start).Microseconds())
+	}()
+
+	for {
+		keys := m.expires.popExpired(time.Now().UnixMilli(), activeExpireKeysPerLoop)
+		for _, key := range keys {
+			m.expireIfNeeded(key)
+		}
+		if len(keys) < activeExpireKeysPerLoop {
+			m.stats.stalePercent.Store(0)
+			return
+		}
+		if time.Since(start) > budget {
+			m.stats.timeCapReached.Add(1)
+			break
+		}
+	}
+	if ttlKeys := m.ttlKeys.Len(); ttlKeys > 0 {
+		m.stats.stalePercent.Store(int64(m.expires.countExpired(time.Now().UnixMilli()) * 100 / ttlKeys))
+	}
+}
@@ -0,0 +1,151 @@
+package memdb
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/VincentFF/thinredis/util"
+)
+
+// expire_index.go implements the index of ttl deadlines used by the active expire cycle.
+// ttl keys are sharded by hash, and each shard keeps its keys in a min-heap ordered by deadline,
+// so the expired keys are found in O(expired * log n) instead of scanning all ttl keys.
+// SetTTL and DelTTL keep the index in step with ttlKeys.
+
+// expireIndexShards is the number of heaps. Keys are sharded to reduce the lock contention of SetTTL and DelTTL.
+const expireIndexShards = 64
+
+type expireEntry struct {
+	key      string
+	deadline int64 // unix milliseconds
+	index    int   // position in the heap
+}
+
+// expireHeap implements heap.Interface, the entry with the earliest deadline is on the top
+type expireHeap []*expireEntry
+
+func (h expireHeap) Len() int { return len(h) }
+
+func (h expireHeap) Less(i, j int) bool { return h[i].deadline < h[j].deadline }
+
+func (h expireHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expireHeap) Push(x any) {
+	e := x.(*expireEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expireHeap) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+type expireShard struct {
+	mu      sync.Mutex
+	heap    expireHeap
+	entries map[string]*expireEntry
+}
+
+type expireIndex struct {
+	shards []*expireShard
+	cursor int // the shard popExpired starts from, so that all shards are served when the count is limited
+}
+
+func newExpireIndex() *expireIndex {
+	x := &expireIndex{shards: make([]*expireShard, expireIndexShards)}
+	for i := range x.shards {
+		x.shards[i] = &expireShard{entries: make(map[string]*expireEntry)}
+	}
+	return x
+}
+
+func (x *expireIndex) shardOf(key string) *expireShard {
+	return x.shards[util.HashKey(key)%len(x.shards)]
+}
+
+// set adds key with deadline to the index, or moves key to deadline if it is in the index
+func (x *expireIndex) set(key string, deadline int64) {
+	s := x.shardOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		e.deadline = deadline
+		heap.Fix(&s.heap, e.index)
+		return
+	}
+	e := &expireEntry{key: key, deadline: deadline}
+	heap.Push(&s.heap, e)
+	s.entries[key] = e
+}
+
+// remove deletes key from the index
+func (x *expireIndex) remove(key string) {
+	s := x.shardOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		heap.Remove(&s.heap, e.index)
+		delete(s.entries, key)
+	}
+}
+
+// clear removes all keys from the index
+func (x *expireIndex) clear() {
+	for _, s := range x.shards {
+		s.mu.Lock()
+		s.heap = nil
+		s.entries = make(map[string]*expireEntry)
+		s.mu.Unlock()
+	}
+}
+
+// popExpired removes at most count keys whose deadline is not after now from the index and returns them.
+// It is called by the expire cycle only, which deletes the returned keys.
+func (x *expireIndex) popExpired(now int64, count int) []string {
+	keys := make([]string, 0)
+	for visited := 0; visited < len(x.shards) && len(keys) < count; visited++ {
+		s := x.shards[x.cursor]
+		s.mu.Lock()
+		for len(s.heap) > 0 && s.heap[0].deadline <= now && len(keys) < count {
+			e := heap.Pop(&s.heap).(*expireEntry)
+			delete(s.entries, e.key)
+			keys = append(keys, e.key)
+		}
+		drained := len(s.heap) == 0 || s.heap[0].deadline > now
+		s.mu.Unlock()
+		if drained {
+			x.cursor = (x.cursor + 1) % len(x.shards)
+		}
+	}
+	return keys
+}
+
+// countExpired returns the number of keys whose deadline is not after now.
+// Only the expired entries and their children are visited.
+func (x *expireIndex) countExpired(now int64) int {
+	count := 0
+	for _, s := range x.shards {
+		s.mu.Lock()
+		stack := []int{0}
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if i >= len(s.heap) || s.heap[i].deadline > now {
+				continue
+			}
+			count++
+			stack = append(stack, 2*i+1, 2*i+2)
+		}
+		s.mu.Unlock()
+	}
+	return count
+}
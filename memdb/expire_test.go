@@ -0,0 +1,133 @@
+package memdb
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestActiveExpireCycle(t *testing.T) {
+	memdb := NewMemDb()
+	now := time.Now().UnixMilli()
+	for i := 0; i < 1000; i++ {
+		key := "expired" + strconv.Itoa(i)
+		memdb.db.Set(key, []byte("v"))
+		memdb.SetTTL(key, now-1)
+	}
+	for i := 0; i < 10; i++ {
+		key := "alive" + strconv.Itoa(i)
+		memdb.db.Set(key, []byte("v"))
+		memdb.SetTTL(key, now+100000)
+	}
+	memdb.db.Set("persist", []byte("v"))
+
+	memdb.activeExpireCycle(time.Second)
+	if memdb.db.Len() != 11 || memdb.ttlKeys.Len() != 10 {
+		t.Errorf("active expire cycle left %d keys and %d ttl keys, expect 11 and 10", memdb.db.Len(), memdb.ttlKeys.Len())
+	}
+	if expired := memdb.stats.expiredKeys.Load(); expired != 1000 {
+		t.Errorf("expired keys is %d, expect 1000", expired)
+	}
+	if memdb.stats.activeExpireCycles.Load() != 1 {
+		t.Error("active expire cycles should be 1")
+	}
+
+	// lazy expiration is counted too
+	memdb.SetTTL("persist", now-1)
+	if memdb.CheckTTL("persist") || memdb.stats.expiredKeys.Load() != 1001 {
+		t.Error("lazy expiration error")
+	}
+
+	// the background cycle deletes keys which are never accessed
+	memdb.db.Set("later", []byte("v"))
+	memdb.SetTTL("later", now-1)
+	memdb.StartActiveExpire(25)
+	defer memdb.stopActiveExpire()
+	time.Sleep(3 * time.Second / activeExpireHz)
+	if _, ok := memdb.db.Get("later"); ok {
+		t.Error("background expire cycle should delete expired key")
+	}
+}
+
+func TestExpireIndex(t *testing.T) {
+	x := newExpireIndex()
+	for i := 0; i < 100; i++ {
+		x.set("key"+strconv.Itoa(i), int64(i))
+	}
+	x.set("key5", 1000) // moved after now
+	x.remove("key6")
+	if n := x.countExpired(49); n != 48 {
+		t.Errorf("count expired is %d, expect 48", n)
+	}
+
+	expired := make(map[string]bool)
+	for {
+		keys := x.popExpired(49, 7)
+		if len(keys) == 0 {
+			break
+		}
+		if len(keys) > 7 {
+			t.Fatal("pop expired returns more keys than count")
+		}
+		for _, key := range keys {
+			expired[key] = true
+		}
+	}
+	if len(expired) != 48 || expired["key5"] || expired["key6"] || expired["key50"] || !expired["key49"] {
+		t.Error("pop expired error")
+	}
+	if n := x.countExpired(1000); n != 51 {
+		t.Errorf("count expired is %d after pop, expect 51", n)
+	}
+}
+
+// The benchmarks run an expire cycle over 1M ttl keys of which 1000 are expired.
+// BenchmarkExpireCycleScan finds them by scanning all ttl keys, as it was done before the expire index.
+const benchTTLKeys = 1 << 20
+
+func benchExpireDb() *MemDb {
+	memdb := NewMemDb()
+	now := time.Now().UnixMilli()
+	for i := 0; i < benchTTLKeys; i++ {
+		key := "key" + strconv.Itoa(i)
+		memdb.db.Set(key, []byte("v"))
+		memdb.SetTTL(key, now+100000)
+	}
+	return memdb
+}
+
+func expireSome(memdb *MemDb) {
+	now := time.Now().UnixMilli()
+	for i := 0; i < 1000; i++ {
+		key := "expired" + strconv.Itoa(i)
+		memdb.db.Set(key, []byte("v"))
+		memdb.SetTTL(key, now-1)
+	}
+}
+
+func BenchmarkExpireCycleIndex(b *testing.B) {
+	memdb := benchExpireDb()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		expireSome(memdb)
+		b.StartTimer()
+		memdb.activeExpireCycle(time.Hour)
+	}
+}
+
+func BenchmarkExpireCycleScan(b *testing.B) {
+	memdb := benchExpireDb()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		expireSome(memdb)
+		b.StartTimer()
+		now := time.Now().UnixMilli()
+		for _, key := range memdb.ttlKeys.Keys() {
+			if ttl, ok := memdb.ttlKeys.Get(key); ok && ttl.(int64) <= now {
+				memdb.expireIfNeeded(key)
+			}
+		}
+	}
+}
@@ -41,6 +41,7 @@ func hDelHash(m *MemDb, cmd [][]byte) resp.RedisData {
 		if hash.IsEmpty() {
 			m.db.Delete(key)
 			m.DelTTL(key)
+			m.notify(notifyGeneric, "del", key)
 		}
 	}()
 
@@ -48,6 +49,9 @@ func hDelHash(m *MemDb, cmd [][]byte) resp.RedisData {
 	for i := 2; i < len(cmd); i++ {
 		res += hash.Del(string(cmd[i]))
 	}
+	if res > 0 {
+		m.notify(notifyHash, "hdel", key)
+	}
 
 	return resp.MakeIntData(int64(res))
 }
@@ -146,11 +150,11 @@ func hGetAllHash(m *MemDb, cmd [][]byte) resp.RedisData {
 		return resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
-	table := hash.Table()
-	res := make([]resp.RedisData, 0, len(table)*2)
-	for k, v := range table {
+	res := make([]resp.RedisData, 0, hash.Len()*2)
+	hash.Range(func(k string, v []byte) bool {
 		res = append(res, resp.MakeBulkData([]byte(k)), resp.MakeBulkData(v))
-	}
+		return true
+	})
 	return resp.MakeArrayData(res)
 }
 
@@ -194,6 +198,7 @@ func hIncrByHash(m *MemDb, cmd [][]byte) resp.RedisData {
 	if !ok {
 		return resp.MakeErrorData("value is not an integer")
 	}
+	m.notify(notifyHash, "hincrby", key)
 	return resp.MakeIntData(int64(res))
 }
 
@@ -233,6 +238,7 @@ func hIncrByFloatHash(m *MemDb, cmd [][]byte) resp.RedisData {
 	if !ok {
 		return resp.MakeErrorData("value is not a float")
 	}
+	m.notify(notifyHash, "hincrbyfloat", key)
 
 	return resp.MakeBulkData([]byte(strconv.FormatFloat(res, 'f', -1, 64)))
 }
@@ -373,6 +379,7 @@ func hSetHash(m *MemDb, cmd [][]byte) resp.RedisData {
 		value := cmd[i+1]
 		hash.Set(field, value)
 	}
+	m.notify(notifyHash, "hset", key)
 	return resp.MakeStringData("OK")
 }
 
@@ -412,6 +419,7 @@ func hSetNxHash(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 
 	hash.Set(field, value)
+	m.notify(notifyHash, "hset", key)
 	return resp.MakeIntData(1)
 }
 
@@ -543,19 +551,74 @@ func hRandFieldHash(m *MemDb, cmd [][]byte) resp.RedisData {
 	return resp.MakeArrayData(res)
 }
 
+// hScanHash handles HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES], see scan.go for the cursor.
+// A listpack hash is small, so it is returned in a single page.
+func hScanHash(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hscan" {
+		logger.Error("hScanHash: command name is not hscan")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 3 {
+		return resp.MakeErrorData("wrong number of arguments for 'hscan' command")
+	}
+	cursor, errRes := parseCursor(cmd[2])
+	if errRes != nil {
+		return errRes
+	}
+	opts, errRes := parseScanOptions(cmd[3:], false, true)
+	if errRes != nil {
+		return errRes
+	}
+
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return scanReply(0, []resp.RedisData{})
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+	tem, ok := m.db.Get(key)
+	if !ok {
+		return scanReply(0, []resp.RedisData{})
+	}
+	hash, ok := tem.(*Hash)
+	if !ok {
+		return resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	var fields []string
+	next := uint64(0)
+	if hash.Encoding() == "listpack" {
+		fields = hash.Keys()
+	} else {
+		fields, next = scanMembers(hash.table, cursor, opts.count)
+	}
+	res := make([]resp.RedisData, 0, len(fields)*2)
+	for _, field := range fields {
+		if !opts.match(field) {
+			continue
+		}
+		res = append(res, resp.MakeBulkData([]byte(field)))
+		if !opts.noValues {
+			res = append(res, resp.MakeBulkData(hash.Get(field)))
+		}
+	}
+	return scanReply(next, res)
+}
+
 func RegisterHashCommands() {
-	RegisterCommand("hdel", hDelHash)
+	RegisterWriteCommand("hdel", hDelHash, 1, 1, 1)
 	RegisterCommand("hexists", hExistsHash)
 	RegisterCommand("hget", hGetHash)
 	RegisterCommand("hgetall", hGetAllHash)
-	RegisterCommand("hincrby", hIncrByHash)
-	RegisterCommand("hincrbyfloat", hIncrByFloatHash)
+	RegisterWriteCommand("hincrby", hIncrByHash, 1, 1, 1)
+	RegisterWriteCommand("hincrbyfloat", hIncrByFloatHash, 1, 1, 1)
 	RegisterCommand("hkeys", hKeysHash)
 	RegisterCommand("hlen", hLenHash)
 	RegisterCommand("hmget", hMGetHash)
-	RegisterCommand("hset", hSetHash)
-	RegisterCommand("hsetnx", hSetNxHash)
+	RegisterWriteCommand("hset", hSetHash, 1, 1, 1)
+	RegisterWriteCommand("hsetnx", hSetNxHash, 1, 1, 1)
 	RegisterCommand("hvals", hValsHash)
 	RegisterCommand("hstrlen", hStrLenHash)
 	RegisterCommand("hrandfield", hRandFieldHash)
+	RegisterCommand("hscan", hScanHash)
 }
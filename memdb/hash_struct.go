@@ -1,130 +1,211 @@
 package memdb
 
-import "strconv"
+import (
+	"math/rand"
+	"strconv"
 
+	"github.com/VincentFF/thinredis/config"
+)
+
+// Hash holds the fields of a hash.
+// A small hash is packed into a listpack of fields and values, it is converted to a map
+// once it has more than hash-max-listpack-entries fields or a field or value longer than hash-max-listpack-value.
+// A map is never converted back to a listpack.
 type Hash struct {
-	table map[string][]byte
+	listpack *listpack // fields and values in turn, used when table is nil
+	table    *bucketMap[[]byte]
 }
 
 func NewHash() *Hash {
-	return &Hash{make(map[string][]byte)}
+	if config.Configures.HashMaxListpackEntries > 0 {
+		return &Hash{listpack: &listpack{}}
+	}
+	return &Hash{table: newBucketMap[[]byte](0)}
+}
+
+// find returns the positions of the field entry and the end of the value entry of key in the listpack
+func (h *Hash) find(key string) (start, end int, value []byte, ok bool) {
+	field := true
+	h.listpack.Range(func(pos, next int, entry []byte) bool {
+		if field {
+			if string(entry) == key {
+				start, ok = pos, true
+			}
+		} else if ok {
+			end, value = next, entry
+			return false
+		}
+		field = !field
+		return true
+	})
+	return start, end, value, ok
+}
+
+// convert converts the listpack to a map
+func (h *Hash) convert() {
+	entries := h.listpack.Entries()
+	h.table = newBucketMap[[]byte](len(entries) / 2)
+	for i := 0; i+1 < len(entries); i += 2 {
+		h.table.Set(string(entries[i]), entries[i+1])
+	}
+	h.listpack = nil
 }
 
 func (h *Hash) Set(key string, value []byte) {
-	h.table[key] = value
+	if h.table == nil {
+		maxValue := config.Configures.HashMaxListpackValue
+		if len(key) > maxValue || len(value) > maxValue {
+			h.convert()
+		} else if start, end, _, ok := h.find(key); ok {
+			h.listpack.replace(start, end, 2, []byte(key), value)
+			return
+		} else if h.Len() >= config.Configures.HashMaxListpackEntries {
+			h.convert()
+		} else {
+			h.listpack.Append([]byte(key), value)
+			return
+		}
+	}
+	h.table.Set(key, value)
 }
 
 func (h *Hash) Get(key string) []byte {
-	return h.table[key]
+	if h.table == nil {
+		_, _, value, _ := h.find(key)
+		return value
+	}
+	value, _ := h.table.Get(key)
+	return value
 }
 
 func (h *Hash) Del(key string) int {
-	if h.Exist(key) {
-		delete(h.table, key)
+	if h.table == nil {
+		if start, end, _, ok := h.find(key); ok {
+			h.listpack.replace(start, end, 2)
+			return 1
+		}
+		return 0
+	}
+	if h.table.Delete(key) {
 		return 1
 	}
 	return 0
 }
 
 func (h *Hash) Len() int {
-	return len(h.table)
+	if h.table == nil {
+		return h.listpack.Len() / 2
+	}
+	return h.table.Len()
+}
+
+// Range calls fn for every field and value until fn returns false
+func (h *Hash) Range(fn func(key string, value []byte) bool) {
+	if h.table == nil {
+		var key []byte
+		field := true
+		h.listpack.Range(func(_, _ int, entry []byte) bool {
+			if field {
+				key = entry
+			} else if !fn(string(key), entry) {
+				return false
+			}
+			field = !field
+			return true
+		})
+		return
+	}
+	h.table.Range(fn)
 }
 
 func (h *Hash) Keys() []string {
-	keys := make([]string, 0, len(h.table))
-	for key := range h.table {
+	keys := make([]string, 0, h.Len())
+	h.Range(func(key string, _ []byte) bool {
 		keys = append(keys, key)
-	}
+		return true
+	})
 	return keys
 }
 
 func (h *Hash) Values() [][]byte {
-	values := make([][]byte, 0, len(h.table))
-	for _, value := range h.table {
+	values := make([][]byte, 0, h.Len())
+	h.Range(func(_ string, value []byte) bool {
 		values = append(values, value)
-	}
+		return true
+	})
 	return values
 }
 
 func (h *Hash) Clear() {
-	h.table = make(map[string][]byte)
+	*h = *NewHash()
 }
 
 func (h *Hash) IsEmpty() bool {
-	return len(h.table) == 0
+	return h.Len() == 0
 }
 
 func (h *Hash) Exist(key string) bool {
-	_, ok := h.table[key]
+	if h.table == nil {
+		_, _, _, ok := h.find(key)
+		return ok
+	}
+	_, ok := h.table.Get(key)
 	return ok
 }
 
 func (h *Hash) StrLen(key string) int {
-	return len(h.table[key])
+	return len(h.Get(key))
 }
 
-func (h *Hash) Random(count int) []string {
-	res := make([]string, 0)
-	if count == 0 || h.Len() == 0 {
-		return res
-	} else if count > 0 {
+// randomPairs returns the indexes of count random fields of a listpack hash, see Random for count
+func (h *Hash) randomPairs(count int) []int {
+	if count > 0 {
 		if count > h.Len() {
 			count = h.Len()
 		}
-		for key := range h.table {
-			res = append(res, key)
-			if len(res) == count {
-				break
-			}
-		}
-	} else {
-		for {
-			for key := range h.table {
-				res = append(res, key)
-				if len(res) == -count {
-					return res
-				}
-			}
+		return rand.Perm(h.Len())[:count]
+	}
+	res := make([]int, -count)
+	for i := range res {
+		res[i] = rand.Intn(h.Len())
+	}
+	return res
+}
 
+func (h *Hash) Random(count int) []string {
+	res := make([]string, 0)
+	if count == 0 || h.Len() == 0 {
+		return res
+	}
+	if h.table == nil {
+		entries := h.listpack.Entries()
+		for _, i := range h.randomPairs(count) {
+			res = append(res, string(entries[2*i]))
 		}
+		return res
 	}
-	return res
+	return h.table.RandomKeys(count)
 }
 
 func (h *Hash) RandomWithValue(count int) [][]byte {
 	res := make([][]byte, 0)
 	if count == 0 || h.Len() == 0 {
 		return res
-	} else if count > 0 {
-		if count > h.Len() {
-			count = h.Len()
-		}
-		count *= 2
-		for key, val := range h.table {
-			res = append(res, []byte(key), val)
-			if len(res) == count {
-				break
-			}
-		}
-	} else {
-		count *= 2
-		for {
-			for key, val := range h.table {
-				res = append(res, []byte(key), val)
-				if len(res) == -count {
-					return res
-				}
-			}
-
+	}
+	if h.table == nil {
+		entries := h.listpack.Entries()
+		for _, i := range h.randomPairs(count) {
+			res = append(res, entries[2*i], entries[2*i+1])
 		}
+		return res
+	}
+	for _, key := range h.table.RandomKeys(count) {
+		value, _ := h.table.Get(key)
+		res = append(res, []byte(key), value)
 	}
 	return res
 }
 
-func (h *Hash) Table() map[string][]byte {
-	return h.table
-}
-
 func (h *Hash) IncrBy(key string, incr int) (int, bool) {
 	tem := h.Get(key)
 	if len(tem) == 0 {
@@ -156,3 +237,19 @@ func (h *Hash) IncrByFloat(key string, incr float64) (float64, bool) {
 		return value, true
 	}
 }
+
+// Encoding returns listpack or hashtable
+func (h *Hash) Encoding() string {
+	if h.table == nil {
+		return "listpack"
+	}
+	return "hashtable"
+}
+
+// Copy returns a deep copy of h, the values are copied too
+func (h *Hash) Copy() *Hash {
+	if h.table == nil {
+		return &Hash{listpack: h.listpack.Copy()}
+	}
+	return &Hash{table: h.table.Copy(copyBytes)}
+}
@@ -0,0 +1,114 @@
+package memdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/VincentFF/thinredis/logger"
+	"github.com/VincentFF/thinredis/resp"
+)
+
+// info.go implements the INFO command which reports server statistics in sections.
+// The figures are of all databases, except the keyspace section which has a line for each non-empty database.
+
+// infoSection writes a section of INFO to builder
+type infoSection func(m *MemDb, builder *strings.Builder)
+
+var infoSections = []struct {
+	name  string
+	write infoSection
+}{
+	{"memory", memoryInfo},
+	{"stats", statsInfo},
+	{"keyspace", keyspaceInfo},
+}
+
+func memoryInfo(m *MemDb, builder *strings.Builder) {
+	builder.WriteString("# Memory\r\n")
+	used := m.dbs.usedMemory.Load()
+	fmt.Fprintf(builder, "used_memory:%d\r\n", used)
+	fmt.Fprintf(builder, "used_memory_human:%s\r\n", humanSize(used))
+	fmt.Fprintf(builder, "maxmemory:%d\r\n", m.maxMemory)
+	fmt.Fprintf(builder, "maxmemory_human:%s\r\n", humanSize(m.maxMemory))
+	policy := m.maxMemoryPolicy
+	if policy == "" {
+		policy = policyNoEviction
+	}
+	fmt.Fprintf(builder, "maxmemory_policy:%s\r\n", policy)
+	fmt.Fprintf(builder, "lazyfree_pending_objects:%d\r\n", m.dbs.sum(func(db *MemDb) int64 { return db.lazyfreePending.Load() }))
+}
+
+// humanSize formats bytes like 1.50M
+func humanSize(bytes int64) string {
+	switch {
+	case bytes >= 1<<30:
+		return fmt.Sprintf("%.2fG", float64(bytes)/(1<<30))
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.2fM", float64(bytes)/(1<<20))
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%.2fK", float64(bytes)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", bytes)
+}
+
+func statsInfo(m *MemDb, builder *strings.Builder) {
+	builder.WriteString("# Stats\r\n")
+	d := m.dbs
+	// the stale percent is of the database with the most stale keys
+	stale := int64(0)
+	for _, db := range d.list() {
+		if perc := db.stats.stalePercent.Load(); perc > stale {
+			stale = perc
+		}
+	}
+	fmt.Fprintf(builder, "expired_keys:%d\r\n", d.sum(func(db *MemDb) int64 { return db.stats.expiredKeys.Load() }))
+	fmt.Fprintf(builder, "expired_stale_perc:%d\r\n", stale)
+	fmt.Fprintf(builder, "expired_time_cap_reached_count:%d\r\n", d.sum(func(db *MemDb) int64 { return db.stats.timeCapReached.Load() }))
+	fmt.Fprintf(builder, "expire_cycle_cpu_milliseconds:%d\r\n", d.sum(func(db *MemDb) int64 { return db.stats.cycleMicroseconds.Load() })/1000)
+	fmt.Fprintf(builder, "active_expire_cycles:%d\r\n", d.sum(func(db *MemDb) int64 { return db.stats.activeExpireCycles.Load() }))
+	fmt.Fprintf(builder, "evicted_keys:%d\r\n", d.sum(func(db *MemDb) int64 { return db.evictedKeys.Load() }))
+	fmt.Fprintf(builder, "lazyfreed_objects:%d\r\n", d.sum(func(db *MemDb) int64 { return db.lazyfreed.Load() }))
+}
+
+func keyspaceInfo(m *MemDb, builder *strings.Builder) {
+	builder.WriteString("# Keyspace\r\n")
+	for i, db := range m.dbs.list() {
+		if keys := db.db.Len(); keys > 0 {
+			fmt.Fprintf(builder, "db%d:keys=%d,expires=%d\r\n", i, keys, db.ttlKeys.Len())
+		}
+	}
+}
+
+func infoServer(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "info" {
+		logger.Error("infoServer Function: cmdName is not info")
+		return resp.MakeErrorData("server error")
+	}
+
+	// no section, "all", "default" and "everything" report all sections
+	wanted := make(map[string]bool)
+	for _, arg := range cmd[1:] {
+		section := strings.ToLower(string(arg))
+		if section == "all" || section == "default" || section == "everything" {
+			wanted = map[string]bool{}
+			break
+		}
+		wanted[section] = true
+	}
+
+	builder := &strings.Builder{}
+	for _, section := range infoSections {
+		if len(wanted) > 0 && !wanted[section.name] {
+			continue
+		}
+		if builder.Len() > 0 {
+			builder.WriteString("\r\n")
+		}
+		section.write(m, builder)
+	}
+	return resp.MakeBulkData([]byte(builder.String()))
+}
+
+func RegisterInfoCommands() {
+	RegisterCommand("info", infoServer)
+}
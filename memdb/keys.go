@@ -1,7 +1,9 @@
 package memdb
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -25,8 +27,11 @@ func delKey(m *MemDb, cmd [][]byte) resp.RedisData {
 	dKey := 0
 	for _, key := range cmd[1:] {
 		m.locks.Lock(string(key))
-		dKey += m.db.Delete(string(key))
-		m.ttlKeys.Delete(string(key))
+		if m.db.Delete(string(key)) == 1 {
+			dKey++
+			m.notify(notifyGeneric, "del", string(key))
+		}
+		m.DelTTL(string(key))
 		m.locks.UnLock(string(key))
 	}
 	return resp.MakeIntData(int64(dKey))
@@ -53,6 +58,59 @@ func existsKey(m *MemDb, cmd [][]byte) resp.RedisData {
 	return resp.MakeIntData(int64(eKey))
 }
 
+// randomKeyTries is the number of expired keys RANDOMKEY deletes before it gives up
+const randomKeyTries = 100
+
+// randomKeyKey handles RANDOMKEY. An expired key that is drawn is deleted, and another key is drawn.
+func randomKeyKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "randomkey" {
+		logger.Error("randomKeyKey Function: cmdName is not randomkey")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) != 1 {
+		return resp.MakeErrorData("wrong number of arguments for 'randomkey' command")
+	}
+	for i := 0; i < randomKeyTries; i++ {
+		key, ok := m.db.RandomKey()
+		if !ok {
+			if m.db.Len() == 0 {
+				return resp.MakeBulkData(nil)
+			}
+			continue
+		}
+		// drawing a key is not an access of it
+		if m.alive(key) {
+			return resp.MakeBulkData([]byte(key))
+		}
+	}
+	return resp.MakeBulkData(nil)
+}
+
+// touchKey handles TOUCH key [key ...], it records an access of the keys and returns the number of existing keys
+func touchKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "touch" {
+		logger.Error("touchKey Function: cmdName is not touch")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 2 {
+		return resp.MakeErrorData("wrong number of arguments for 'touch' command")
+	}
+	touched := 0
+	for _, keyByte := range cmd[1:] {
+		key := string(keyByte)
+		// CheckTTL records the access for the lru and lfu eviction and OBJECT IDLETIME
+		if !m.CheckTTL(key) {
+			continue
+		}
+		m.locks.RLock(key)
+		if _, ok := m.db.Get(key); ok {
+			touched++
+		}
+		m.locks.RUnLock(key)
+	}
+	return resp.MakeIntData(int64(touched))
+}
+
 func keysKey(m *MemDb, cmd [][]byte) resp.RedisData {
 	if strings.ToLower(string(cmd[0])) != "keys" || len(cmd) != 2 {
 		logger.Error("keysKey Function: cmdName is not keys or cmd length is not 2")
@@ -62,7 +120,7 @@ func keysKey(m *MemDb, cmd [][]byte) resp.RedisData {
 	allKeys := m.db.Keys()
 	pattern := string(cmd[1])
 	for _, key := range allKeys {
-		if m.CheckTTL(key) {
+		if m.alive(key) {
 			if util.PattenMatch(pattern, key) {
 				res = append(res, resp.MakeBulkData([]byte(key)))
 			}
@@ -71,24 +129,108 @@ func keysKey(m *MemDb, cmd [][]byte) resp.RedisData {
 	return resp.MakeArrayData(res)
 }
 
+// scanKey handles SCAN cursor [MATCH pattern] [COUNT count] [TYPE type], see scan.go for the cursor.
+// MATCH and TYPE filter the keys of a page, so a page may be empty before the scan is done.
+func scanKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "scan" {
+		logger.Error("scanKey Function: cmdName is not scan")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 2 {
+		return resp.MakeErrorData("wrong number of arguments for 'scan' command")
+	}
+	cursor, errRes := parseCursor(cmd[1])
+	if errRes != nil {
+		return errRes
+	}
+	opts, errRes := parseScanOptions(cmd[2:], true, false)
+	if errRes != nil {
+		return errRes
+	}
+
+	keys, next := m.scanKeys(cursor, opts.count)
+	res := make([]resp.RedisData, 0, len(keys))
+	for _, key := range keys {
+		// scanning a key is not an access
+		if !opts.match(key) || !m.alive(key) {
+			continue
+		}
+		if opts.kind != -1 {
+			m.locks.RLock(key)
+			val, ok := m.db.Get(key)
+			m.locks.RUnLock(key)
+			if !ok || valueKind(val) != opts.kind {
+				continue
+			}
+		}
+		res = append(res, resp.MakeBulkData([]byte(key)))
+	}
+	return scanReply(next, res)
+}
+
+// expireKey handles EXPIRE and PEXPIRE, which set a ttl in seconds or milliseconds
 func expireKey(m *MemDb, cmd [][]byte) resp.RedisData {
-	cmdName := string(cmd[0])
-	if strings.ToLower(cmdName) != "expire" || len(cmd) < 3 || len(cmd) > 4 {
-		logger.Error("expireKey Function: cmdName is not expire or command args number is invalid")
-		return resp.MakeErrorData("error: cmdName is not expire or command args number is invalid")
+	cmdName := strings.ToLower(string(cmd[0]))
+	if (cmdName != "expire" && cmdName != "pexpire") || len(cmd) < 3 || len(cmd) > 4 {
+		logger.Error("expireKey Function: cmdName is not expire or pexpire or command args number is invalid")
+		return resp.MakeErrorData("error: cmdName is not expire or pexpire or command args number is invalid")
 	}
 
 	v, err := strconv.ParseInt(string(cmd[2]), 10, 64)
 	if err != nil {
-		logger.Error("expireKey Function: cmd[2] %s is not int", string(cmd[2]))
+		logger.Error("expireKey Function: cmd[2] ", string(cmd[2]), " is not int")
 		return resp.MakeErrorData(fmt.Sprintf("error: %s is not int", string(cmd[2])))
 	}
-	ttl := time.Now().Unix() + v
+	if cmdName == "expire" {
+		if v, err = secondsToMilli(v); err != nil {
+			return resp.MakeErrorData(fmt.Sprintf("error: invalid expire time in '%s' command", cmdName))
+		}
+	}
+	now := time.Now().UnixMilli()
+	if v > math.MaxInt64-now || v < math.MinInt64+now {
+		return resp.MakeErrorData(fmt.Sprintf("error: invalid expire time in '%s' command", cmdName))
+	}
 	var opt string
 	if len(cmd) == 4 {
 		opt = strings.ToLower(string(cmd[3]))
 	}
-	key := string(cmd[1])
+	return m.expireAt(string(cmd[1]), now+v, opt)
+}
+
+// expireAtKey handles EXPIREAT and PEXPIREAT, which set an absolute unix time in seconds or milliseconds
+func expireAtKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if (cmdName != "expireat" && cmdName != "pexpireat") || len(cmd) < 3 || len(cmd) > 4 {
+		logger.Error("expireAtKey Function: cmdName is not expireat or pexpireat or command args number is invalid")
+		return resp.MakeErrorData("error: cmdName is not expireat or pexpireat or command args number is invalid")
+	}
+
+	ttl, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil {
+		return resp.MakeErrorData(fmt.Sprintf("error: %s is not int", string(cmd[2])))
+	}
+	if cmdName == "expireat" {
+		if ttl, err = secondsToMilli(ttl); err != nil {
+			return resp.MakeErrorData(fmt.Sprintf("error: invalid expire time in '%s' command", cmdName))
+		}
+	}
+	var opt string
+	if len(cmd) == 4 {
+		opt = strings.ToLower(string(cmd[3]))
+	}
+	return m.expireAt(string(cmd[1]), ttl, opt)
+}
+
+// secondsToMilli converts seconds to milliseconds and returns an error if it overflows
+func secondsToMilli(seconds int64) (int64, error) {
+	if seconds > math.MaxInt64/1000 || seconds < math.MinInt64/1000 {
+		return 0, errors.New("time overflows")
+	}
+	return seconds * 1000, nil
+}
+
+// expireAt sets the absolute expire time of key in unix milliseconds according to the nx, xx, gt or lt option
+func (m *MemDb) expireAt(key string, ttl int64, opt string) resp.RedisData {
 	if !m.CheckTTL(key) {
 		return resp.MakeIntData(int64(0))
 	}
@@ -115,11 +257,14 @@ func expireKey(m *MemDb, cmd [][]byte) resp.RedisData {
 		}
 	default:
 		if opt != "" {
-			logger.Error("expireKey Function: opt %s is not nx, xx, gt or lt", opt)
+			logger.Error("expireAt Function: opt ", opt, " is not nx, xx, gt or lt")
 			return resp.MakeErrorData(fmt.Sprintf("error: unsupport %s, except nx, xx, gt, lt", opt))
 		}
 		res = m.SetTTL(key, ttl)
 	}
+	if res == 1 {
+		m.notify(notifyGeneric, "expire", key)
+	}
 	return resp.MakeIntData(int64(res))
 }
 
@@ -137,14 +282,18 @@ func persistKey(m *MemDb, cmd [][]byte) resp.RedisData {
 	m.locks.Lock(key)
 	defer m.locks.UnLock(key)
 	res := m.DelTTL(key)
+	if res == 1 {
+		m.notify(notifyGeneric, "persist", key)
+	}
 	return resp.MakeIntData(int64(res))
 }
 
+// ttlKey handles TTL and PTTL, which return the remaining ttl in seconds or milliseconds
 func ttlKey(m *MemDb, cmd [][]byte) resp.RedisData {
-	cmdName := string(cmd[0])
-	if strings.ToLower(cmdName) != "ttl" || len(cmd) != 2 {
-		logger.Error("ttlKey Function: cmdName is not ttl or command args number is invalid")
-		return resp.MakeErrorData("error: cmdName is not ttl or command args number is invalid")
+	cmdName := strings.ToLower(string(cmd[0]))
+	if (cmdName != "ttl" && cmdName != "pttl") || len(cmd) != 2 {
+		logger.Error("ttlKey Function: cmdName is not ttl or pttl or command args number is invalid")
+		return resp.MakeErrorData("error: cmdName is not ttl or pttl or command args number is invalid")
 	}
 	key := string(cmd[1])
 
@@ -157,12 +306,48 @@ func ttlKey(m *MemDb, cmd [][]byte) resp.RedisData {
 	if _, ok := m.db.Get(key); !ok {
 		return resp.MakeIntData(int64(-2))
 	}
-	now := time.Now().Unix()
+	now := time.Now().UnixMilli()
 	ttl, ok := m.ttlKeys.Get(key)
 	if !ok {
 		return resp.MakeIntData(int64(-1))
 	}
-	return resp.MakeIntData(ttl.(int64) - now)
+	remain := ttl.(int64) - now
+	if remain < 0 {
+		remain = 0
+	}
+	if cmdName == "ttl" {
+		// round to the nearest second
+		return resp.MakeIntData((remain + 500) / 1000)
+	}
+	return resp.MakeIntData(remain)
+}
+
+// expireTimeKey handles EXPIRETIME and PEXPIRETIME, which return the absolute unix expire time in seconds or milliseconds
+func expireTimeKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if (cmdName != "expiretime" && cmdName != "pexpiretime") || len(cmd) != 2 {
+		logger.Error("expireTimeKey Function: cmdName is not expiretime or pexpiretime or command args number is invalid")
+		return resp.MakeErrorData("error: cmdName is not expiretime or pexpiretime or command args number is invalid")
+	}
+	key := string(cmd[1])
+
+	if !m.CheckTTL(key) {
+		return resp.MakeIntData(int64(-2))
+	}
+
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+	if _, ok := m.db.Get(key); !ok {
+		return resp.MakeIntData(int64(-2))
+	}
+	ttl, ok := m.ttlKeys.Get(key)
+	if !ok {
+		return resp.MakeIntData(int64(-1))
+	}
+	if cmdName == "expiretime" {
+		return resp.MakeIntData(ttl.(int64) / 1000)
+	}
+	return resp.MakeIntData(ttl.(int64))
 }
 
 func typeKey(m *MemDb, cmd [][]byte) resp.RedisData {
@@ -192,23 +377,27 @@ func typeKey(m *MemDb, cmd [][]byte) resp.RedisData {
 		return resp.MakeStringData("set")
 	case *Hash:
 		return resp.MakeStringData("hash")
+	case *ZSet:
+		return resp.MakeStringData("zset")
 	default:
-		logger.Error("typeKey Function: type func error, not in string|list|set|hash")
+		logger.Error("typeKey Function: type func error, not in string|list|set|hash|zset")
 	}
 	return resp.MakeErrorData("unknown error: server error")
 }
 
+// renameKey handles RENAME and RENAMENX. The ttl of the old key is kept by the new key.
 func renameKey(m *MemDb, cmd [][]byte) resp.RedisData {
-	cmdName := string(cmd[0])
-	if strings.ToLower(cmdName) != "rename" || len(cmd) != 3 {
-		logger.Error("renameKey Function: cmdName is not rename or command args number is invalid")
-		return resp.MakeErrorData("error: cmdName is not rename or command args number is invalid")
+	cmdName := strings.ToLower(string(cmd[0]))
+	if (cmdName != "rename" && cmdName != "renamenx") || len(cmd) != 3 {
+		logger.Error("renameKey Function: cmdName is not rename or renamenx or command args number is invalid")
+		return resp.MakeErrorData("error: cmdName is not rename or renamenx or command args number is invalid")
 	}
 	oldName, newName := string(cmd[1]), string(cmd[2])
 
 	if !m.CheckTTL(oldName) {
 		return resp.MakeErrorData(fmt.Sprintf("error: %s not exist", oldName))
 	}
+	m.CheckTTL(newName)
 
 	m.locks.LockMulti([]string{oldName, newName})
 	defer m.locks.UnLockMulti([]string{oldName, newName})
@@ -217,11 +406,297 @@ func renameKey(m *MemDb, cmd [][]byte) resp.RedisData {
 	if !ok {
 		return resp.MakeErrorData(fmt.Sprintf("error: %s not exist", oldName))
 	}
-	m.db.Delete(oldName)
-	m.ttlKeys.Delete(oldName)
-	m.db.Delete(newName)
-	m.ttlKeys.Delete(newName)
-	m.db.Set(newName, oldValue)
+	if cmdName == "renamenx" {
+		if _, ok = m.db.Get(newName); ok {
+			return resp.MakeIntData(0)
+		}
+	}
+	if oldName != newName {
+		ttl, hasTTL := m.ttlKeys.Get(oldName)
+		m.db.Delete(oldName)
+		m.DelTTL(oldName)
+		m.db.Set(newName, oldValue)
+		m.DelTTL(newName)
+		if hasTTL {
+			m.SetTTL(newName, ttl.(int64))
+		}
+	}
+	m.notify(notifyGeneric, "rename_from", oldName)
+	m.notify(notifyGeneric, "rename_to", newName)
+	if cmdName == "renamenx" {
+		return resp.MakeIntData(1)
+	}
+	return resp.MakeStringData("OK")
+}
+
+// copyBytes returns a copy of b, it is never nil
+func copyBytes(b []byte) []byte {
+	res := make([]byte, len(b))
+	copy(res, b)
+	return res
+}
+
+// copyValue returns a deep copy of a db value, so that the copy can be modified independently
+func copyValue(val any) any {
+	switch v := val.(type) {
+	case []byte:
+		return copyBytes(v)
+	case *List:
+		return v.Copy()
+	case *Set:
+		return v.Copy()
+	case *Hash:
+		return v.Copy()
+	case *ZSet:
+		return v.Copy()
+	}
+	logger.Error("copyValue Function: value type is not string|list|set|hash|zset")
+	return nil
+}
+
+// copyKey handles COPY source destination [DB destination-db] [REPLACE]
+func copyKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "copy" {
+		logger.Error("copyKey Function: cmdName is not copy")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 3 {
+		return resp.MakeErrorData("wrong number of arguments for 'copy' command")
+	}
+	src, dst := string(cmd[1]), string(cmd[2])
+
+	var replace bool
+	target := m
+	for i := 3; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "replace":
+			replace = true
+		case "db":
+			i++
+			if i >= len(cmd) {
+				return resp.MakeErrorData("error: syntax error")
+			}
+			index, err := m.dbs.ParseIndex(cmd[i])
+			if err != nil {
+				return resp.MakeErrorData("error: " + err.Error())
+			}
+			target = m.dbs.Db(index)
+		default:
+			return resp.MakeErrorData("error: syntax error")
+		}
+	}
+	if src == dst && target == m {
+		return resp.MakeErrorData("error: source and destination objects are the same")
+	}
+
+	if !m.CheckTTL(src) {
+		return resp.MakeIntData(0)
+	}
+	target.CheckTTL(dst)
+
+	copied := func() bool {
+		if target == m {
+			m.locks.LockMulti([]string{src, dst})
+			defer m.locks.UnLockMulti([]string{src, dst})
+		} else {
+			defer lockKeys(m, src, target, dst)()
+		}
+		val, ok := m.db.Get(src)
+		if !ok {
+			return false
+		}
+		if _, ok = target.db.Get(dst); ok && !replace {
+			return false
+		}
+		target.db.Set(dst, copyValue(val))
+		target.DelTTL(dst)
+		if ttl, ok := m.ttlKeys.Get(src); ok {
+			target.SetTTL(dst, ttl.(int64))
+		}
+		target.notify(notifyGeneric, "copy_to", dst)
+		return true
+	}()
+	if !copied {
+		return resp.MakeIntData(0)
+	}
+	if target != m {
+		target.wroteKey(dst)
+	}
+	return resp.MakeIntData(1)
+}
+
+// moveKey handles MOVE key db, the key is moved with its ttl if it doesn't exist in db
+func moveKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "move" {
+		logger.Error("moveKey Function: cmdName is not move")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) != 3 {
+		return resp.MakeErrorData("wrong number of arguments for 'move' command")
+	}
+	index, err := m.dbs.ParseIndex(cmd[2])
+	if err != nil {
+		return resp.MakeErrorData("error: " + err.Error())
+	}
+	target := m.dbs.Db(index)
+	if target == m {
+		return resp.MakeErrorData("error: source and destination objects are the same")
+	}
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return resp.MakeIntData(0)
+	}
+	target.CheckTTL(key)
+
+	moved := func() bool {
+		defer lockKeys(m, key, target, key)()
+		val, ok := m.db.Get(key)
+		if !ok {
+			return false
+		}
+		if _, ok = target.db.Get(key); ok {
+			return false
+		}
+		ttl, hasTTL := m.ttlKeys.Get(key)
+		m.db.Delete(key)
+		m.DelTTL(key)
+		target.db.Set(key, val)
+		if hasTTL {
+			target.SetTTL(key, ttl.(int64))
+		}
+		m.notify(notifyGeneric, "move_from", key)
+		target.notify(notifyGeneric, "move_to", key)
+		return true
+	}()
+	if !moved {
+		return resp.MakeIntData(0)
+	}
+	target.wroteKey(key)
+	return resp.MakeIntData(1)
+}
+
+// wroteKey accounts key written by a command executed in another database,
+// ExecCommand and the aof only track the keys of the database the command is executed in.
+func (m *MemDb) wroteKey(key string) {
+	m.accountKey(key)
+	if m.aof != nil {
+		m.aof.touch(m, key)
+	}
+}
+
+// dbSizeKey handles DBSIZE, it returns the number of keys in the selected database
+func dbSizeKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "dbsize" {
+		logger.Error("dbSizeKey Function: cmdName is not dbsize")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) != 1 {
+		return resp.MakeErrorData("wrong number of arguments for 'dbsize' command")
+	}
+	return resp.MakeIntData(int64(m.db.Len()))
+}
+
+func dumpKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := string(cmd[0])
+	if strings.ToLower(cmdName) != "dump" || len(cmd) != 2 {
+		logger.Error("dumpKey Function: cmdName is not dump or command args number is invalid")
+		return resp.MakeErrorData("error: cmdName is not dump or command args number is invalid")
+	}
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return resp.MakeBulkData(nil)
+	}
+
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+	val, ok := m.db.Get(key)
+	if !ok {
+		return resp.MakeBulkData(nil)
+	}
+	payload, err := dumpValue(val)
+	if err != nil {
+		logger.Error("dumpKey Function: ", err.Error())
+		return resp.MakeErrorData("error: " + err.Error())
+	}
+	return resp.MakeBulkData(payload)
+}
+
+// restoreKey implements RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+// ttl is in milliseconds and 0 means no ttl. IDLETIME and FREQ set the access statistics of the restored key.
+func restoreKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := string(cmd[0])
+	if strings.ToLower(cmdName) != "restore" || len(cmd) < 4 {
+		logger.Error("restoreKey Function: cmdName is not restore or command args number is invalid")
+		return resp.MakeErrorData("error: cmdName is not restore or command args number is invalid")
+	}
+	key := string(cmd[1])
+	ttl, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil || ttl < 0 {
+		return resp.MakeErrorData("error: invalid TTL value, must be >= 0")
+	}
+
+	var replace, absTTL bool
+	idle, freq := int64(0), int64(lfuInitVal)
+	for i := 4; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		case "idletime":
+			i++
+			if i >= len(cmd) {
+				return resp.MakeErrorData("error: syntax error")
+			}
+			idle, err = strconv.ParseInt(string(cmd[i]), 10, 64)
+			if err != nil || idle < 0 {
+				return resp.MakeErrorData("error: invalid IDLETIME value, must be >= 0")
+			}
+		case "freq":
+			i++
+			if i >= len(cmd) {
+				return resp.MakeErrorData("error: syntax error")
+			}
+			freq, err = strconv.ParseInt(string(cmd[i]), 10, 64)
+			if err != nil || freq < 0 || freq > 255 {
+				return resp.MakeErrorData("error: invalid FREQ value, must be >= 0 and <= 255")
+			}
+		default:
+			return resp.MakeErrorData(fmt.Sprintf("error: unsupported option %s", string(cmd[i])))
+		}
+	}
+
+	val, err := restoreValue(cmd[3])
+	if err != nil {
+		return resp.MakeErrorData("error: " + err.Error())
+	}
+
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.UnLock(key)
+	if _, ok := m.db.Get(key); ok && !replace {
+		return resp.MakeErrorData("BUSYKEY Target key name already exists.")
+	}
+
+	expireAt := ttl
+	if ttl > 0 && !absTTL {
+		expireAt = time.Now().UnixMilli() + ttl
+	}
+	// an absolute ttl in the past restores nothing but deletes the old key
+	if ttl > 0 && expireAt <= time.Now().UnixMilli() {
+		if m.db.Delete(key) == 1 {
+			m.notify(notifyGeneric, "del", key)
+		}
+		m.DelTTL(key)
+		return resp.MakeStringData("OK")
+	}
+	m.db.Set(key, val)
+	m.DelTTL(key)
+	if ttl > 0 {
+		m.SetTTL(key, expireAt)
+	}
+	m.setAccess(key, time.Now().UnixMilli()-idle*1000, uint32(freq))
+	m.notify(notifyGeneric, "restore", key)
 	return resp.MakeStringData("OK")
 }
 
@@ -244,12 +719,33 @@ func pingKeys(m *MemDb, cmd [][]byte) resp.RedisData {
 
 func RegisterKeyCommands() {
 	RegisterCommand("ping", pingKeys)
-	RegisterCommand("del", delKey)
+	RegisterWriteCommand("del", delKey, 1, -1, 1)
+	RegisterWriteCommand("unlink", unlinkKey, 1, -1, 1)
 	RegisterCommand("exists", existsKey)
 	RegisterCommand("keys", keysKey)
-	RegisterCommand("expire", expireKey)
-	RegisterCommand("persist", persistKey)
+	RegisterCommand("randomkey", randomKeyKey)
+	RegisterCommand("touch", touchKey)
+	RegisterWriteCommandKeys("sort", sortKey, sortStoreKeys)
+	RegisterCommand("sort_ro", sortKey)
+	RegisterCommand("scan", scanKey)
+	RegisterCommand("dbsize", dbSizeKey)
+	RegisterWriteCommand("expire", expireKey, 1, 1, 1)
+	RegisterWriteCommand("pexpire", expireKey, 1, 1, 1)
+	RegisterWriteCommand("expireat", expireAtKey, 1, 1, 1)
+	RegisterWriteCommand("pexpireat", expireAtKey, 1, 1, 1)
+	RegisterWriteCommand("persist", persistKey, 1, 1, 1)
 	RegisterCommand("ttl", ttlKey)
+	RegisterCommand("pttl", ttlKey)
+	RegisterCommand("expiretime", expireTimeKey)
+	RegisterCommand("pexpiretime", expireTimeKey)
 	RegisterCommand("type", typeKey)
-	RegisterCommand("rename", renameKey)
+	RegisterWriteCommand("rename", renameKey, 1, 2, 1)
+	RegisterWriteCommand("renamenx", renameKey, 1, 2, 1)
+	RegisterWriteCommand("copy", copyKey, 1, 2, 1)
+	RegisterWriteCommand("move", moveKey, 1, 1, 1)
+	RegisterCommand("dump", dumpKey)
+	RegisterWriteCommand("restore", restoreKey, 1, 1, 1)
+	// flushes append to aof by themselves, see flush
+	RegisterCommand("flushall", flushAllKeys)
+	RegisterCommand("flushdb", flushAllKeys)
 }
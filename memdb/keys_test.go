@@ -2,10 +2,15 @@ package memdb
 
 import (
 	"bytes"
+	"encoding/binary"
+	"hash/crc64"
+	"math"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/VincentFF/thinredis/config"
+	"github.com/VincentFF/thinredis/resp"
 )
 
 func init() {
@@ -16,7 +21,7 @@ func TestDelKey(t *testing.T) {
 	memdb := NewMemDb()
 	memdb.db.Set("a", "a")
 	memdb.db.Set("b", "b")
-	memdb.ttlKeys.Set("b", time.Now().Unix()+10)
+	memdb.ttlKeys.Set("b", time.Now().UnixMilli()+10000)
 
 	del_a := delKey(memdb, [][]byte{[]byte("del"), []byte("a"), []byte("b")})
 
@@ -42,7 +47,7 @@ func TestExpireKey(t *testing.T) {
 		t.Error("expire reply is not correct")
 	}
 	attl, _ := memdb.ttlKeys.Get("a")
-	if attl.(int64)-time.Now().Unix() > 100 || attl.(int64)-time.Now().Unix() < 99 {
+	if attl.(int64)-time.Now().UnixMilli() > 100000 || attl.(int64)-time.Now().UnixMilli() < 99000 {
 		t.Error("ttl set incorrect")
 	}
 	expire_a1 := expireKey(memdb, [][]byte{[]byte("expire"), []byte("a"), []byte("1000"), []byte("xx")})
@@ -50,7 +55,7 @@ func TestExpireKey(t *testing.T) {
 		t.Error("expire reply is not correct")
 	}
 	a1ttl, _ := memdb.ttlKeys.Get("a")
-	if a1ttl.(int64)-time.Now().Unix() > 1000 || a1ttl.(int64)-time.Now().Unix() < 999 {
+	if a1ttl.(int64)-time.Now().UnixMilli() > 1000000 || a1ttl.(int64)-time.Now().UnixMilli() < 999000 {
 		t.Error("ttl set incorrect")
 	}
 
@@ -59,7 +64,7 @@ func TestExpireKey(t *testing.T) {
 		t.Error("expire reply is not correct")
 	}
 	bttl, _ := memdb.ttlKeys.Get("b")
-	if bttl.(int64)-time.Now().Unix() > 100 || bttl.(int64)-time.Now().Unix() < 99 {
+	if bttl.(int64)-time.Now().UnixMilli() > 100000 || bttl.(int64)-time.Now().UnixMilli() < 99000 {
 		t.Error("ttl set incorrect")
 	}
 
@@ -68,7 +73,272 @@ func TestExpireKey(t *testing.T) {
 		t.Error("expire reply is not correct")
 	}
 	b1ttl, _ := memdb.ttlKeys.Get("b")
-	if b1ttl.(int64)-time.Now().Unix() > 1000 || b1ttl.(int64)-time.Now().Unix() < 999 {
+	if b1ttl.(int64)-time.Now().UnixMilli() > 1000000 || b1ttl.(int64)-time.Now().UnixMilli() < 999000 {
 		t.Error("ttl set incorrect")
 	}
 }
+
+func TestMilliExpireKey(t *testing.T) {
+	memdb := NewMemDb()
+	memdb.db.Set("a", []byte("a"))
+	memdb.db.Set("b", []byte("b"))
+
+	res := expireKey(memdb, [][]byte{[]byte("pexpire"), []byte("a"), []byte("1200")})
+	if !bytes.Equal(res.ToBytes(), []byte(":1\r\n")) {
+		t.Error("pexpire reply is not correct")
+	}
+	pttl := ttlKey(memdb, [][]byte{[]byte("pttl"), []byte("a")}).(*resp.IntData).Data()
+	if pttl > 1200 || pttl < 1100 {
+		t.Errorf("pttl is %d, expect about 1200", pttl)
+	}
+	// ttl is rounded to the nearest second
+	if ttl := ttlKey(memdb, [][]byte{[]byte("ttl"), []byte("a")}).(*resp.IntData).Data(); ttl != 1 {
+		t.Errorf("ttl is %d, expect 1", ttl)
+	}
+
+	deadline := time.Now().UnixMilli() + 100000
+	res = expireAtKey(memdb, [][]byte{[]byte("pexpireat"), []byte("b"), []byte(strconv.FormatInt(deadline, 10))})
+	if !bytes.Equal(res.ToBytes(), []byte(":1\r\n")) {
+		t.Error("pexpireat reply is not correct")
+	}
+	if at := expireTimeKey(memdb, [][]byte{[]byte("pexpiretime"), []byte("b")}).(*resp.IntData).Data(); at != deadline {
+		t.Errorf("pexpiretime is %d, expect %d", at, deadline)
+	}
+	if at := expireTimeKey(memdb, [][]byte{[]byte("expiretime"), []byte("b")}).(*resp.IntData).Data(); at != deadline/1000 {
+		t.Errorf("expiretime is %d, expect %d", at, deadline/1000)
+	}
+
+	// lt keeps the earlier deadline, expireat is in seconds
+	res = expireAtKey(memdb, [][]byte{[]byte("expireat"), []byte("b"), []byte(strconv.FormatInt(deadline/1000+10, 10)), []byte("lt")})
+	if !bytes.Equal(res.ToBytes(), []byte(":0\r\n")) {
+		t.Error("expireat lt reply is not correct")
+	}
+	res = expireKey(memdb, [][]byte{[]byte("expire"), []byte("b"), []byte("9223372036854775807")})
+	if _, ok := res.(*resp.ErrorData); !ok {
+		t.Error("expire with overflowed ttl should fail")
+	}
+
+	memdb.db.Set("c", []byte("c"))
+	if at := expireTimeKey(memdb, [][]byte{[]byte("expiretime"), []byte("c")}).(*resp.IntData).Data(); at != -1 {
+		t.Error("expiretime of key without ttl should be -1")
+	}
+	if at := expireTimeKey(memdb, [][]byte{[]byte("pexpiretime"), []byte("none")}).(*resp.IntData).Data(); at != -2 {
+		t.Error("pexpiretime of missing key should be -2")
+	}
+
+	expireKey(memdb, [][]byte{[]byte("pexpire"), []byte("c"), []byte("50")})
+	time.Sleep(60 * time.Millisecond)
+	if memdb.CheckTTL("c") {
+		t.Error("key should expire after 50 milliseconds")
+	}
+}
+
+func TestDumpRestore(t *testing.T) {
+	memdb := NewMemDb()
+	memdb.db.Set("str", []byte("v"))
+	rPushList(memdb, [][]byte{[]byte("rpush"), []byte("list"), []byte("a"), []byte("b")})
+	sAddSet(memdb, [][]byte{[]byte("sadd"), []byte("set"), []byte("a"), []byte("b")})
+	hSetHash(memdb, [][]byte{[]byte("hset"), []byte("hash"), []byte("f"), []byte("v")})
+
+	for _, key := range []string{"str", "list", "set", "hash"} {
+		payload := dumpKey(memdb, [][]byte{[]byte("dump"), []byte(key)})
+		restored := []byte(key + "-restored")
+		res := restoreKey(memdb, [][]byte{[]byte("restore"), restored, []byte("100000"), payload.ByteData()})
+		if !bytes.Equal(res.ToBytes(), []byte("+OK\r\n")) {
+			t.Errorf("restore %s reply error: %s", key, res.ToBytes())
+		}
+		origin, _ := memdb.db.Get(key)
+		val, _ := memdb.db.Get(string(restored))
+		originDump, _ := dumpValue(origin)
+		valDump, _ := dumpValue(val)
+		if key != "set" && key != "hash" && !bytes.Equal(originDump, valDump) {
+			t.Errorf("restored %s is not equal to the origin", key)
+		}
+		ttl, ok := memdb.ttlKeys.Get(string(restored))
+		if !ok || ttl.(int64)-time.Now().UnixMilli() > 100000 || ttl.(int64)-time.Now().UnixMilli() < 99000 {
+			t.Errorf("restore ttl of %s error", key)
+		}
+	}
+	if val, _ := memdb.db.Get("set-restored"); val.(*Set).Len() != 2 || !val.(*Set).Has("b") {
+		t.Error("restore set error")
+	}
+	if val, _ := memdb.db.Get("hash-restored"); !bytes.Equal(val.(*Hash).Get("f"), []byte("v")) {
+		t.Error("restore hash error")
+	}
+
+	payload := dumpKey(memdb, [][]byte{[]byte("dump"), []byte("list")}).ByteData()
+	res := restoreKey(memdb, [][]byte{[]byte("restore"), []byte("str"), []byte("0"), payload})
+	if !bytes.HasPrefix(res.ToBytes(), []byte("-BUSYKEY")) {
+		t.Error("restore existing key without replace should fail")
+	}
+	res = restoreKey(memdb, [][]byte{[]byte("restore"), []byte("str"), []byte("0"), payload, []byte("replace")})
+	if val, _ := memdb.db.Get("str"); !bytes.Equal(res.ToBytes(), []byte("+OK\r\n")) || val.(*List).Len != 2 {
+		t.Error("restore with replace error")
+	}
+
+	payload[0] ^= 0xFF
+	res = restoreKey(memdb, [][]byte{[]byte("restore"), []byte("broken"), []byte("0"), payload})
+	if _, ok := res.(*resp.ErrorData); !ok {
+		t.Error("restore corrupted payload should fail")
+	}
+}
+
+func TestRestoreForgedLength(t *testing.T) {
+	memdb := NewMemDb()
+	// payloads with valid checksums whose lengths and counts are far longer than the payload
+	for _, body := range [][]byte{
+		binary.AppendUvarint([]byte{typeString}, math.MaxUint64),
+		binary.AppendUvarint([]byte{typeString}, 1<<40),
+		binary.AppendUvarint([]byte{typeList}, 1<<40),
+		binary.AppendUvarint([]byte{typeSet}, math.MaxUint64),
+		binary.AppendUvarint([]byte{typeHash}, 1<<40),
+		binary.AppendUvarint([]byte{typeZSet}, 3),
+	} {
+		payload := binary.BigEndian.AppendUint16(body, dumpVersion)
+		payload = binary.BigEndian.AppendUint64(payload, crc64.Checksum(payload, crcTable))
+		res := restoreKey(memdb, [][]byte{[]byte("restore"), []byte("forged"), []byte("0"), payload})
+		if !bytes.HasSuffix(res.ToBytes(), []byte(errBadPayload.Error()+"\r\n")) {
+			t.Errorf("restore of forged payload %v replies %q", body, res.ToBytes())
+		}
+	}
+	if _, ok := memdb.db.Get("forged"); ok {
+		t.Error("forged payload is restored")
+	}
+}
+
+func TestRenameKey(t *testing.T) {
+	memdb := NewMemDb()
+	deadline := time.Now().UnixMilli() + 100000
+	memdb.db.Set("a", []byte("a"))
+	memdb.ttlKeys.Set("a", deadline)
+	memdb.db.Set("b", []byte("b"))
+	memdb.ttlKeys.Set("b", deadline+1000)
+
+	res := renameKey(memdb, [][]byte{[]byte("rename"), []byte("a"), []byte("b")})
+	if !bytes.Equal(res.ToBytes(), []byte("+OK\r\n")) {
+		t.Error("rename reply is not correct")
+	}
+	if _, ok := memdb.db.Get("a"); ok {
+		t.Error("rename should delete the old key")
+	}
+	if _, ok := memdb.ttlKeys.Get("a"); ok {
+		t.Error("rename should delete the ttl of the old key")
+	}
+	if val, _ := memdb.db.Get("b"); !bytes.Equal(val.([]byte), []byte("a")) {
+		t.Error("rename should overwrite the new key")
+	}
+	if ttl, ok := memdb.ttlKeys.Get("b"); !ok || ttl.(int64) != deadline {
+		t.Error("rename should keep the ttl of the old key")
+	}
+
+	memdb.db.Set("c", []byte("c"))
+	res = renameKey(memdb, [][]byte{[]byte("renamenx"), []byte("b"), []byte("c")})
+	if !bytes.Equal(res.ToBytes(), []byte(":0\r\n")) {
+		t.Error("renamenx to an existing key should do nothing")
+	}
+	res = renameKey(memdb, [][]byte{[]byte("renamenx"), []byte("b"), []byte("d")})
+	if _, ok := memdb.ttlKeys.Get("d"); !bytes.Equal(res.ToBytes(), []byte(":1\r\n")) || !ok {
+		t.Error("renamenx error")
+	}
+	res = renameKey(memdb, [][]byte{[]byte("rename"), []byte("nokey"), []byte("e")})
+	if _, ok := res.(*resp.ErrorData); !ok {
+		t.Error("rename a not exist key should fail")
+	}
+}
+
+func TestCopyKey(t *testing.T) {
+	memdb := NewMemDb()
+	list := NewList()
+	list.RPush([]byte("a"))
+	memdb.db.Set("list", list)
+	memdb.ttlKeys.Set("list", time.Now().UnixMilli()+100000)
+	hash := NewHash()
+	hash.Set("f", []byte("v"))
+	memdb.db.Set("hash", hash)
+	memdb.db.Set("str", []byte("s"))
+
+	res := copyKey(memdb, [][]byte{[]byte("copy"), []byte("list"), []byte("list2")})
+	if !bytes.Equal(res.ToBytes(), []byte(":1\r\n")) {
+		t.Error("copy reply is not correct")
+	}
+	copied, _ := memdb.db.Get("list2")
+	copied.(*List).RPush([]byte("b"))
+	val, _ := copied.(*List).Index(0)
+	val[0] = 'x'
+	if val, _ := list.Index(0); list.Len != 1 || !bytes.Equal(val, []byte("a")) {
+		t.Error("copy should not share the list with the source")
+	}
+	if _, ok := memdb.ttlKeys.Get("list2"); !ok {
+		t.Error("copy should copy the ttl")
+	}
+
+	res = copyKey(memdb, [][]byte{[]byte("copy"), []byte("hash"), []byte("str")})
+	if !bytes.Equal(res.ToBytes(), []byte(":0\r\n")) {
+		t.Error("copy to an existing key without replace should do nothing")
+	}
+	res = copyKey(memdb, [][]byte{[]byte("copy"), []byte("hash"), []byte("str"), []byte("db"), []byte("0"), []byte("replace")})
+	if !bytes.Equal(res.ToBytes(), []byte(":1\r\n")) {
+		t.Error("copy with replace error")
+	}
+	copied, _ = memdb.db.Get("str")
+	copied.(*Hash).Set("f", []byte("changed"))
+	if !bytes.Equal(hash.Get("f"), []byte("v")) {
+		t.Error("copy should not share the hash with the source")
+	}
+
+	res = copyKey(memdb, [][]byte{[]byte("copy"), []byte("hash"), []byte("other"), []byte("db"), []byte("1")})
+	if _, ok := res.(*resp.ErrorData); !ok {
+		t.Error("copy to a not exist db should fail")
+	}
+	res = copyKey(memdb, [][]byte{[]byte("copy"), []byte("hash"), []byte("hash")})
+	if _, ok := res.(*resp.ErrorData); !ok {
+		t.Error("copy to the same key should fail")
+	}
+}
+
+func TestRandomKey(t *testing.T) {
+	memdb := NewMemDb()
+	randomKey := [][]byte{[]byte("randomkey")}
+	if res := randomKeyKey(memdb, randomKey); !bytes.Equal(res.ToBytes(), []byte("$-1\r\n")) {
+		t.Errorf("randomkey of an empty db replies %q", res.ToBytes())
+	}
+	for i := 0; i < 10; i++ {
+		execCommands(memdb, "set key"+strconv.Itoa(i)+" v")
+	}
+	drawn := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		drawn[string(randomKeyKey(memdb, randomKey).ByteData())]++
+	}
+	for i := 0; i < 10; i++ {
+		if count := drawn["key"+strconv.Itoa(i)]; count < 700 || count > 1300 {
+			t.Errorf("key%d is drawn %d times in 10000, expect about 1000", i, count)
+		}
+	}
+
+	// expired keys are deleted instead of returned
+	memdb = NewMemDb()
+	execCommands(memdb, "set live v")
+	for i := 0; i < 5; i++ {
+		key := "expired" + strconv.Itoa(i)
+		memdb.db.Set(key, []byte("v"))
+		memdb.SetTTL(key, time.Now().UnixMilli()-1)
+	}
+	if res := randomKeyKey(memdb, randomKey); string(res.ByteData()) != "live" {
+		t.Errorf("randomkey replies %q, expect live", res.ToBytes())
+	}
+}
+
+func TestTouchKey(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "set a v", "rpush b v")
+	tem, _ := memdb.meta.Get("a")
+	tem.(*keyMeta).access.Store(time.Now().UnixMilli() - 100000)
+
+	res := touchKey(memdb, [][]byte{[]byte("touch"), []byte("a"), []byte("b"), []byte("none")})
+	if !bytes.Equal(res.ToBytes(), []byte(":2\r\n")) {
+		t.Errorf("touch replies %q, expect 2", res.ToBytes())
+	}
+	if idle := time.Now().UnixMilli() - tem.(*keyMeta).access.Load(); idle > 1000 {
+		t.Errorf("key is idle for %d ms after touch", idle)
+	}
+}
@@ -0,0 +1,220 @@
+package memdb
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/VincentFF/thinredis/logger"
+	"github.com/VincentFF/thinredis/resp"
+)
+
+// lazyfree.go implements UNLINK, FLUSHALL and FLUSHDB and the background freer they hand the removed values to.
+// Memory is reclaimed by the gc once a value is unreachable, so freeing a value means dropping the references it holds.
+// The freer does it for big values off the client goroutine, and the keys are removed from db at once.
+
+const (
+	// lazyfreeThreshold is the number of elements above which a value is freed in background, smaller values are just dropped
+	lazyfreeThreshold = 64
+	// lazyfreeQueueSize is the number of jobs waiting for the freer, a job is freed at once when the queue is full
+	lazyfreeQueueSize = 1024
+)
+
+type lazyfreeJob struct {
+	m       *MemDb
+	val     any              // a value removed by UNLINK
+	shards  []map[string]any // the keys removed by FLUSHALL or FLUSHDB
+	objects int64
+}
+
+var (
+	lazyfreeJobs = make(chan lazyfreeJob, lazyfreeQueueSize)
+	lazyfreeOnce sync.Once
+)
+
+// freeEffort returns the number of elements of val
+func freeEffort(val any) int {
+	switch v := val.(type) {
+	case *List:
+		return v.Len
+	case *Set:
+		return v.Len()
+	case *Hash:
+		return v.Len()
+	case *ZSet:
+		return v.Len()
+	}
+	return 1
+}
+
+// release drops the references held by val, so that its parts can be reclaimed even if val is still referenced
+func release(val any) {
+	switch v := val.(type) {
+	case *List:
+		v.Clear()
+	case *Set:
+		v.intset, v.table = nil, nil
+	case *Hash:
+		v.listpack, v.table = nil, nil
+	case *ZSet:
+		v.header, v.tail, v.dict = nil, nil, nil
+	}
+}
+
+// releaseShards drops the shards of the keys removed by a flush.
+// Nothing else references the maps or their values, so the gc reclaims them without the keys being walked.
+func releaseShards(shards []map[string]any) {
+	for i := range shards {
+		shards[i] = nil
+	}
+}
+
+func lazyfree() {
+	for job := range lazyfreeJobs {
+		if job.shards != nil {
+			releaseShards(job.shards)
+		} else {
+			release(job.val)
+		}
+		job.m.lazyfreePending.Add(-job.objects)
+		job.m.lazyfreed.Add(job.objects)
+	}
+}
+
+// submitLazyfree hands job to the freer, or frees it at once if the freer is busy
+func (m *MemDb) submitLazyfree(job lazyfreeJob) {
+	lazyfreeOnce.Do(func() {
+		go lazyfree()
+	})
+	job.m = m
+	m.lazyfreePending.Add(job.objects)
+	select {
+	case lazyfreeJobs <- job:
+	default:
+		m.lazyfreePending.Add(-job.objects)
+		if job.shards != nil {
+			releaseShards(job.shards)
+		} else {
+			release(job.val)
+		}
+	}
+}
+
+// freeValue frees a value removed from db, big values are freed in background
+func (m *MemDb) freeValue(val any) {
+	if freeEffort(val) > lazyfreeThreshold {
+		m.submitLazyfree(lazyfreeJob{val: val, objects: 1})
+	}
+}
+
+// clearKeys removes all keys of m and returns the shards of the removed keys. The caller must hold all locks.
+func (m *MemDb) clearKeys() []map[string]any {
+	shards := m.db.Clear()
+	m.ttlKeys.Clear()
+	m.expires.clear()
+	m.meta.Clear()
+	m.dbs.usedMemory.Add(-m.usedMemory.Swap(0))
+	for i := range m.typeMemory {
+		m.typeMemory[i].Store(0)
+	}
+	return shards
+}
+
+// flush removes all keys of m, or of all databases if all is true, and appends cmd to aof.
+// The removed keys are freed in background if async is true.
+// Like evictKey it appends to aof by itself, so that cmd is ordered with the commands of the keys it removes.
+func (m *MemDb) flush(cmd [][]byte, all, async bool) {
+	dbs := []*MemDb{m}
+	if all {
+		dbs = m.dbs.list()
+	}
+	shards := func() []map[string]any {
+		if m.aof != nil {
+			m.aof.cmdMu.RLock()
+			defer m.aof.cmdMu.RUnlock()
+		}
+		// databases are locked in index order
+		shards := make([]map[string]any, 0)
+		for _, db := range dbs {
+			db.locks.LockAll()
+			defer db.locks.UnLockAll()
+			shards = append(shards, db.clearKeys()...)
+		}
+		if m.aof != nil {
+			flushed := m
+			if all {
+				flushed = nil
+			}
+			if err := m.aof.AppendFlush(flushed, cmd); err != nil {
+				logger.Error("append flush to aof error: ", err.Error())
+			}
+		}
+		return shards
+	}()
+	if !async {
+		// the removed keys are reclaimed by the gc once shards is dropped
+		return
+	}
+	objects := int64(0)
+	for _, mp := range shards {
+		objects += int64(len(mp))
+	}
+	if objects > 0 {
+		m.submitLazyfree(lazyfreeJob{shards: shards, objects: objects})
+	}
+}
+
+// unlinkKey deletes keys like DEL, but the values are freed in background
+func unlinkKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := string(cmd[0])
+	if strings.ToLower(cmdName) != "unlink" {
+		logger.Error("unlinkKey Function: cmdName is not unlink")
+		return resp.MakeErrorData("Protocol error: cmdName is not unlink")
+	}
+	if len(cmd) < 2 {
+		return resp.MakeErrorData("wrong number of arguments for 'unlink' command")
+	}
+	unlinked := 0
+	for _, keyByte := range cmd[1:] {
+		key := string(keyByte)
+		if !m.CheckTTL(key) {
+			continue
+		}
+		m.locks.Lock(key)
+		val, ok := m.db.Get(key)
+		if ok {
+			m.db.Delete(key)
+			unlinked++
+			m.notify(notifyGeneric, "del", key)
+		}
+		m.DelTTL(key)
+		m.locks.UnLock(key)
+		if ok {
+			m.freeValue(val)
+		}
+	}
+	return resp.MakeIntData(int64(unlinked))
+}
+
+// flushAllKeys implements FLUSHALL and FLUSHDB [ASYNC|SYNC], FLUSHDB only removes the keys of the selected database
+func flushAllKeys(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "flushall" && cmdName != "flushdb" {
+		logger.Error("flushAllKeys Function: cmdName is not flushall or flushdb")
+		return resp.MakeErrorData("Protocol error: cmdName is not flushall or flushdb")
+	}
+	if len(cmd) > 2 {
+		return resp.MakeErrorData("wrong number of arguments for '" + cmdName + "' command")
+	}
+	async := false
+	if len(cmd) == 2 {
+		switch strings.ToLower(string(cmd[1])) {
+		case "async":
+			async = true
+		case "sync":
+		default:
+			return resp.MakeErrorData("error: syntax error")
+		}
+	}
+	m.flush(cmd, cmdName == "flushall", async)
+	return resp.MakeStringData("OK")
+}
@@ -0,0 +1,104 @@
+package memdb
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnlink(t *testing.T) {
+	memdb := NewMemDb()
+	members := make([]string, 0, lazyfreeThreshold*2)
+	for i := 0; i < lazyfreeThreshold*2; i++ {
+		members = append(members, strconv.Itoa(i))
+	}
+	execCommands(memdb, "sadd big "+strings.Join(members, " "), "set small v", "set ttl v ex 100")
+	res := memdb.ExecCommand([][]byte{[]byte("unlink"), []byte("big"), []byte("small"), []byte("ttl"), []byte("nokey")})
+	if !bytes.Equal(res.ToBytes(), []byte(":3\r\n")) {
+		t.Errorf("unlink replies %q, expect 3", res.ToBytes())
+	}
+	if memdb.db.Len() != 0 || memdb.ttlKeys.Len() != 0 || memdb.usedMemory.Load() != 0 {
+		t.Error("unlinked keys are left")
+	}
+	// only the big set is freed in background
+	deadline := time.Now().Add(time.Second)
+	for memdb.lazyfreed.Load() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if memdb.lazyfreed.Load() != 1 || memdb.lazyfreePending.Load() != 0 {
+		t.Errorf("lazyfreed %d objects with %d pending, expect 1 and 0", memdb.lazyfreed.Load(), memdb.lazyfreePending.Load())
+	}
+}
+
+func TestFlushAll(t *testing.T) {
+	for _, mode := range []string{"", "sync", "async"} {
+		memdb := NewMemDb()
+		execCommands(memdb, "set a 1", "rpush l a b", "sadd s a", "hset h f v", "set t v ex 100")
+		cmd := "flushall " + mode
+		res := memdb.ExecCommand(bytes.Fields([]byte(cmd)))
+		if !bytes.Equal(res.ToBytes(), []byte("+OK\r\n")) {
+			t.Fatalf("%s replies %q", cmd, res.ToBytes())
+		}
+		if memdb.db.Len() != 0 || memdb.ttlKeys.Len() != 0 || memdb.meta.Len() != 0 || memdb.usedMemory.Load() != 0 {
+			t.Errorf("keys are left after %s", cmd)
+		}
+		for i := range memdb.typeMemory {
+			if memdb.typeMemory[i].Load() != 0 {
+				t.Errorf("%s memory is %d after %s", kindNames[i], memdb.typeMemory[i].Load(), cmd)
+			}
+		}
+		if memdb.expires.countExpired(time.Now().UnixMilli()+200000) != 0 {
+			t.Errorf("ttl index is not cleared by %s", cmd)
+		}
+		execCommands(memdb, "set a 1")
+		if val, ok := memdb.db.Get("a"); !ok || memdb.db.Len() != 1 || !bytes.Equal(val.([]byte), []byte("1")) {
+			t.Errorf("set after %s error", cmd)
+		}
+	}
+
+	memdb := NewMemDb()
+	res := memdb.ExecCommand([][]byte{[]byte("flushdb"), []byte("lazy")})
+	if !bytes.Equal(res.ToBytes(), []byte("-error: syntax error\r\n")) {
+		t.Errorf("flushdb with a wrong mode replies %q", res.ToBytes())
+	}
+}
+
+func TestFlushAof(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := NewAof(path, FsyncNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMemDb()
+	m.dbs.SetAof(aof)
+
+	// a flush during a rewrite drops the keys written before it from the merge
+	aof.mu.Lock()
+	aof.touched, aof.flushed = make(map[*MemDb]map[string]struct{}), make(map[*MemDb]bool)
+	aof.mu.Unlock()
+	execCommands(m, "set a 1", "flushall async", "set b 1")
+	aof.mu.Lock()
+	_, touchedA := aof.touched[m]["a"]
+	_, touchedB := aof.touched[m]["b"]
+	if touchedA || !touchedB || !aof.flushedAll {
+		t.Error("flush is not recorded for the running rewrite")
+	}
+	aof.touched, aof.flushed, aof.flushedAll = nil, nil, false
+	aof.mu.Unlock()
+
+	execCommands(m, "set c 1", "flushdb", "set d 1")
+	if err = aof.Close(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewMemDb()
+	if err = LoadAof(path, loaded.dbs); err != nil {
+		t.Fatal(err)
+	}
+	keys := loaded.db.Keys()
+	if len(keys) != 1 || keys[0] != "d" {
+		t.Errorf("keys are %v after replaying flushes, expect [d]", keys)
+	}
+}
@@ -76,11 +76,11 @@ func lIndexList(m *MemDb, cmd [][]byte) resp.RedisData {
 	if !ok {
 		return resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
-	resNode := typeV.Index(index)
-	if resNode == nil {
+	val, ok := typeV.Index(index)
+	if !ok {
 		return resp.MakeBulkData(nil)
 	}
-	return resp.MakeBulkData(resNode.Val)
+	return resp.MakeBulkData(val)
 }
 
 func lPosList(m *MemDb, cmd [][]byte) resp.RedisData {
@@ -170,13 +170,13 @@ func lPosList(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 
 	// handle options
-	var now *ListNode
+	var now *listCursor
 	if rank {
 		if rankVal > 0 {
 			pos = -1
-			for now = list.Head.Next; now != list.Tail; now = now.Next {
+			for now = list.cursor(0); now.Valid(); now.Next() {
 				pos++
-				if bytes.Equal(now.Val, elem) {
+				if bytes.Equal(now.Val(), elem) {
 					rankVal--
 				}
 				if maxLen {
@@ -192,9 +192,9 @@ func lPosList(m *MemDb, cmd [][]byte) resp.RedisData {
 		} else {
 			reverse = true
 			pos = list.Len
-			for now = list.Tail.Prev; now != list.Head; now = now.Prev {
+			for now = list.cursor(-1); now.Valid(); now.Prev() {
 				pos--
-				if bytes.Equal(now.Val, elem) {
+				if bytes.Equal(now.Val(), elem) {
 					rankVal++
 				}
 				if maxLen {
@@ -209,7 +209,7 @@ func lPosList(m *MemDb, cmd [][]byte) resp.RedisData {
 			}
 		}
 	} else {
-		now = list.Head.Next
+		now = list.cursor(0)
 		pos = 0
 		if maxLen {
 			maxLenVal--
@@ -217,15 +217,15 @@ func lPosList(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 
 	// when rank is out of range, return nil
-	if (rank && rankVal != 0) || now == list.Tail || now == list.Head {
+	if (rank && rankVal != 0) || !now.Valid() {
 		return resp.MakeBulkData(nil)
 	}
 
 	res := make([]resp.RedisData, 0)
 	if !count {
 		// if count is not set, return first find pos inside maxLen range
-		for ; now != list.Tail; now = now.Next {
-			if bytes.Equal(now.Val, elem) {
+		for ; now.Valid(); now.Next() {
+			if bytes.Equal(now.Val(), elem) {
 				return resp.MakeIntData(int64(pos))
 			}
 			pos++
@@ -239,8 +239,8 @@ func lPosList(m *MemDb, cmd [][]byte) resp.RedisData {
 		return resp.MakeBulkData(nil)
 	} else {
 		if !reverse {
-			for ; now != list.Tail && countVal != 0; now = now.Next {
-				if bytes.Equal(now.Val, elem) {
+			for ; now.Valid() && countVal != 0; now.Next() {
+				if bytes.Equal(now.Val(), elem) {
 					res = append(res, resp.MakeIntData(int64(pos)))
 					countVal--
 				}
@@ -253,8 +253,8 @@ func lPosList(m *MemDb, cmd [][]byte) resp.RedisData {
 				}
 			}
 		} else {
-			for ; now != list.Head && countVal != 0; now = now.Prev {
-				if bytes.Equal(now.Val, elem) {
+			for ; now.Valid() && countVal != 0; now.Prev() {
+				if bytes.Equal(now.Val(), elem) {
 					res = append(res, resp.MakeIntData(int64(pos)))
 					countVal--
 				}
@@ -313,26 +313,31 @@ func lPopList(m *MemDb, cmd [][]byte) resp.RedisData {
 		if list.Len == 0 {
 			m.db.Delete(key)
 			m.DelTTL(key)
+			m.notify(notifyGeneric, "del", key)
 		}
 	}()
 
 	// if cnt is not set, return first element
 	if cnt == 0 {
-		e := list.LPop()
-		if e == nil {
+		val, ok := list.LPop()
+		if !ok {
 			return resp.MakeBulkData(nil)
 		}
-		return resp.MakeBulkData(e.Val)
+		m.notify(notifyList, "lpop", key)
+		return resp.MakeBulkData(val)
 	}
 
 	// return cnt number elements as array
 	res := make([]resp.RedisData, 0)
 	for i := 0; i < cnt; i++ {
-		e := list.LPop()
-		if e == nil {
+		val, ok := list.LPop()
+		if !ok {
 			break
 		}
-		res = append(res, resp.MakeBulkData(e.Val))
+		res = append(res, resp.MakeBulkData(val))
+	}
+	if len(res) > 0 {
+		m.notify(notifyList, "lpop", key)
 	}
 	return resp.MakeArrayData(res)
 }
@@ -375,26 +380,31 @@ func rPopList(m *MemDb, cmd [][]byte) resp.RedisData {
 		if list.Len == 0 {
 			m.db.Delete(key)
 			m.DelTTL(key)
+			m.notify(notifyGeneric, "del", key)
 		}
 	}()
 
 	// if cnt is not set, return last element
 	if cnt == 0 {
-		e := list.RPop()
-		if e == nil {
+		val, ok := list.RPop()
+		if !ok {
 			return resp.MakeBulkData(nil)
 		}
-		return resp.MakeBulkData(e.Val)
+		m.notify(notifyList, "rpop", key)
+		return resp.MakeBulkData(val)
 	}
 
 	// return cnt number elements as array
 	res := make([]resp.RedisData, 0)
 	for i := 0; i < cnt; i++ {
-		e := list.RPop()
-		if e == nil {
+		val, ok := list.RPop()
+		if !ok {
 			break
 		}
-		res = append(res, resp.MakeBulkData(e.Val))
+		res = append(res, resp.MakeBulkData(val))
+	}
+	if len(res) > 0 {
+		m.notify(notifyList, "rpop", key)
 	}
 	return resp.MakeArrayData(res)
 }
@@ -428,6 +438,7 @@ func lPushList(m *MemDb, cmd [][]byte) resp.RedisData {
 	for i := 2; i < len(cmd); i++ {
 		list.LPush(cmd[i])
 	}
+	m.notify(notifyList, "lpush", key)
 	return resp.MakeIntData(int64(list.Len))
 }
 
@@ -459,6 +470,7 @@ func lPushXList(m *MemDb, cmd [][]byte) resp.RedisData {
 	for i := 2; i < len(cmd); i++ {
 		list.LPush(cmd[i])
 	}
+	m.notify(notifyList, "lpush", key)
 	return resp.MakeIntData(int64(list.Len))
 }
 
@@ -491,6 +503,7 @@ func rPushList(m *MemDb, cmd [][]byte) resp.RedisData {
 	for i := 2; i < len(cmd); i++ {
 		list.RPush(cmd[i])
 	}
+	m.notify(notifyList, "rpush", key)
 	return resp.MakeIntData(int64(list.Len))
 }
 
@@ -522,6 +535,7 @@ func rPushXList(m *MemDb, cmd [][]byte) resp.RedisData {
 	for i := 2; i < len(cmd); i++ {
 		list.RPush(cmd[i])
 	}
+	m.notify(notifyList, "rpush", key)
 	return resp.MakeIntData(int64(list.Len))
 }
 
@@ -562,6 +576,7 @@ func lSetList(m *MemDb, cmd [][]byte) resp.RedisData {
 	if !success {
 		return resp.MakeErrorData("index out of range")
 	}
+	m.notify(notifyList, "lset", key)
 	return resp.MakeStringData("OK")
 }
 
@@ -602,10 +617,14 @@ func lRemList(m *MemDb, cmd [][]byte) resp.RedisData {
 		if list.Len == 0 {
 			m.db.Delete(key)
 			m.DelTTL(key)
+			m.notify(notifyGeneric, "del", key)
 		}
 	}()
 
 	res := list.RemoveElement(cmd[3], count)
+	if res > 0 {
+		m.notify(notifyList, "lrem", key)
+	}
 
 	return resp.MakeIntData(int64(res))
 }
@@ -645,10 +664,12 @@ func lTrimList(m *MemDb, cmd [][]byte) resp.RedisData {
 		if list.Len == 0 {
 			m.db.Delete(key)
 			m.DelTTL(key)
+			m.notify(notifyGeneric, "del", key)
 		}
 	}()
 
 	list.Trim(start, end)
+	m.notify(notifyList, "ltrim", key)
 	return resp.MakeStringData("OK")
 }
 
@@ -740,6 +761,7 @@ func lMoveList(m *MemDb, cmd [][]byte) resp.RedisData {
 		if srcList.Len == 0 {
 			m.db.Delete(src)
 			m.DelTTL(src)
+			m.notify(notifyGeneric, "del", src)
 		}
 	}()
 
@@ -759,20 +781,24 @@ func lMoveList(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 
 	// pop from src
-	var popElem *ListNode
+	var popElem []byte
 	if srcDrc == "left" {
-		popElem = srcList.LPop()
+		popElem, _ = srcList.LPop()
+		m.notify(notifyList, "lpop", src)
 	} else {
-		popElem = srcList.RPop()
+		popElem, _ = srcList.RPop()
+		m.notify(notifyList, "rpop", src)
 	}
 
 	//    insert to des
 	if desDrc == "left" {
-		desList.LPush(popElem.Val)
+		desList.LPush(popElem)
+		m.notify(notifyList, "lpush", des)
 	} else {
-		desList.RPush(popElem.Val)
+		desList.RPush(popElem)
+		m.notify(notifyList, "rpush", des)
 	}
-	return resp.MakeBulkData(popElem.Val)
+	return resp.MakeBulkData(popElem)
 }
 
 // TODO: blpop from list
@@ -789,17 +815,17 @@ func RegisterListCommands() {
 	RegisterCommand("llen", lLenList)
 	RegisterCommand("lindex", lIndexList)
 	RegisterCommand("lpos", lPosList)
-	RegisterCommand("lpop", lPopList)
-	RegisterCommand("rpop", rPopList)
-	RegisterCommand("lpush", lPushList)
-	RegisterCommand("lpushx", lPushXList)
-	RegisterCommand("rpush", rPushList)
-	RegisterCommand("rpushx", rPushXList)
-	RegisterCommand("lset", lSetList)
-	RegisterCommand("lrem", lRemList)
-	RegisterCommand("ltrim", lTrimList)
+	RegisterWriteCommand("lpop", lPopList, 1, 1, 1)
+	RegisterWriteCommand("rpop", rPopList, 1, 1, 1)
+	RegisterWriteCommand("lpush", lPushList, 1, 1, 1)
+	RegisterWriteCommand("lpushx", lPushXList, 1, 1, 1)
+	RegisterWriteCommand("rpush", rPushList, 1, 1, 1)
+	RegisterWriteCommand("rpushx", rPushXList, 1, 1, 1)
+	RegisterWriteCommand("lset", lSetList, 1, 1, 1)
+	RegisterWriteCommand("lrem", lRemList, 1, 1, 1)
+	RegisterWriteCommand("ltrim", lTrimList, 1, 1, 1)
 	RegisterCommand("lrange", lRangeList)
-	RegisterCommand("lmove", lMoveList)
+	RegisterWriteCommand("lmove", lMoveList, 1, 2, 1)
 	//RegisterCommand("blpop", blPopList)
 	//RegisterCommand("brpop", brPopList)
 }
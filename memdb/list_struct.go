@@ -1,122 +1,377 @@
 package memdb
 
-import "bytes"
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
 
-// List implements a double linked list for redis list
+	"github.com/VincentFF/thinredis/config"
+	"github.com/VincentFF/thinredis/logger"
+)
+
+// List implements the redis list as a quicklist, a double linked list of listpack nodes.
+// A node holds many elements packed together, so indexing skips whole nodes and each element costs
+// a few bytes instead of a list node. The size of nodes is limited by list-max-listpack-size.
+// Nodes more than list-compress-depth nodes away from both ends are compressed, only the ends are
+// pushed and popped frequently.
 type List struct {
-	Head *ListNode
-	Tail *ListNode
-	Len  int
+	head  *quicklistNode
+	tail  *quicklistNode
+	Len   int
+	nodes int
+	fill  int // list-max-listpack-size of the list
+	depth int // list-compress-depth of the list
 }
 
-type ListNode struct {
-	Prev *ListNode
-	Next *ListNode
-	Val  []byte
+type quicklistNode struct {
+	prev       *quicklistNode
+	next       *quicklistNode
+	lp         *listpack // nil if the node is compressed
+	compressed []byte    // the compressed listpack data
+	count      int       // number of elements
+	size       int       // bytes of the uncompressed listpack
 }
 
+const (
+	// listMinCompressBytes is the size under which a node is not worth compressing
+	listMinCompressBytes = 48
+	// listDefaultFill is the default list-max-listpack-size, nodes are limited to 8kb
+	listDefaultFill = -2
+)
+
+// listFillBytes are the node size limits of the negative list-max-listpack-size -1 to -5
+var listFillBytes = [...]int{4096, 8192, 16384, 32768, 65536}
+
+var flateWriters = sync.Pool{New: func() any {
+	w, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return w
+}}
+
 func NewList() *List {
-	head := &ListNode{}
-	tail := &ListNode{}
-	head.Next = tail
-	tail.Prev = head
-	return &List{Head: head, Tail: tail, Len: 0}
+	fill := config.Configures.ListMaxListpackSize
+	if fill == 0 || fill < -len(listFillBytes) {
+		fill = listDefaultFill
+	}
+	return &List{fill: fill, depth: config.Configures.ListCompressDepth}
 }
 
-func (l *List) Index(index int) *ListNode {
-	var node *ListNode
-	if index < 0 {
-		if -index > l.Len {
-			return nil
+// entries returns the listpack of n, a compressed node is decompressed to a new listpack which is not kept.
+// It doesn't modify n, so it is safe for readers holding a read lock.
+func (n *quicklistNode) entries() *listpack {
+	if n.lp != nil {
+		return n.lp
+	}
+	data, err := io.ReadAll(flate.NewReader(bytes.NewReader(n.compressed)))
+	if err != nil {
+		logger.Error("decompress list node error: ", err.Error())
+	}
+	return &listpack{data: data, count: n.count}
+}
+
+// update refreshes the count and size of n after its listpack is modified
+func (n *quicklistNode) update() {
+	n.count = n.lp.Len()
+	n.size = len(n.lp.data)
+}
+
+// bytes returns the memory used by the elements of n
+func (n *quicklistNode) bytes() int {
+	if n.lp == nil {
+		return cap(n.compressed)
+	}
+	return n.lp.Size()
+}
+
+func (n *quicklistNode) compress() {
+	if n.lp == nil || n.size < listMinCompressBytes {
+		return
+	}
+	var buf bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(n.lp.data); err != nil || w.Close() != nil || buf.Len() >= n.size {
+		return
+	}
+	n.compressed = buf.Bytes()
+	n.lp = nil
+}
+
+// unpack decompresses n to be modified and returns its listpack
+func (l *List) unpack(n *quicklistNode) *listpack {
+	if n.lp == nil {
+		n.lp = n.entries()
+		n.compressed = nil
+	}
+	return n.lp
+}
+
+// fits reports whether val can be added to n without exceeding list-max-listpack-size
+func (l *List) fits(n *quicklistNode, val []byte) bool {
+	if l.fill > 0 {
+		return n.count < l.fill
+	}
+	return n.size+len(val) <= listFillBytes[-l.fill-1]
+}
+
+// full reports whether n exceeds list-max-listpack-size and should be split
+func (l *List) full(n *quicklistNode) bool {
+	if n.count <= 1 {
+		return false
+	}
+	if l.fill > 0 {
+		return n.count > l.fill
+	}
+	return n.size > listFillBytes[-l.fill-1]
+}
+
+// interior reports whether n is more than depth nodes away from both ends
+func (l *List) interior(n *quicklistNode) bool {
+	head, tail := l.head, l.tail
+	for i := 0; i < l.depth; i++ {
+		if head == n || tail == n {
+			return false
 		}
-		node = l.Tail.Prev
-		for i := -1; i > index; i-- {
-			node = node.Prev
+		if head != nil {
+			head = head.next
 		}
+		if tail != nil {
+			tail = tail.prev
+		}
+	}
+	return true
+}
+
+// compressNode compresses n if it is an interior node
+func (l *List) compressNode(n *quicklistNode) {
+	if l.depth > 0 && l.interior(n) {
+		n.compress()
+	}
+}
+
+// balance keeps the nodes within depth of both ends decompressed and compresses the nodes next to them,
+// which become interior when nodes are added to the ends
+func (l *List) balance() {
+	if l.depth <= 0 {
+		return
+	}
+	head, tail := l.head, l.tail
+	for i := 0; i < l.depth && head != nil; i++ {
+		l.unpack(head)
+		head = head.next
+	}
+	for i := 0; i < l.depth && tail != nil; i++ {
+		l.unpack(tail)
+		tail = tail.prev
+	}
+	if head != nil {
+		l.compressNode(head)
+	}
+	if tail != nil {
+		l.compressNode(tail)
+	}
+}
+
+// insertNode links n after prev, or as the head if prev is nil
+func (l *List) insertNode(prev, n *quicklistNode) {
+	n.prev = prev
+	if prev == nil {
+		n.next = l.head
+		l.head = n
 	} else {
-		if index >= l.Len {
-			return nil
+		n.next = prev.next
+		prev.next = n
+	}
+	if n.next == nil {
+		l.tail = n
+	} else {
+		n.next.prev = n
+	}
+	l.nodes++
+}
+
+func (l *List) removeNode(n *quicklistNode) {
+	if n.prev == nil {
+		l.head = n.next
+	} else {
+		n.prev.next = n.next
+	}
+	if n.next == nil {
+		l.tail = n.prev
+	} else {
+		n.next.prev = n.prev
+	}
+	n.prev, n.next = nil, nil
+	l.nodes--
+}
+
+// split moves the second half of the elements of n to a new node after it
+func (l *List) split(n *quicklistNode) {
+	entries := l.unpack(n).Entries()
+	half := len(entries) / 2
+	n.lp = &listpack{}
+	n.lp.Append(entries[:half]...)
+	n.update()
+	next := &quicklistNode{lp: &listpack{}}
+	next.lp.Append(entries[half:]...)
+	next.update()
+	l.insertNode(n, next)
+	l.compressNode(n)
+	l.compressNode(next)
+}
+
+// locate returns the node of the element at index and its offset in the node.
+// A negative index counts from the tail. The node is nil if index is out of range.
+func (l *List) locate(index int) (*quicklistNode, int) {
+	if index < 0 {
+		index += l.Len
+	}
+	if index < 0 || index >= l.Len {
+		return nil, 0
+	}
+	if index < l.Len/2 {
+		for n := l.head; n != nil; n = n.next {
+			if index < n.count {
+				return n, index
+			}
+			index -= n.count
 		}
-		node = l.Head.Next
-		for i := 0; i < index; i++ {
-			node = node.Next
+		return nil, 0
+	}
+	index = l.Len - 1 - index
+	for n := l.tail; n != nil; n = n.prev {
+		if index < n.count {
+			return n, n.count - 1 - index
+		}
+		index -= n.count
+	}
+	return nil, 0
+}
+
+// nth returns the positions of the entry at offset of lp
+func nth(lp *listpack, offset int) (start, end int, val []byte) {
+	i := 0
+	lp.Range(func(pos, next int, entry []byte) bool {
+		if i == offset {
+			start, end, val = pos, next, entry
+			return false
+		}
+		i++
+		return true
+	})
+	return start, end, val
+}
+
+// Index returns the element at index, a negative index counts from the tail
+func (l *List) Index(index int) ([]byte, bool) {
+	n, offset := l.locate(index)
+	if n == nil {
+		return nil, false
+	}
+	_, _, val := nth(n.entries(), offset)
+	return val, true
+}
+
+// ForEach calls fn for every element from the head until fn returns false
+func (l *List) ForEach(fn func(val []byte) bool) {
+	for n := l.head; n != nil; n = n.next {
+		next := true
+		n.entries().Range(func(_, _ int, entry []byte) bool {
+			next = fn(entry)
+			return next
+		})
+		if !next {
+			return
 		}
 	}
-	return node
 }
 
 func (l *List) Pos(val []byte) int {
-	pos := 0
-	for now := l.Head.Next; now != l.Tail; now = now.Next {
-		if bytes.Equal(now.Val, val) {
-			return pos
+	pos, found := 0, false
+	l.ForEach(func(entry []byte) bool {
+		if bytes.Equal(entry, val) {
+			found = true
+			return false
 		}
 		pos++
+		return true
+	})
+	if found {
+		return pos
 	}
 	return -1
 }
 
 func (l *List) LPush(val []byte) {
-	node := &ListNode{Prev: l.Head, Next: l.Head.Next, Val: val}
-	l.Head.Next = node
-	node.Next.Prev = node
+	if l.head == nil || !l.fits(l.head, val) {
+		l.insertNode(nil, &quicklistNode{lp: &listpack{}})
+	}
+	l.unpack(l.head).replace(0, 0, 0, val)
+	l.head.update()
 	l.Len++
+	l.balance()
 }
 
 func (l *List) RPush(val []byte) {
-	node := &ListNode{Prev: l.Tail.Prev, Next: l.Tail, Val: val}
-	l.Tail.Prev = node
-	node.Prev.Next = node
+	if l.tail == nil || !l.fits(l.tail, val) {
+		l.insertNode(l.tail, &quicklistNode{lp: &listpack{}})
+	}
+	l.unpack(l.tail).Append(val)
+	l.tail.update()
 	l.Len++
+	l.balance()
 }
 
-func (l *List) LPop() *ListNode {
+// LPop removes and returns the head element, ok is false if the list is empty
+func (l *List) LPop() ([]byte, bool) {
 	if l.Len == 0 {
-		return nil
+		return nil, false
+	}
+	n := l.head
+	lp := l.unpack(n)
+	val, end := lp.next(0)
+	lp.replace(0, end, 1)
+	n.update()
+	if n.count == 0 {
+		l.removeNode(n)
 	}
-	node := l.Head.Next
-	l.Head.Next = node.Next
-	node.Next.Prev = l.Head
-	node.Prev = nil
-	node.Next = nil
 	l.Len--
-	return node
+	l.balance()
+	return val, true
 }
 
-func (l *List) RPop() *ListNode {
+// RPop removes and returns the tail element, ok is false if the list is empty
+func (l *List) RPop() ([]byte, bool) {
 	if l.Len == 0 {
-		return nil
+		return nil, false
+	}
+	n := l.tail
+	lp := l.unpack(n)
+	start, end, val := nth(lp, n.count-1)
+	lp.replace(start, end, 1)
+	n.update()
+	if n.count == 0 {
+		l.removeNode(n)
 	}
-	node := l.Tail.Prev
-	l.Tail.Prev = node.Prev
-	node.Prev.Next = l.Tail
-	node.Prev = nil
-	node.Next = nil
 	l.Len--
-	return node
+	l.balance()
+	return val, true
 }
 
 func (l *List) Set(index int, val []byte) bool {
-	if index < 0 {
-		if -index > l.Len {
-			return false
-		}
-		node := l.Tail
-		for node != l.Head && index < 0 {
-			node = node.Prev
-			index++
-		}
-		node.Val = val
+	n, offset := l.locate(index)
+	if n == nil {
+		return false
+	}
+	lp := l.unpack(n)
+	start, end, _ := nth(lp, offset)
+	lp.replace(start, end, 1, val)
+	n.update()
+	if l.full(n) {
+		l.split(n)
 	} else {
-		if index >= l.Len {
-			return false
-		}
-		node := l.Head
-		for node != l.Tail && index >= 0 {
-			node = node.Next
-			index--
-		}
-		node.Val = val
+		l.compressNode(n)
 	}
 	return true
 }
@@ -141,52 +396,62 @@ func (l *List) Range(start, end int) [][]byte {
 	}
 
 	res := make([][]byte, 0, end-start+1)
-	node := l.Head
-	for i := 0; i <= end; i++ {
-		node = node.Next
-		if i >= start {
-			res = append(res, node.Val)
-		}
+	n, offset := l.locate(start)
+	for ; n != nil && len(res) < cap(res); n = n.next {
+		i := 0
+		n.entries().Range(func(_, _ int, entry []byte) bool {
+			if i >= offset {
+				res = append(res, entry)
+			}
+			i++
+			return len(res) < cap(res)
+		})
+		offset = 0
 	}
 	return res
 }
 
-func (l *List) InsertBefore(val []byte, tar []byte) int {
+// insert inserts val before or after the first element equal to tar, it returns the index of val or -1
+func (l *List) insert(val []byte, tar []byte, after bool) int {
 	pos := 0
-	ok := false
-	for now := l.Head.Next; now != l.Tail; now = now.Next {
-		if bytes.Equal(now.Val, tar) {
-			ok = true
-			node := &ListNode{Prev: now.Prev, Next: now, Val: val}
-			now.Prev = node
-			node.Prev.Next = node
-			break
+	for n := l.head; n != nil; n = n.next {
+		start, end, found := 0, 0, false
+		n.entries().Range(func(p, next int, entry []byte) bool {
+			if bytes.Equal(entry, tar) {
+				start, end, found = p, next, true
+				return false
+			}
+			pos++
+			return true
+		})
+		if !found {
+			continue
+		}
+		lp := l.unpack(n)
+		if after {
+			lp.replace(end, end, 0, val)
+			pos++
+		} else {
+			lp.replace(start, start, 0, val)
+		}
+		n.update()
+		l.Len++
+		if l.full(n) {
+			l.split(n)
+		} else {
+			l.compressNode(n)
 		}
-		pos++
-	}
-	if ok {
 		return pos
 	}
 	return -1
 }
 
+func (l *List) InsertBefore(val []byte, tar []byte) int {
+	return l.insert(val, tar, false)
+}
+
 func (l *List) InsertAfter(val []byte, tar []byte) int {
-	pos := 0
-	ok := false
-	for now := l.Head.Next; now != l.Tail; now = now.Next {
-		if bytes.Equal(now.Val, tar) {
-			ok = true
-			node := &ListNode{Prev: now, Next: now.Next, Val: val}
-			now.Next = node
-			node.Next.Prev = node
-			break
-		}
-		pos++
-	}
-	if ok {
-		return pos + 1
-	}
-	return -1
+	return l.insert(val, tar, true)
 }
 
 // RemoveElement remove count number elements with Val=val from list, if count is 0, remove all elements.
@@ -197,44 +462,97 @@ func (l *List) RemoveElement(val []byte, count int) int {
 		return 0
 	}
 
-	if count == 0 {
-		count = l.Len - 1
+	reverse := count < 0
+	if count < 0 {
+		count = -count
+	} else if count == 0 {
+		count = l.Len
 	}
 
 	removed := 0
-	var tem *ListNode
-	if count >= 0 {
-		for now := l.Head.Next; now != l.Tail && removed < count; {
-			if bytes.Equal(now.Val, val) {
-				tem = now.Next
-				now.Prev.Next = now.Next
-				now.Next.Prev = now.Prev
-				now.Prev = nil
-				now.Next = nil
-				removed++
-				now = tem
-			} else {
-				now = now.Next
+	n := l.head
+	if reverse {
+		n = l.tail
+	}
+	for n != nil && removed < count {
+		next := n.next
+		if reverse {
+			next = n.prev
+		}
+		entries := n.entries().Entries()
+		drop := make([]bool, len(entries))
+		dropped := 0
+		for i := range entries {
+			j := i
+			if reverse {
+				j = len(entries) - 1 - i
+			}
+			if removed+dropped < count && bytes.Equal(entries[j], val) {
+				drop[j] = true
+				dropped++
 			}
 		}
-	} else {
-		for now := l.Tail.Prev; now != l.Head && removed < -count; {
-			if bytes.Equal(now.Val, val) {
-				tem = now.Prev
-				now.Prev.Next = now.Next
-				now.Next.Prev = now.Prev
-				now.Prev = nil
-				now.Next = nil
-				removed++
-				now = tem
+		if dropped > 0 {
+			lp := &listpack{}
+			for i, entry := range entries {
+				if !drop[i] {
+					lp.Append(entry)
+				}
+			}
+			n.lp, n.compressed = lp, nil
+			n.update()
+			if n.count == 0 {
+				l.removeNode(n)
 			} else {
-				now = now.Prev
+				l.compressNode(n)
 			}
+			removed += dropped
 		}
+		n = next
 	}
+	l.Len -= removed
+	l.balance()
 	return removed
 }
 
+// removeFront removes the first count elements
+func (l *List) removeFront(count int) {
+	for count > 0 && l.head != nil {
+		n := l.head
+		if n.count <= count {
+			count -= n.count
+			l.Len -= n.count
+			l.removeNode(n)
+			continue
+		}
+		lp := l.unpack(n)
+		_, end, _ := nth(lp, count-1)
+		lp.replace(0, end, count)
+		n.update()
+		l.Len -= count
+		count = 0
+	}
+}
+
+// removeBack removes the last count elements
+func (l *List) removeBack(count int) {
+	for count > 0 && l.tail != nil {
+		n := l.tail
+		if n.count <= count {
+			count -= n.count
+			l.Len -= n.count
+			l.removeNode(n)
+			continue
+		}
+		lp := l.unpack(n)
+		start, _, _ := nth(lp, n.count-count)
+		lp.replace(start, len(lp.data), count)
+		n.update()
+		l.Len -= count
+		count = 0
+	}
+}
+
 func (l *List) Trim(start, end int) {
 	if l.Len == 0 {
 		return
@@ -258,49 +576,82 @@ func (l *List) Trim(start, end int) {
 		end = l.Len - 1
 	}
 
-	var startNode, endNode *ListNode
-	pos := 0
-	for now := l.Head.Next; now != l.Tail; now = now.Next {
-		if pos == start {
-			startNode = now
-		}
-		if pos == end {
-			endNode = now
-			break
+	l.removeBack(l.Len - 1 - end)
+	l.removeFront(start)
+	l.balance()
+}
+
+func (l *List) Clear() {
+	l.head, l.tail = nil, nil
+	l.Len, l.nodes = 0, 0
+}
+
+// Encoding returns quicklist, a list is always a quicklist
+func (l *List) Encoding() string {
+	return "quicklist"
+}
+
+// Copy returns a deep copy of l, the values are copied too
+func (l *List) Copy() *List {
+	res := &List{Len: l.Len, fill: l.fill, depth: l.depth}
+	for n := l.head; n != nil; n = n.next {
+		node := &quicklistNode{count: n.count, size: n.size}
+		if n.lp != nil {
+			node.lp = n.lp.Copy()
+		} else {
+			node.compressed = copyBytes(n.compressed)
 		}
-		pos++
+		res.insertNode(res.tail, node)
 	}
+	return res
+}
 
-	//	remove discarded nodes for gc
-	l.Head.Next.Prev = nil
-	l.Tail.Prev.Next = nil
-	if startNode.Prev != nil {
-		startNode.Prev.Next = nil
-	}
-	if endNode.Next != nil {
-		endNode.Next.Prev = nil
+// listCursor points at an element of a List and moves in both directions.
+// It is invalid after the list is modified.
+type listCursor struct {
+	node    *quicklistNode
+	entries [][]byte // elements of node
+	offset  int
+}
+
+// cursor returns a cursor at index, a negative index counts from the tail
+func (l *List) cursor(index int) *listCursor {
+	n, offset := l.locate(index)
+	c := &listCursor{node: n, offset: offset}
+	if n != nil {
+		c.entries = n.entries().Entries()
 	}
+	return c
+}
 
-	//	link trimmed nodes to head and tail
-	l.Head.Next = startNode
-	startNode.Prev = l.Head
-	l.Tail.Prev = endNode
-	endNode.Next = l.Tail
-	l.Len = end - start + 1
+// Valid reports whether c points at an element, it is false after c moves past an end
+func (c *listCursor) Valid() bool {
+	return c.node != nil
 }
 
-func (l *List) Clear() {
-	if l.Len == 0 {
+func (c *listCursor) Val() []byte {
+	return c.entries[c.offset]
+}
+
+func (c *listCursor) Next() {
+	c.offset++
+	if c.offset < len(c.entries) {
 		return
 	}
+	c.node, c.offset = c.node.next, 0
+	if c.node != nil {
+		c.entries = c.node.entries().Entries()
+	}
+}
 
-	fist := l.Head.Next
-	last := l.Tail.Prev
-	l.Head.Next = l.Tail
-	l.Tail.Prev = l.Head
-	l.Len = 0
-
-	//	 gc will remove the list
-	fist.Prev = nil
-	last.Next = nil
+func (c *listCursor) Prev() {
+	c.offset--
+	if c.offset >= 0 {
+		return
+	}
+	c.node = c.node.prev
+	if c.node != nil {
+		c.entries = c.node.entries().Entries()
+		c.offset = len(c.entries) - 1
+	}
 }
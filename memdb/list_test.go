@@ -2,6 +2,9 @@ package memdb
 
 import (
 	"bytes"
+	"math/rand"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/VincentFF/thinredis/config"
@@ -82,3 +85,140 @@ func TestLRemList(t *testing.T) {
 		t.Error("lrem error")
 	}
 }
+
+// checkList compares l with the expected elements and checks the node counts
+func checkList(t *testing.T, l *List, expect [][]byte, op string) {
+	t.Helper()
+	if l.Len != len(expect) {
+		t.Fatalf("after %s list length is %d, expect %d", op, l.Len, len(expect))
+	}
+	got := l.Range(0, -1)
+	for i := range expect {
+		if !bytes.Equal(got[i], expect[i]) {
+			t.Fatalf("after %s element %d is %s, expect %s", op, i, got[i], expect[i])
+		}
+	}
+	count, nodes := 0, 0
+	for n := l.head; n != nil; n = n.next {
+		if n.count == 0 {
+			t.Fatalf("after %s an empty node is left", op)
+		}
+		count += n.count
+		nodes++
+	}
+	if count != l.Len || nodes != l.nodes {
+		t.Fatalf("after %s nodes hold %d elements in %d nodes, expect %d in %d", op, count, nodes, l.Len, l.nodes)
+	}
+}
+
+func TestQuicklist(t *testing.T) {
+	l := NewList()
+	l.fill, l.depth = 4, 1
+	var expect [][]byte
+	elem := func(i int) []byte {
+		return []byte(strconv.Itoa(i) + strings.Repeat("v", 20))
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1500; i++ {
+		var op string
+		switch rnd.Intn(9) {
+		case 0, 1:
+			op = "lpush"
+			l.LPush(elem(i))
+			expect = append([][]byte{elem(i)}, expect...)
+		case 2, 3:
+			op = "rpush"
+			l.RPush(elem(i))
+			expect = append(expect, elem(i))
+		case 4:
+			op = "lpop"
+			val, ok := l.LPop()
+			if ok != (len(expect) > 0) || ok && !bytes.Equal(val, expect[0]) {
+				t.Fatalf("lpop returns %s, %v", val, ok)
+			}
+			if ok {
+				expect = expect[1:]
+			}
+		case 5:
+			op = "rpop"
+			val, ok := l.RPop()
+			if ok != (len(expect) > 0) || ok && !bytes.Equal(val, expect[len(expect)-1]) {
+				t.Fatalf("rpop returns %s, %v", val, ok)
+			}
+			if ok {
+				expect = expect[:len(expect)-1]
+			}
+		case 6:
+			op = "set"
+			if len(expect) > 0 {
+				index := rnd.Intn(len(expect))
+				l.Set(index-len(expect)*rnd.Intn(2), elem(i))
+				expect[index] = elem(i)
+			}
+		case 7:
+			op = "insert"
+			if len(expect) > 0 {
+				index := rnd.Intn(len(expect))
+				tar := expect[index]
+				if l.InsertAfter(elem(i), tar) != index+1 {
+					t.Fatal("insert after returns a wrong index")
+				}
+				expect = append(expect[:index+1], append([][]byte{elem(i)}, expect[index+1:]...)...)
+			}
+		case 8:
+			op = "lindex"
+			if len(expect) > 0 {
+				index := rnd.Intn(len(expect))
+				if val, _ := l.Index(index); !bytes.Equal(val, expect[index]) {
+					t.Fatalf("index %d is %s, expect %s", index, val, expect[index])
+				}
+				if val, _ := l.Index(index - len(expect)); !bytes.Equal(val, expect[index]) {
+					t.Fatalf("index %d is %s, expect %s", index-len(expect), val, expect[index])
+				}
+			}
+		}
+		checkList(t, l, expect, op)
+	}
+
+	compressed := 0
+	for n := l.head; n != nil; n = n.next {
+		if n.lp == nil {
+			compressed++
+		}
+	}
+	if l.nodes > 2 && (compressed == 0 || l.head.lp == nil || l.tail.lp == nil) {
+		t.Errorf("%d of %d nodes are compressed, expect only the interior nodes", compressed, l.nodes)
+	}
+
+	l.Trim(10, -11)
+	expect = expect[10 : len(expect)-10]
+	checkList(t, l, expect, "trim")
+
+	target := expect[len(expect)/2]
+	for _, count := range []int{1, -1, 0} {
+		removed := l.RemoveElement(target, count)
+		var left [][]byte
+		for _, val := range expect {
+			if !bytes.Equal(val, target) {
+				left = append(left, val)
+			}
+		}
+		if removed > 0 {
+			expect = left
+		}
+		checkList(t, l, expect, "lrem")
+	}
+	l.Trim(5, 2)
+	checkList(t, l, nil, "trim")
+}
+
+func BenchmarkListIndex(b *testing.B) {
+	l := NewList()
+	for i := 0; i < 1000000; i++ {
+		l.RPush([]byte(strconv.Itoa(i)))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Index(i * 7919 % l.Len)
+	}
+}
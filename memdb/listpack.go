@@ -0,0 +1,83 @@
+package memdb
+
+import "encoding/binary"
+
+// listpack is a list of byte entries packed into one byte slice, like the listpack of redis.
+// Each entry is its length as an uvarint followed by its bytes, so a small collection costs
+// one or two bytes per element instead of a slice header, a list node or a map entry.
+// Entries returned by a listpack share its memory, so existing entries are never modified in place:
+// replace builds a new slice, and Append only writes after the last entry.
+type listpack struct {
+	data  []byte
+	count int
+}
+
+// Len returns the number of entries
+func (lp *listpack) Len() int {
+	return lp.count
+}
+
+// Size returns the bytes of the packed entries
+func (lp *listpack) Size() int {
+	return cap(lp.data)
+}
+
+// next returns the entry at pos and the position of the entry after it
+func (lp *listpack) next(pos int) ([]byte, int) {
+	n, size := binary.Uvarint(lp.data[pos:])
+	start := pos + size
+	end := start + int(n)
+	return lp.data[start:end:end], end
+}
+
+// Append adds entries to the end
+func (lp *listpack) Append(entries ...[]byte) {
+	for _, entry := range entries {
+		lp.data = binary.AppendUvarint(lp.data, uint64(len(entry)))
+		lp.data = append(lp.data, entry...)
+	}
+	lp.count += len(entries)
+}
+
+// Range calls fn for every entry in order until fn returns false.
+// pos is the position of the entry, end is the position after it.
+func (lp *listpack) Range(fn func(pos, end int, entry []byte) bool) {
+	for pos := 0; pos < len(lp.data); {
+		entry, end := lp.next(pos)
+		if !fn(pos, end, entry) {
+			return
+		}
+		pos = end
+	}
+}
+
+// Entries returns all entries in order
+func (lp *listpack) Entries() [][]byte {
+	res := make([][]byte, 0, lp.count)
+	lp.Range(func(_, _ int, entry []byte) bool {
+		res = append(res, entry)
+		return true
+	})
+	return res
+}
+
+// replace replaces the removed entries between positions start and end with entries
+func (lp *listpack) replace(start, end, removed int, entries ...[]byte) {
+	size := len(lp.data) - (end - start)
+	for _, entry := range entries {
+		size += binary.MaxVarintLen64 + len(entry)
+	}
+	data := make([]byte, start, size)
+	copy(data, lp.data[:start])
+	for _, entry := range entries {
+		data = binary.AppendUvarint(data, uint64(len(entry)))
+		data = append(data, entry...)
+	}
+	lp.data = append(data, lp.data[end:]...)
+	lp.count += len(entries) - removed
+}
+
+// Copy returns a deep copy of lp
+func (lp *listpack) Copy() *listpack {
+	return &listpack{data: copyBytes(lp.data), count: lp.count}
+}
@@ -0,0 +1,379 @@
+package memdb
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/VincentFF/thinredis/logger"
+	"github.com/VincentFF/thinredis/resp"
+)
+
+// memory.go implements the memory accounting and access statistics of keys, and the OBJECT and MEMORY commands.
+// The memory of a key is estimated from its value after every write command which modifies it.
+// Collections are sized by sampling some of their elements, like MEMORY USAGE of redis does,
+// so the estimation costs O(samples) instead of O(elements).
+
+const (
+	// memorySamples is the number of elements sampled to estimate the size of a collection
+	memorySamples = 5
+	// keyOverhead is the estimated size of a key entry in the db map, besides the key and the value
+	keyOverhead = 64
+	// sliceOverhead is the size of a slice header
+	sliceOverhead = 24
+	// quicklistNodeOverhead is the size of a quicklist node and its listpack, besides the packed elements
+	quicklistNodeOverhead = 96
+	// mapEntryOverhead is the estimated size of an entry of a set or hash, besides the member and the value
+	mapEntryOverhead = 32
+	// zsetNodeOverhead is the estimated size of a skiplist node of a sorted set, besides the member
+	zsetNodeOverhead = 80
+	// collectionOverhead is the estimated size of an empty list, set or hash
+	collectionOverhead = 48
+
+	// lfuInitVal is the lfu counter of a new key, so that it is not evicted before it has a chance to be accessed
+	lfuInitVal = 5
+	// lfuLogFactor makes the lfu counter logarithmic, about a million accesses saturate it
+	lfuLogFactor = 10
+	// lfuDecayTime is the number of milliseconds the lfu counter is decremented by one
+	lfuDecayTime = 60 * 1000
+)
+
+// value types of the memory accounting
+const (
+	kindString = iota
+	kindList
+	kindSet
+	kindHash
+	kindZSet
+	kindNum // number of value types
+)
+
+var kindNames = [kindNum]string{"string", "list", "set", "hash", "zset"}
+
+func valueKind(val any) int {
+	switch val.(type) {
+	case *List:
+		return kindList
+	case *Set:
+		return kindSet
+	case *Hash:
+		return kindHash
+	case *ZSet:
+		return kindZSet
+	}
+	return kindString
+}
+
+// keyMeta holds the memory accounting and the access statistics of a key
+type keyMeta struct {
+	size   atomic.Int64  // estimated memory used by the key and its value in bytes
+	kind   int           // type of the value accounted in size, it is written with the lock of the key held
+	access atomic.Int64  // unix milliseconds of the last access
+	freq   atomic.Uint32 // lfu counter, a logarithmic access frequency
+}
+
+// valueSize estimates the memory used by a db value. At most samples elements of a collection are read.
+func valueSize(val any, samples int) int64 {
+	switch v := val.(type) {
+	case []byte:
+		return int64(sliceOverhead + cap(v))
+	case *List:
+		sampled, size := 0, 0
+		for node := v.head; node != nil && sampled < samples; node = node.next {
+			size += node.bytes()
+			sampled++
+		}
+		return int64(collectionOverhead + v.nodes*quicklistNodeOverhead + averageTimes(size, sampled, v.nodes))
+	case *Set:
+		if v.table == nil {
+			return int64(collectionOverhead + cap(v.intset)*8)
+		}
+		sampled, size := 0, 0
+		v.table.Range(func(member string, _ void) bool {
+			if sampled == samples {
+				return false
+			}
+			size += len(member)
+			sampled++
+			return true
+		})
+		return int64(collectionOverhead + v.Len()*(mapEntryOverhead+16) + averageTimes(size, sampled, v.Len()))
+	case *Hash:
+		if v.table == nil {
+			return int64(collectionOverhead + v.listpack.Size())
+		}
+		sampled, size := 0, 0
+		v.table.Range(func(field string, value []byte) bool {
+			if sampled == samples {
+				return false
+			}
+			size += len(field) + cap(value)
+			sampled++
+			return true
+		})
+		return int64(collectionOverhead + v.Len()*(mapEntryOverhead+16+sliceOverhead) + averageTimes(size, sampled, v.Len()))
+	case *ZSet:
+		// the member string is shared by the dict entry and the skiplist node
+		sampled, size := 0, 0
+		for member := range v.dict {
+			if sampled == samples {
+				break
+			}
+			size += len(member)
+			sampled++
+		}
+		return int64(collectionOverhead + v.Len()*(mapEntryOverhead+16+zsetNodeOverhead) + averageTimes(size, sampled, v.Len()))
+	}
+	return 0
+}
+
+// averageTimes returns the average of sampled elements of total size, times n
+func averageTimes(size, sampled, n int) int {
+	if sampled == 0 {
+		return 0
+	}
+	return size * n / sampled
+}
+
+// accountKey updates the estimated memory of key after it is modified.
+// The key is forgotten if it does not exist any more.
+func (m *MemDb) accountKey(key string) {
+	m.locks.Lock(key)
+	defer m.locks.UnLock(key)
+	val, ok := m.db.Get(key)
+	if !ok {
+		m.forgetKey(key)
+		return
+	}
+	meta := m.keyMetaOf(key)
+	size := int64(keyOverhead+len(key)) + valueSize(val, memorySamples)
+	old := meta.size.Swap(size)
+	m.usedMemory.Add(size - old)
+	m.dbs.usedMemory.Add(size - old)
+	m.typeMemory[meta.kind].Add(-old)
+	meta.kind = valueKind(val)
+	m.typeMemory[meta.kind].Add(size)
+}
+
+// keyMetaOf returns the meta of key, a new key is created as accessed now. The caller must hold the lock of key.
+func (m *MemDb) keyMetaOf(key string) *keyMeta {
+	if tem, ok := m.meta.Get(key); ok {
+		return tem.(*keyMeta)
+	}
+	meta := &keyMeta{}
+	meta.access.Store(time.Now().UnixMilli())
+	meta.freq.Store(lfuInitVal)
+	m.meta.Set(key, meta)
+	return meta
+}
+
+// setAccess sets the last access time and the lfu counter of key. The caller must hold the lock of key.
+func (m *MemDb) setAccess(key string, access int64, freq uint32) {
+	meta := m.keyMetaOf(key)
+	meta.access.Store(access)
+	meta.freq.Store(freq)
+}
+
+// forgetKey drops the accounting of a deleted key. The caller must hold the lock of key.
+func (m *MemDb) forgetKey(key string) {
+	tem, ok := m.meta.Get(key)
+	if !ok {
+		return
+	}
+	meta := tem.(*keyMeta)
+	m.meta.Delete(key)
+	old := meta.size.Swap(0)
+	m.usedMemory.Add(-old)
+	m.dbs.usedMemory.Add(-old)
+	m.typeMemory[meta.kind].Add(-old)
+}
+
+// touch records an access of key for the lru and lfu eviction
+func (m *MemDb) touch(key string) {
+	tem, ok := m.meta.Get(key)
+	if !ok {
+		return
+	}
+	meta := tem.(*keyMeta)
+	now := time.Now().UnixMilli()
+	meta.freq.Store(lfuLogIncr(lfuDecr(meta, now)))
+	meta.access.Store(now)
+}
+
+// lfuDecr returns the lfu counter of meta decremented by the decay periods elapsed since the last access
+func lfuDecr(meta *keyMeta, now int64) uint32 {
+	counter := meta.freq.Load()
+	periods := (now - meta.access.Load()) / lfuDecayTime
+	if periods <= 0 {
+		return counter
+	}
+	if periods >= int64(counter) {
+		return 0
+	}
+	return counter - uint32(periods)
+}
+
+// lfuLogIncr increments counter with a probability decreasing as counter grows
+func lfuLogIncr(counter uint32) uint32 {
+	if counter >= 255 {
+		return 255
+	}
+	base := 0.0
+	if counter > lfuInitVal {
+		base = float64(counter - lfuInitVal)
+	}
+	if rand.Float64() < 1.0/(base*lfuLogFactor+1) {
+		counter++
+	}
+	return counter
+}
+
+// stringEncoding returns the encoding redis uses for a string value
+func stringEncoding(val []byte) string {
+	if len(val) <= 20 {
+		if n, err := strconv.ParseInt(string(val), 10, 64); err == nil && strconv.FormatInt(n, 10) == string(val) {
+			return "int"
+		}
+	}
+	if len(val) <= 44 {
+		return "embstr"
+	}
+	return "raw"
+}
+
+// valueEncoding returns the encoding reported by OBJECT ENCODING
+func valueEncoding(val any) string {
+	switch v := val.(type) {
+	case []byte:
+		return stringEncoding(v)
+	case *List:
+		return v.Encoding()
+	case *Set:
+		return v.Encoding()
+	case *Hash:
+		return v.Encoding()
+	case *ZSet:
+		return v.Encoding()
+	}
+	return "unknown"
+}
+
+// objectKey handles OBJECT ENCODING|IDLETIME|FREQ|REFCOUNT key.
+// The access time and lfu counter are always tracked, so IDLETIME and FREQ work with any maxmemory policy.
+func objectKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "object" {
+		logger.Error("objectKey Function: cmdName is not object")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) != 3 {
+		return resp.MakeErrorData("wrong number of arguments for 'object' command")
+	}
+	subCmd := strings.ToLower(string(cmd[1]))
+	if subCmd != "encoding" && subCmd != "idletime" && subCmd != "freq" && subCmd != "refcount" {
+		return resp.MakeErrorData(fmt.Sprintf("error: unsupported object subcommand %s", string(cmd[1])))
+	}
+
+	// OBJECT doesn't count as an access of key
+	key := string(cmd[2])
+	if !m.alive(key) {
+		return resp.MakeBulkData(nil)
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+	val, ok := m.db.Get(key)
+	if !ok {
+		return resp.MakeBulkData(nil)
+	}
+
+	switch subCmd {
+	case "encoding":
+		return resp.MakeBulkData([]byte(valueEncoding(val)))
+	case "refcount":
+		// values are never shared between keys
+		return resp.MakeIntData(1)
+	}
+	var access int64
+	var freq uint32
+	now := time.Now().UnixMilli()
+	if tem, ok := m.meta.Get(key); ok {
+		meta := tem.(*keyMeta)
+		access, freq = meta.access.Load(), lfuDecr(meta, now)
+	} else {
+		access, freq = now, lfuInitVal
+	}
+	if subCmd == "idletime" {
+		return resp.MakeIntData((now - access) / 1000)
+	}
+	return resp.MakeIntData(int64(freq))
+}
+
+// memoryKey handles MEMORY USAGE|STATS|DOCTOR|PURGE.
+func memoryKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "memory" {
+		logger.Error("memoryKey Function: cmdName is not memory")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 2 {
+		return resp.MakeErrorData("wrong number of arguments for 'memory' command")
+	}
+	subCmd := strings.ToLower(string(cmd[1]))
+	if subCmd == "usage" {
+		return memoryUsage(m, cmd)
+	}
+	if subCmd != "stats" && subCmd != "doctor" && subCmd != "purge" {
+		return resp.MakeErrorData(fmt.Sprintf("error: unsupported memory subcommand %s", string(cmd[1])))
+	}
+	if len(cmd) != 2 {
+		return resp.MakeErrorData(fmt.Sprintf("wrong number of arguments for 'memory %s' command", subCmd))
+	}
+	switch subCmd {
+	case "stats":
+		return memoryStatsReply(m)
+	case "doctor":
+		return resp.MakeBulkData([]byte(m.memoryDoctor()))
+	}
+	return memoryPurge()
+}
+
+// memoryUsage handles MEMORY USAGE key [SAMPLES count]. SAMPLES 0 reads all elements of a collection.
+func memoryUsage(m *MemDb, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 3 && len(cmd) != 5 {
+		return resp.MakeErrorData("wrong number of arguments for 'memory usage' command")
+	}
+	samples := memorySamples
+	if len(cmd) == 5 {
+		if strings.ToLower(string(cmd[3])) != "samples" {
+			return resp.MakeErrorData("error: syntax error")
+		}
+		var err error
+		samples, err = strconv.Atoi(string(cmd[4]))
+		if err != nil || samples < 0 {
+			return resp.MakeErrorData("error: value is out of range, must be positive")
+		}
+		if samples == 0 {
+			samples = math.MaxInt
+		}
+	}
+
+	key := string(cmd[2])
+	if !m.alive(key) {
+		return resp.MakeBulkData(nil)
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+	val, ok := m.db.Get(key)
+	if !ok {
+		return resp.MakeBulkData(nil)
+	}
+	return resp.MakeIntData(int64(keyOverhead+len(key)) + valueSize(val, samples))
+}
+
+func RegisterMemoryCommands() {
+	RegisterCommand("object", objectKey)
+	RegisterCommand("memory", memoryKey)
+	RegisterCommand("analyze", analyzeKeys)
+}
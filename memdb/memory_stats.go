@@ -0,0 +1,221 @@
+package memdb
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/VincentFF/thinredis/resp"
+)
+
+// memory_stats.go implements MEMORY STATS, MEMORY DOCTOR and MEMORY PURGE.
+// The dataset bytes come from the accounting of keys, the overheads of the db structures are estimated
+// from their sizes, and the heap and gc figures are read from the go runtime.
+
+var (
+	// shardOverhead is the estimated size of an empty shard of a ConcurrentMap
+	shardOverhead = int64(unsafe.Sizeof(shard{})+unsafe.Sizeof(sync.RWMutex{})) + collectionOverhead + 8
+	// lockOverhead is the size of a lock of Locks
+	lockOverhead = int64(unsafe.Sizeof(sync.RWMutex{})) + 8
+	// metaEntryOverhead is the estimated size of the access statistics of a key
+	metaEntryOverhead = int64(unsafe.Sizeof(keyMeta{})) + mapEntryOverhead + 32
+	// ttlEntryOverhead is the estimated size of a ttl in ttlKeys and in the expire index
+	ttlEntryOverhead = int64(unsafe.Sizeof(expireEntry{})) + 2*mapEntryOverhead + 72
+)
+
+const (
+	// doctorMinDataset is the used memory under which MEMORY DOCTOR gives no advice
+	doctorMinDataset = 5 << 20
+	// doctorBigKeySize is the size from which MEMORY DOCTOR reports a key as big
+	doctorBigKeySize = 16 << 20
+	// doctorBigKeysShown is the max number of big keys listed by MEMORY DOCTOR
+	doctorBigKeysShown = 5
+	// doctorFragmentation is the fragmentation ratio from which MEMORY DOCTOR reports fragmentation
+	doctorFragmentation = 1.4
+	// doctorIdleHeap is the idle heap size from which MEMORY DOCTOR suggests MEMORY PURGE
+	doctorIdleHeap = 64 << 20
+	// doctorClientsBytes is the size of client buffers from which MEMORY DOCTOR reports them
+	doctorClientsBytes = 32 << 20
+	// doctorGCFraction is the gc cpu fraction from which MEMORY DOCTOR reports the gc
+	doctorGCFraction = 0.05
+)
+
+// memoryStats is a breakdown of the memory used by the server
+type memoryStats struct {
+	dataset      int64
+	types        [kindNum]int64
+	keys         int
+	shards       int64 // shards of db, ttlKeys and the access statistics
+	keyMeta      int64 // access statistics of keys
+	locks        int64
+	ttlIndex     int64
+	clients      int
+	clientsBytes int64
+	runtime      runtime.MemStats
+}
+
+// memoryStats returns the memory breakdown of the server, the dataset and overheads of all databases are summed
+func (m *MemDb) memoryStats() *memoryStats {
+	stats := &memoryStats{dataset: m.dbs.usedMemory.Load()}
+	for _, db := range m.dbs.list() {
+		stats.keys += db.db.Len()
+		stats.shards += int64(db.db.ShardNum()+db.ttlKeys.ShardNum()+db.meta.ShardNum()) * shardOverhead
+		stats.keyMeta += int64(db.meta.Len()) * metaEntryOverhead
+		stats.locks += int64(len(db.locks.locks)) * lockOverhead
+		stats.ttlIndex += int64(db.ttlKeys.Len()) * ttlEntryOverhead
+		for i := range stats.types {
+			stats.types[i] += db.typeMemory[i].Load()
+		}
+	}
+	if m.dbs.clientsMemory != nil {
+		stats.clients, stats.clientsBytes = m.dbs.clientsMemory()
+	}
+	runtime.ReadMemStats(&stats.runtime)
+	return stats
+}
+
+func (s *memoryStats) overhead() int64 {
+	return s.shards + s.keyMeta + s.locks + s.ttlIndex + s.clientsBytes
+}
+
+// fragmentation is the ratio of the heap spans in use to the allocated heap objects
+func (s *memoryStats) fragmentation() float64 {
+	if s.runtime.HeapAlloc == 0 {
+		return 1
+	}
+	return float64(s.runtime.HeapInuse) / float64(s.runtime.HeapAlloc)
+}
+
+// memoryStatsReply replies MEMORY STATS as an array of field names and values
+func memoryStatsReply(m *MemDb) resp.RedisData {
+	s := m.memoryStats()
+	bytesPerKey := int64(0)
+	if s.keys > 0 {
+		bytesPerKey = s.dataset / int64(s.keys)
+	}
+	percentage := 0.0
+	if s.runtime.HeapAlloc > 0 {
+		percentage = float64(s.dataset) * 100 / float64(s.runtime.HeapAlloc)
+	}
+
+	res := make([]resp.RedisData, 0, 64)
+	addInt := func(name string, val int64) {
+		res = append(res, resp.MakeBulkData([]byte(name)), resp.MakeIntData(val))
+	}
+	addFloat := func(name string, val float64) {
+		res = append(res, resp.MakeBulkData([]byte(name)), resp.MakeBulkData([]byte(fmt.Sprintf("%.4f", val))))
+	}
+	addInt("total.allocated", int64(s.runtime.HeapAlloc))
+	addInt("dataset.bytes", s.dataset)
+	for i, name := range kindNames {
+		addInt("dataset."+name+".bytes", s.types[i])
+	}
+	addFloat("dataset.percentage", percentage)
+	addInt("keys.count", int64(s.keys))
+	addInt("keys.bytes-per-key", bytesPerKey)
+	addInt("overhead.db.shards", s.shards)
+	addInt("overhead.db.keymeta", s.keyMeta)
+	addInt("overhead.locks", s.locks)
+	addInt("overhead.ttl.index", s.ttlIndex)
+	addInt("overhead.total", s.overhead())
+	addInt("clients.count", int64(s.clients))
+	addInt("clients.bytes", s.clientsBytes)
+	addInt("heap.alloc", int64(s.runtime.HeapAlloc))
+	addInt("heap.inuse", int64(s.runtime.HeapInuse))
+	addInt("heap.idle", int64(s.runtime.HeapIdle))
+	addInt("heap.released", int64(s.runtime.HeapReleased))
+	addInt("heap.sys", int64(s.runtime.HeapSys))
+	addInt("heap.objects", int64(s.runtime.HeapObjects))
+	addInt("sys", int64(s.runtime.Sys))
+	addInt("gc.count", int64(s.runtime.NumGC))
+	addInt("gc.pause.total.ms", int64(s.runtime.PauseTotalNs/1e6))
+	addFloat("gc.cpu.fraction", s.runtime.GCCPUFraction)
+	addFloat("fragmentation", s.fragmentation())
+	addInt("fragmentation.bytes", int64(s.runtime.HeapInuse)-int64(s.runtime.HeapAlloc))
+	return resp.MakeArrayData(res)
+}
+
+// bigKeys returns the keys of all databases whose accounted size is at least size, the biggest first.
+// The keys are prefixed by their db index, such as db3:key.
+func (m *MemDb) bigKeys(size int64) ([]string, []int64) {
+	type bigKey struct {
+		key  string
+		size int64
+	}
+	var found []bigKey
+	for index, db := range m.dbs.list() {
+		db.meta.Range(func(key string, val any) bool {
+			if s := val.(*keyMeta).size.Load(); s >= size {
+				found = append(found, bigKey{"db" + strconv.Itoa(index) + ":" + key, s})
+			}
+			return true
+		})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].size > found[j].size })
+	keys, sizes := make([]string, len(found)), make([]int64, len(found))
+	for i, k := range found {
+		keys[i], sizes[i] = k.key, k.size
+	}
+	return keys, sizes
+}
+
+// memoryDoctor returns human readable advice about the memory issues found
+func (m *MemDb) memoryDoctor() string {
+	s := m.memoryStats()
+	if s.dataset < doctorMinDataset && s.runtime.HeapAlloc < doctorMinDataset {
+		return "This instance is empty or is using very little memory, the issues detector can't be used in these conditions."
+	}
+
+	var issues []string
+	if frag := s.fragmentation(); frag > doctorFragmentation && s.runtime.HeapInuse-s.runtime.HeapAlloc > doctorMinDataset {
+		issues = append(issues, fmt.Sprintf("High fragmentation: the heap spans in use are %.2f times the allocated objects (%s wasted). "+
+			"This is usual after many keys are deleted or shrunk, the go runtime reuses the free space for new allocations.",
+			frag, humanSize(int64(s.runtime.HeapInuse-s.runtime.HeapAlloc))))
+	}
+	if idle := s.runtime.HeapIdle - s.runtime.HeapReleased; idle > doctorIdleHeap {
+		issues = append(issues, fmt.Sprintf("Idle heap: %s of free heap is not returned to the operating system yet. "+
+			"Run MEMORY PURGE to release it now, otherwise the go runtime releases it gradually.", humanSize(int64(idle))))
+	}
+	if keys, sizes := m.bigKeys(doctorBigKeySize); len(keys) > 0 {
+		shown := make([]string, 0, doctorBigKeysShown)
+		for i := 0; i < len(keys) && i < doctorBigKeysShown; i++ {
+			shown = append(shown, fmt.Sprintf("%s (%s)", keys[i], humanSize(sizes[i])))
+		}
+		issues = append(issues, fmt.Sprintf("Big keys: %d keys are larger than %s, the biggest are %s. "+
+			"Big keys make commands, deletions and evictions slow, consider splitting them into smaller keys.",
+			len(keys), humanSize(doctorBigKeySize), strings.Join(shown, ", ")))
+	}
+	if s.clientsBytes > doctorClientsBytes {
+		issues = append(issues, fmt.Sprintf("Big client buffers: %d clients use %s of buffers. "+
+			"This is usually caused by pub/sub clients which can't read messages as fast as they are published.",
+			s.clients, humanSize(s.clientsBytes)))
+	}
+	if m.maxMemory > 0 && s.dataset*10 >= m.maxMemory*9 {
+		advice := "Keys are evicted to make room for new data."
+		if m.maxMemoryPolicy == policyNoEviction || m.maxMemoryPolicy == "" {
+			advice = "Write commands are rejected when it is reached, consider raising maxmemory or setting an eviction policy."
+		}
+		issues = append(issues, fmt.Sprintf("Near maxmemory: %s of %s maxmemory is used. %s",
+			humanSize(s.dataset), humanSize(m.maxMemory), advice))
+	}
+	if s.runtime.GCCPUFraction > doctorGCFraction {
+		issues = append(issues, fmt.Sprintf("Busy garbage collector: the gc uses %.1f%% of the cpu time. "+
+			"Consider raising GOGC or setting GOMEMLIMIT to collect less often.", s.runtime.GCCPUFraction*100))
+	}
+
+	if len(issues) == 0 {
+		return "No memory issue is found in this instance."
+	}
+	return "A few memory issues are found in this instance:\n\n * " + strings.Join(issues, "\n\n * ")
+}
+
+// memoryPurge returns the free heap to the operating system
+func memoryPurge() resp.RedisData {
+	debug.FreeOSMemory()
+	return resp.MakeStringData("OK")
+}
@@ -0,0 +1,210 @@
+package memdb
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VincentFF/thinredis/resp"
+)
+
+func TestObjectKey(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "set int 12345", "set str hello", "set raw "+string(bytes.Repeat([]byte("a"), 45)),
+		"rpush list a", "sadd set a", "hset hash f v")
+	for key, encoding := range map[string]string{
+		"int": "int", "str": "embstr", "raw": "raw", "list": "quicklist", "set": "hashtable", "hash": "hashtable",
+	} {
+		res := objectKey(memdb, [][]byte{[]byte("object"), []byte("encoding"), []byte(key)})
+		if !bytes.Equal(res.ByteData(), []byte(encoding)) {
+			t.Errorf("encoding of %s is %s, expect %s", key, res.ByteData(), encoding)
+		}
+	}
+
+	tem, _ := memdb.meta.Get("str")
+	tem.(*keyMeta).access.Store(time.Now().UnixMilli() - 10500)
+	res := objectKey(memdb, [][]byte{[]byte("object"), []byte("idletime"), []byte("str")})
+	if !bytes.Equal(res.ToBytes(), []byte(":10\r\n")) {
+		t.Errorf("idletime is %q, expect 10", res.ToBytes())
+	}
+	// OBJECT is not an access
+	res = objectKey(memdb, [][]byte{[]byte("object"), []byte("idletime"), []byte("str")})
+	if !bytes.Equal(res.ToBytes(), []byte(":10\r\n")) {
+		t.Error("object should not update the access time")
+	}
+	memdb.CheckTTL("str")
+	res = objectKey(memdb, [][]byte{[]byte("object"), []byte("idletime"), []byte("str")})
+	if !bytes.Equal(res.ToBytes(), []byte(":0\r\n")) {
+		t.Error("access should reset the idle time")
+	}
+
+	res = objectKey(memdb, [][]byte{[]byte("object"), []byte("freq"), []byte("int")})
+	if !bytes.Equal(res.ToBytes(), []byte(":"+strconv.Itoa(lfuInitVal)+"\r\n")) {
+		t.Errorf("freq of a new key is %q, expect %d", res.ToBytes(), lfuInitVal)
+	}
+	res = objectKey(memdb, [][]byte{[]byte("object"), []byte("refcount"), []byte("int")})
+	if !bytes.Equal(res.ToBytes(), []byte(":1\r\n")) {
+		t.Error("refcount should be 1")
+	}
+	res = objectKey(memdb, [][]byte{[]byte("object"), []byte("encoding"), []byte("nokey")})
+	if !bytes.Equal(res.ToBytes(), []byte("$-1\r\n")) {
+		t.Error("object of a not exist key should be nil")
+	}
+}
+
+func TestRestoreAccess(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "set a v")
+	payload := dumpKey(memdb, [][]byte{[]byte("dump"), []byte("a")}).ByteData()
+	restoreKey(memdb, [][]byte{[]byte("restore"), []byte("b"), []byte("0"), payload,
+		[]byte("idletime"), []byte("100"), []byte("freq"), []byte("42")})
+	res := objectKey(memdb, [][]byte{[]byte("object"), []byte("idletime"), []byte("b")})
+	if !bytes.Equal(res.ToBytes(), []byte(":100\r\n")) {
+		t.Errorf("idletime of restored key is %q, expect 100", res.ToBytes())
+	}
+	res = objectKey(memdb, [][]byte{[]byte("object"), []byte("freq"), []byte("b")})
+	if !bytes.Equal(res.ToBytes(), []byte(":41\r\n")) {
+		t.Errorf("freq of restored key is %q, expect 41 after 100 seconds of decay", res.ToBytes())
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "set str 0123456789")
+	res := memoryKey(memdb, [][]byte{[]byte("memory"), []byte("usage"), []byte("str")})
+	tem, _ := memdb.meta.Get("str")
+	if res.(*resp.IntData).Data() != tem.(*keyMeta).size.Load() {
+		t.Error("memory usage should equal to the accounted size")
+	}
+
+	// nodes of different sizes, the estimation depends on the samples
+	list := NewList()
+	list.fill = 10
+	for i := 0; i < 100; i++ {
+		list.RPush(bytes.Repeat([]byte("a"), i+1))
+	}
+	memdb.db.Set("list", list)
+	sampled := memoryKey(memdb, [][]byte{[]byte("memory"), []byte("usage"), []byte("list"), []byte("samples"), []byte("1")})
+	all := memoryKey(memdb, [][]byte{[]byte("memory"), []byte("usage"), []byte("list"), []byte("samples"), []byte("0")})
+	if sampled.(*resp.IntData).Data() >= all.(*resp.IntData).Data() {
+		t.Error("sampling the first small node should estimate less than reading all elements")
+	}
+
+	res = memoryKey(memdb, [][]byte{[]byte("memory"), []byte("usage"), []byte("nokey")})
+	if !bytes.Equal(res.ToBytes(), []byte("$-1\r\n")) {
+		t.Error("memory usage of a not exist key should be nil")
+	}
+}
+
+func TestMemoryStats(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "set s hello", "rpush l a b c", "sadd set a b", "hset h f v", "zadd z 1 a", "set t v ex 100")
+	sum := int64(0)
+	for i := range memdb.typeMemory {
+		if memdb.typeMemory[i].Load() <= 0 {
+			t.Errorf("%s memory is not accounted", kindNames[i])
+		}
+		sum += memdb.typeMemory[i].Load()
+	}
+	if sum != memdb.usedMemory.Load() {
+		t.Errorf("memory of types sums to %d, expect %d", sum, memdb.usedMemory.Load())
+	}
+	// a key changing its type moves its memory to the new type
+	execCommands(memdb, "del s t", "rpush s a")
+	if memdb.typeMemory[kindString].Load() != 0 {
+		t.Errorf("string memory is %d after all strings are deleted, expect 0", memdb.typeMemory[kindString].Load())
+	}
+
+	memdb.dbs.SetClientsMemory(func() (int, int64) { return 2, 1000 })
+	res := memoryKey(memdb, [][]byte{[]byte("memory"), []byte("stats")}).(*resp.ArrayData).Data()
+	stats := make(map[string]string)
+	for i := 0; i+1 < len(res); i += 2 {
+		stats[string(res[i].ByteData())] = string(res[i+1].ByteData())
+	}
+	expect := map[string]string{
+		"dataset.bytes":        strconv.FormatInt(memdb.usedMemory.Load(), 10),
+		"dataset.string.bytes": "0",
+		"keys.count":           "5",
+		"clients.count":        "2",
+		"clients.bytes":        "1000",
+		"overhead.locks":       strconv.FormatInt(int64(len(memdb.locks.locks))*lockOverhead, 10),
+	}
+	for name, val := range expect {
+		if stats[name] != val {
+			t.Errorf("%s is %s, expect %s", name, stats[name], val)
+		}
+	}
+	if stats["heap.alloc"] == "" || stats["heap.alloc"] == "0" || stats["fragmentation"] == "" {
+		t.Error("runtime memory is not reported")
+	}
+
+	res2 := memoryKey(memdb, [][]byte{[]byte("memory"), []byte("doctor")})
+	if !bytes.Contains(res2.ByteData(), []byte("very little memory")) {
+		t.Errorf("unexpected doctor advice for a small dataset: %s", res2.ByteData())
+	}
+	res2 = memoryKey(memdb, [][]byte{[]byte("memory"), []byte("purge")})
+	if !bytes.Equal(res2.ToBytes(), []byte("+OK\r\n")) {
+		t.Errorf("purge replies %q, expect OK", res2.ToBytes())
+	}
+}
+
+func TestMemoryDoctor(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "set big "+string(bytes.Repeat([]byte("a"), doctorBigKeySize)), "set small v")
+	memdb.maxMemory = memdb.usedMemory.Load()
+	advice := memdb.memoryDoctor()
+	if !strings.Contains(advice, "Big keys: 1 keys") || !strings.Contains(advice, "db0:big (") {
+		t.Errorf("big key is not reported: %s", advice)
+	}
+	if !strings.Contains(advice, "Near maxmemory") || !strings.Contains(advice, "raising maxmemory") {
+		t.Errorf("maxmemory is not reported: %s", advice)
+	}
+}
+
+func TestMemoryDoctorAllDbs(t *testing.T) {
+	d := NewDatabases(4)
+	execIn(d, 3, "set big "+string(bytes.Repeat([]byte("a"), doctorBigKeySize)))
+	execIn(d, 0, "set small v")
+	advice := d.Db(0).memoryDoctor()
+	if !strings.Contains(advice, "Big keys: 1 keys") || !strings.Contains(advice, "db3:big (") {
+		t.Errorf("big key of db 3 is not reported from db 0: %s", advice)
+	}
+}
+
+func TestPersistenceIsNotAccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := NewAof(path, FsyncNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memdb := NewMemDb()
+	memdb.dbs.SetAof(aof)
+	execCommands(memdb, "set a 1", "rpush l x", "expire l 100000")
+	for _, key := range []string{"a", "l"} {
+		tem, _ := memdb.meta.Get(key)
+		tem.(*keyMeta).access.Store(time.Now().UnixMilli() - 3600500)
+	}
+	freq := objectKey(memdb, [][]byte{[]byte("object"), []byte("freq"), []byte("a")}).ToBytes()
+
+	if err = SaveSnapshot(filepath.Join(t.TempDir(), "dump.tdb"), memdb.dbs); err != nil {
+		t.Fatal(err)
+	}
+	if err = aof.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	if err = aof.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "l"} {
+		res := objectKey(memdb, [][]byte{[]byte("object"), []byte("idletime"), []byte(key)})
+		if !bytes.Equal(res.ToBytes(), []byte(":3600\r\n")) {
+			t.Errorf("idletime of %s is %q after save and rewrite, expect 3600", key, res.ToBytes())
+		}
+	}
+	if res := objectKey(memdb, [][]byte{[]byte("object"), []byte("freq"), []byte("a")}).ToBytes(); !bytes.Equal(res, freq) {
+		t.Errorf("freq is %q after save and rewrite, expect %q", res, freq)
+	}
+}
@@ -0,0 +1,128 @@
+package memdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/VincentFF/thinredis/logger"
+	"github.com/VincentFF/thinredis/resp"
+)
+
+// notify.go implements keyspace notifications and the PUBLISH and PUBSUB commands.
+// A change of key in db n emits the event name to channel __keyspace@n__:<key> and the key to channel __keyevent@n__:<event>,
+// if the class of the event is enabled by notify-keyspace-events.
+
+// keyspace notification classes, see notify-keyspace-events in redis.conf
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g, type independent commands like del, expire and rename
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZset                 // z
+	notifyExpired              // x, keys deleted because they are expired
+	notifyEvicted              // e, keys evicted for maxmemory
+	// notifyAll is the alias "A"
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZset | notifyExpired | notifyEvicted
+)
+
+var notifyClassFlags = map[rune]int{
+	'K': notifyKeyspace,
+	'E': notifyKeyevent,
+	'g': notifyGeneric,
+	'$': notifyString,
+	'l': notifyList,
+	's': notifySet,
+	'h': notifyHash,
+	'z': notifyZset,
+	'x': notifyExpired,
+	'e': notifyEvicted,
+	'A': notifyAll,
+}
+
+// ParseNotifyClasses parses a notify-keyspace-events class string such as "KEA" or "Kx" to notification flags.
+// Nothing is notified unless K or E is given.
+func ParseNotifyClasses(classes string) (int, error) {
+	flags := 0
+	for _, c := range classes {
+		flag, ok := notifyClassFlags[c]
+		if !ok {
+			return 0, fmt.Errorf("invalid notify-keyspace-events class %q", c)
+		}
+		flags |= flag
+	}
+	return flags, nil
+}
+
+// notify emits event of class on key to the keyspace and keyevent channels
+func (m *MemDb) notify(class int, event, key string) {
+	flags := m.notifyFlags
+	if flags&class == 0 {
+		return
+	}
+	index := strconv.FormatInt(m.index.Load(), 10)
+	if flags&notifyKeyspace != 0 {
+		m.hub.Publish("__keyspace@"+index+"__:"+key, []byte(event))
+	}
+	if flags&notifyKeyevent != 0 {
+		m.hub.Publish("__keyevent@"+index+"__:"+event, []byte(key))
+	}
+}
+
+func publishChannel(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "publish" {
+		logger.Error("publishChannel Function: cmdName is not publish")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) != 3 {
+		return resp.MakeErrorData("wrong number of arguments for 'publish' command")
+	}
+	return resp.MakeIntData(int64(m.hub.Publish(string(cmd[1]), cmd[2])))
+}
+
+// pubSubChannel handles PUBSUB CHANNELS [pattern], PUBSUB NUMSUB [channel ...] and PUBSUB NUMPAT
+func pubSubChannel(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "pubsub" {
+		logger.Error("pubSubChannel Function: cmdName is not pubsub")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 2 {
+		return resp.MakeErrorData("wrong number of arguments for 'pubsub' command")
+	}
+
+	switch strings.ToLower(string(cmd[1])) {
+	case "channels":
+		if len(cmd) > 3 {
+			return resp.MakeErrorData("wrong number of arguments for 'pubsub channels' command")
+		}
+		var pattern string
+		if len(cmd) == 3 {
+			pattern = string(cmd[2])
+		}
+		res := make([]resp.RedisData, 0)
+		for _, channel := range m.hub.Channels(pattern) {
+			res = append(res, resp.MakeBulkData([]byte(channel)))
+		}
+		return resp.MakeArrayData(res)
+	case "numsub":
+		res := make([]resp.RedisData, 0, 2*(len(cmd)-2))
+		for _, channel := range cmd[2:] {
+			res = append(res, resp.MakeBulkData(channel), resp.MakeIntData(int64(m.hub.NumSub(string(channel)))))
+		}
+		return resp.MakeArrayData(res)
+	case "numpat":
+		if len(cmd) != 2 {
+			return resp.MakeErrorData("wrong number of arguments for 'pubsub numpat' command")
+		}
+		return resp.MakeIntData(int64(m.hub.NumPat()))
+	}
+	return resp.MakeErrorData(fmt.Sprintf("error: unsupported pubsub subcommand %s", string(cmd[1])))
+}
+
+func RegisterPubSubCommands() {
+	RegisterCommand("publish", publishChannel)
+	RegisterCommand("pubsub", pubSubChannel)
+}
@@ -0,0 +1,71 @@
+package memdb
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+type eventRecorder struct {
+	messages [][]byte
+}
+
+func (r *eventRecorder) Push(data []byte) {
+	r.messages = append(r.messages, data)
+}
+
+func TestParseNotifyClasses(t *testing.T) {
+	flags, err := ParseNotifyClasses("KEA")
+	if err != nil || flags != notifyKeyspace|notifyKeyevent|notifyAll {
+		t.Error("parse KEA error")
+	}
+	flags, err = ParseNotifyClasses("")
+	if err != nil || flags != 0 {
+		t.Error("parse empty classes error")
+	}
+	if _, err = ParseNotifyClasses("Kq"); err == nil {
+		t.Error("parse invalid class should fail")
+	}
+}
+
+func TestNotify(t *testing.T) {
+	memdb := NewMemDb()
+	rec := &eventRecorder{}
+	memdb.hub.PSubscribe(rec, []string{"__keyevent@0__:*"})
+
+	// nothing is notified by default
+	setString(memdb, [][]byte{[]byte("set"), []byte("a"), []byte("1")})
+	if len(rec.messages) != 0 {
+		t.Error("notification should be disabled by default")
+	}
+
+	memdb.notifyFlags, _ = ParseNotifyClasses("Eg$x")
+	setString(memdb, [][]byte{[]byte("set"), []byte("a"), []byte("1"), []byte("px"), []byte("100000")})
+	lPushList(memdb, [][]byte{[]byte("lpush"), []byte("l"), []byte("v")})
+	delKey(memdb, [][]byte{[]byte("del"), []byte("a"), []byte("nokey")})
+	memdb.db.Set("b", []byte("v"))
+	memdb.ttlKeys.Set("b", time.Now().UnixMilli()-1)
+	memdb.CheckTTL("b")
+
+	events := []string{"set:a", "expire:a", "del:a", "expired:b"}
+	if len(rec.messages) != len(events) {
+		t.Fatalf("got %d events, expect %d", len(rec.messages), len(events))
+	}
+	for i, event := range events {
+		name, key, _ := bytes.Cut([]byte(event), []byte(":"))
+		channel := append([]byte("__keyevent@0__:"), name...)
+		if !bytes.Contains(rec.messages[i], channel) || !bytes.HasSuffix(rec.messages[i], append(key, '\r', '\n')) {
+			t.Errorf("event %d is %q, expect %s", i, rec.messages[i], event)
+		}
+	}
+
+	// keyspace channels get the event name
+	memdb.notifyFlags, _ = ParseNotifyClasses("Kl")
+	memdb.hub.Subscribe(rec, []string{"__keyspace@0__:l"})
+	lPopList(memdb, [][]byte{[]byte("lpop"), []byte("l")})
+	want := []byte("*3\r\n$7\r\nmessage\r\n$16\r\n__keyspace@0__:l\r\n$4\r\nlpop\r\n")
+	got := rec.messages[len(events):]
+	if len(got) != 1 || !bytes.Equal(got[0], want) {
+		t.Errorf("keyspace events are %q, expect lpop only because g is disabled", got)
+	}
+}
@@ -0,0 +1,168 @@
+package memdb
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/VincentFF/thinredis/config"
+	"github.com/VincentFF/thinredis/logger"
+	"github.com/VincentFF/thinredis/rdb"
+	"github.com/VincentFF/thinredis/resp"
+)
+
+// rdb.go loads the native RDB files produced by Redis into MemDb.
+
+// rdbValue converts a value parsed from an RDB file to the value stored in MemDb
+func rdbValue(e *rdb.Entry) (any, error) {
+	switch v := e.Value.(type) {
+	case []byte:
+		return v, nil
+	case [][]byte:
+		if e.Type.Name() == "list" {
+			list := NewList()
+			for _, val := range v {
+				list.RPush(val)
+			}
+			return list, nil
+		}
+		set := NewSet()
+		for _, member := range v {
+			set.Add(string(member))
+		}
+		return set, nil
+	case map[string][]byte:
+		hash := NewHash()
+		for field, value := range v {
+			hash.Set(field, value)
+		}
+		return hash, nil
+	case []rdb.ZSetMember:
+		zset := NewZSet()
+		for _, member := range v {
+			if math.IsNaN(member.Score) {
+				return nil, fmt.Errorf("%w: sorted set %q has a nan score", rdb.ErrBadFormat, e.Key)
+			}
+			zset.Add(string(member.Member), member.Score)
+		}
+		return zset, nil
+	}
+	return nil, &rdb.UnsupportedTypeError{Key: e.Key, Type: e.Type}
+}
+
+// LoadRdb loads keys from the Redis RDB file at path into the databases of d. Keys that have already expired are skipped.
+// It returns an error if the file holds a value type or a db index that thinredis can not represent.
+func LoadRdb(path string, d *Databases) error {
+	fl, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := fl.Close(); err != nil {
+			logger.Error("close rdb file error: ", err.Error())
+		}
+	}()
+
+	now := time.Now().UnixMilli()
+	loaded := 0
+	err = rdb.Parse(fl, func(e *rdb.Entry) error {
+		if e.DB < 0 || e.DB >= d.Len() {
+			return fmt.Errorf("key %q is in db %d, but only %d databases are configured", e.Key, e.DB, d.Len())
+		}
+		m := d.Db(e.DB)
+		if e.ExpireAt >= 0 && e.ExpireAt <= now {
+			return nil
+		}
+		val, err := rdbValue(e)
+		if err != nil {
+			return err
+		}
+		m.locks.Lock(e.Key)
+		m.db.Set(e.Key, val)
+		m.DelTTL(e.Key)
+		if e.ExpireAt >= 0 {
+			m.SetTTL(e.Key, e.ExpireAt)
+		}
+		m.locks.UnLock(e.Key)
+		m.accountKey(e.Key)
+		loaded++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("load rdb file %s error: %w", path, err)
+	}
+	logger.Info("load ", loaded, " keys from rdb file ", path)
+	return nil
+}
+
+// replaceWith deletes all keys of m and moves all keys of other into m
+func (m *MemDb) replaceWith(other *MemDb) {
+	m.locks.LockAll()
+	m.clearKeys()
+	m.locks.UnLockAll()
+	for _, key := range other.db.Keys() {
+		val, ok := other.db.Get(key)
+		if !ok {
+			continue
+		}
+		m.locks.Lock(key)
+		m.db.Set(key, val)
+		if ttl, ok := other.ttlKeys.Get(key); ok {
+			m.SetTTL(key, ttl.(int64))
+		}
+		m.locks.UnLock(key)
+		m.accountKey(key)
+	}
+}
+
+func debugReload(m *MemDb, cmd [][]byte) resp.RedisData {
+	path := config.Configures.RdbFile
+	if len(cmd) == 3 {
+		path = string(cmd[2])
+	}
+	if path == "" {
+		return resp.MakeErrorData("error: no rdb file is given")
+	}
+
+	// load into new databases first, so that a broken file leaves the current keys untouched
+	loaded := NewDatabases(m.dbs.Len())
+	if err := LoadRdb(path, loaded); err != nil {
+		logger.Error(err.Error())
+		return resp.MakeErrorData("error: " + err.Error())
+	}
+	for i, db := range m.dbs.list() {
+		db.replaceWith(loaded.Db(i))
+	}
+	if m.aof != nil {
+		if err := m.aof.Rewrite(); err != nil {
+			logger.Error("rewrite aof after reload error: ", err.Error())
+			return resp.MakeErrorData("error: keys are reloaded, but rewrite aof failed: " + err.Error())
+		}
+	}
+	return resp.MakeStringData("OK")
+}
+
+func debugKeys(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "debug" {
+		logger.Error("debugKeys Function: cmdName is not debug")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 2 {
+		return resp.MakeErrorData("wrong number of arguments for 'debug' command")
+	}
+
+	switch strings.ToLower(string(cmd[1])) {
+	case "reload":
+		if len(cmd) > 3 {
+			return resp.MakeErrorData("wrong number of arguments for 'debug reload' command")
+		}
+		return debugReload(m, cmd)
+	}
+	return resp.MakeErrorData(fmt.Sprintf("error: unsupported debug subcommand %s", string(cmd[1])))
+}
+
+func RegisterDebugCommands() {
+	RegisterCommand("debug", debugKeys)
+}
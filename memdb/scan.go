@@ -0,0 +1,221 @@
+package memdb
+
+import (
+	"container/heap"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/VincentFF/thinredis/resp"
+	"github.com/VincentFF/thinredis/util"
+)
+
+// scan.go implements the cursors of SCAN, SSCAN and HSCAN.
+// Go maps have no stable iteration order, so elements are visited in the order of their hashes instead:
+// a cursor is the hash the next page starts from, and a page holds the elements of the about COUNT smallest hashes after it.
+// An element present for the whole scan is returned exactly once, because elements never move between hashes,
+// and all elements of the same hash are returned in the same page.
+// The keyspace cursor also holds the shard position in its high 32 bits, so that a call only walks the shards it returns keys of.
+// The map encoding of a set or hash keeps its members in buckets ordered by hash, see bucketMap,
+// so a call walks the buckets from the one of the cursor and a page costs O(COUNT + skipped buckets).
+
+const scanDefaultCount = 10
+
+// scanOptions are the options of SCAN, SSCAN and HSCAN
+type scanOptions struct {
+	count    int
+	pattern  string
+	kind     int // the value type TYPE filters by, -1 if TYPE is not given
+	noValues bool
+}
+
+// parseScanOptions parses [MATCH pattern] [COUNT count] and the TYPE or NOVALUES options allowed by the command
+func parseScanOptions(args [][]byte, allowType, allowNoValues bool) (scanOptions, resp.RedisData) {
+	opts := scanOptions{count: scanDefaultCount, kind: -1}
+	for i := 0; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		if option == "novalues" && allowNoValues {
+			opts.noValues = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return opts, resp.MakeErrorData("error: syntax error")
+		}
+		i++
+		switch {
+		case option == "match":
+			opts.pattern = string(args[i])
+		case option == "count":
+			count, err := strconv.Atoi(string(args[i]))
+			if err != nil || count <= 0 {
+				return opts, resp.MakeErrorData("count value must be a positive integer")
+			}
+			opts.count = count
+		case option == "type" && allowType:
+			opts.kind = -1
+			for kind, name := range kindNames {
+				if strings.ToLower(string(args[i])) == name {
+					opts.kind = kind
+				}
+			}
+			if opts.kind == -1 {
+				return opts, resp.MakeErrorData("error: unknown type name " + string(args[i]))
+			}
+		default:
+			return opts, resp.MakeErrorData("error: syntax error")
+		}
+	}
+	return opts, nil
+}
+
+func (o scanOptions) match(member string) bool {
+	return o.pattern == "" || util.PattenMatch(o.pattern, member)
+}
+
+func parseCursor(arg []byte) (uint64, resp.RedisData) {
+	cursor, err := strconv.ParseUint(string(arg), 10, 64)
+	if err != nil {
+		return 0, resp.MakeErrorData("error: invalid cursor")
+	}
+	return cursor, nil
+}
+
+func scanReply(cursor uint64, elements []resp.RedisData) resp.RedisData {
+	return resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte(strconv.FormatUint(cursor, 10))),
+		resp.MakeArrayData(elements),
+	})
+}
+
+// scanHash hashes member with fnv32a and mixes the result, so that the top bits picking a bucket of a bucketMap
+// are as well distributed as the low ones. It doesn't allocate, as it is computed by every access of a bucketMap.
+func scanHash(member string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(member); i++ {
+		h ^= uint32(member[i])
+		h *= 16777619
+	}
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// maxHashHeap implements heap.Interface, the biggest hash is on the top
+type maxHashHeap []uint32
+
+func (h maxHashHeap) Len() int { return len(h) }
+
+func (h maxHashHeap) Less(i, j int) bool { return h[i] > h[j] }
+
+func (h maxHashHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *maxHashHeap) Push(x any) { *h = append(*h, x.(uint32)) }
+
+func (h *maxHashHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// scanBound returns the last hash of the page starting from hash from, which is the count-th smallest hash at or after from.
+// It returns math.MaxUint32 if fewer elements are left, so that the page holds all of them.
+// walk calls its argument for every element.
+func scanBound(walk func(fn func(member string)), from uint32, count int) uint32 {
+	hashes := make(maxHashHeap, 0)
+	walk(func(member string) {
+		h := scanHash(member)
+		switch {
+		case h < from:
+		case len(hashes) < count:
+			heap.Push(&hashes, h)
+		case h < hashes[0]:
+			hashes[0] = h
+			heap.Fix(&hashes, 0)
+		}
+	})
+	if len(hashes) < count {
+		return math.MaxUint32
+	}
+	return hashes[0]
+}
+
+// nextCursor returns the cursor after the page ending with hash bound, and whether the walk is done
+func nextCursor(bound uint32) (uint32, bool) {
+	if bound == math.MaxUint32 {
+		return 0, true
+	}
+	return bound + 1, false
+}
+
+// inPage reports whether member belongs to the page from hash from to hash bound
+func inPage(member string, from, bound uint32) bool {
+	h := scanHash(member)
+	return h >= from && h <= bound
+}
+
+// scanKeys returns the keys of the next page of db from cursor and the cursor after them
+func (m *MemDb) scanKeys(cursor uint64, count int) ([]string, uint64) {
+	pos, from := int(cursor>>32), uint32(cursor)
+	keys := make([]string, 0)
+	for pos < m.db.ShardNum() && len(keys) < count {
+		shardKeys := m.db.ShardKeys(pos)
+		walk := func(fn func(member string)) {
+			for _, key := range shardKeys {
+				fn(key)
+			}
+		}
+		bound := scanBound(walk, from, count-len(keys))
+		for _, key := range shardKeys {
+			if inPage(key, from, bound) {
+				keys = append(keys, key)
+			}
+		}
+		next, done := nextCursor(bound)
+		if done {
+			pos, from = pos+1, 0
+		} else {
+			from = next
+		}
+	}
+	if pos >= m.db.ShardNum() {
+		return keys, 0
+	}
+	return keys, uint64(pos)<<32 | uint64(from)
+}
+
+// scanMembers returns the members of the next page of the map encoding of a set or hash from cursor and the cursor after them.
+// Like scanKeys walks the shards, it walks the buckets from the one holding the cursor hash until the page is full.
+func scanMembers[V any](table *bucketMap[V], cursor uint64, count int) ([]string, uint64) {
+	if cursor > math.MaxUint32 {
+		return nil, 0
+	}
+	from := uint32(cursor)
+	members := make([]string, 0)
+	for pos := bucketOf(from, table.bits); pos < len(table.buckets) && len(members) < count; pos++ {
+		bucket := table.buckets[pos]
+		walk := func(fn func(member string)) {
+			for member := range bucket {
+				fn(member)
+			}
+		}
+		bound := scanBound(walk, from, count-len(members))
+		for member := range bucket {
+			if inPage(member, from, bound) {
+				members = append(members, member)
+			}
+		}
+		if next, done := nextCursor(bound); !done {
+			// the page ends inside this bucket
+			return members, uint64(next)
+		}
+		if pos+1 == len(table.buckets) {
+			return members, 0
+		}
+		from = bucketStart(pos+1, table.bits)
+	}
+	return members, uint64(from)
+}
@@ -0,0 +1,167 @@
+package memdb
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/VincentFF/thinredis/resp"
+)
+
+// scanAll calls the scan command built by cmd with every cursor until the scan is done, and changes the data after every call.
+// It returns how many times each element is returned.
+func scanAll(t *testing.T, m *MemDb, cmd func(cursor string) string, change func(i int)) map[string]int {
+	t.Helper()
+	seen := make(map[string]int)
+	cursor := "0"
+	for i := 0; ; i++ {
+		reply := m.ExecCommand(bytes.Fields([]byte(cmd(cursor))))
+		arr, ok := reply.(*resp.ArrayData)
+		if !ok || len(arr.Data()) != 2 {
+			t.Fatalf("%s replies %q", cmd(cursor), reply.ToBytes())
+		}
+		res := arr.Data()
+		for _, element := range res[1].(*resp.ArrayData).Data() {
+			seen[string(element.ByteData())]++
+		}
+		cursor = string(res[0].ByteData())
+		if cursor == "0" {
+			return seen
+		}
+		if i > 10000 {
+			t.Fatal("scan doesn't terminate")
+		}
+		change(i)
+	}
+}
+
+func TestScan(t *testing.T) {
+	memdb := NewMemDb()
+	for i := 0; i < 1000; i++ {
+		execCommands(memdb, "set key"+strconv.Itoa(i)+" v", "set tmp"+strconv.Itoa(i)+" v")
+	}
+	// keys present for the whole scan are returned exactly once, while other keys come and go
+	seen := scanAll(t, memdb, func(cursor string) string { return "scan " + cursor + " count 15" }, func(i int) {
+		execCommands(memdb, "del tmp"+strconv.Itoa(i), "set new"+strconv.Itoa(i)+" v")
+	})
+	for i := 0; i < 1000; i++ {
+		if count := seen["key"+strconv.Itoa(i)]; count != 1 {
+			t.Fatalf("key%d is returned %d times, expect 1", i, count)
+		}
+	}
+
+	execCommands(memdb, "rpush list a", "sadd set a", "hset keyhash f v")
+	seen = scanAll(t, memdb, func(cursor string) string { return "scan " + cursor + " match key* type hash" }, func(int) {})
+	if len(seen) != 1 || seen["keyhash"] != 1 {
+		t.Errorf("scan with match and type returns %v, expect keyhash", seen)
+	}
+	res := scanKey(memdb, [][]byte{[]byte("scan"), []byte("0"), []byte("type"), []byte("stream")})
+	if !bytes.Equal(res.ToBytes(), []byte("-error: unknown type name stream\r\n")) {
+		t.Errorf("scan with an unknown type replies %q", res.ToBytes())
+	}
+	res = scanKey(memdb, [][]byte{[]byte("scan"), []byte("-1")})
+	if !bytes.Equal(res.ToBytes(), []byte("-error: invalid cursor\r\n")) {
+		t.Errorf("scan with an invalid cursor replies %q", res.ToBytes())
+	}
+}
+
+func TestSScan(t *testing.T) {
+	memdb := NewMemDb()
+	for i := 0; i < 500; i++ {
+		execCommands(memdb, "sadd set m"+strconv.Itoa(i))
+	}
+	seen := scanAll(t, memdb, func(cursor string) string { return "sscan set " + cursor + " count 7" }, func(i int) {
+		execCommands(memdb, "sadd set added"+strconv.Itoa(i))
+	})
+	for i := 0; i < 500; i++ {
+		if count := seen["m"+strconv.Itoa(i)]; count != 1 {
+			t.Fatalf("m%d is returned %d times, expect 1", i, count)
+		}
+	}
+
+	seen = scanAll(t, memdb, func(cursor string) string { return "sscan set " + cursor + " match m1?" }, func(int) {})
+	if len(seen) != 10 {
+		t.Errorf("sscan with match returns %d members, expect 10", len(seen))
+	}
+	res := sScanSet(memdb, [][]byte{[]byte("sscan"), []byte("nokey"), []byte("0")})
+	if !bytes.Equal(res.ToBytes(), []byte("*2\r\n$1\r\n0\r\n*0\r\n")) {
+		t.Errorf("sscan of a not exist key replies %q", res.ToBytes())
+	}
+}
+
+func TestHScan(t *testing.T) {
+	memdb := NewMemDb()
+	for i := 0; i < 300; i++ {
+		execCommands(memdb, "hset hash f"+strconv.Itoa(i)+" "+strconv.Itoa(i))
+	}
+	seen := scanAll(t, memdb, func(cursor string) string { return "hscan hash " + cursor + " count 9" }, func(i int) {
+		execCommands(memdb, "hset hash added"+strconv.Itoa(i)+" v")
+	})
+	for i := 0; i < 300; i++ {
+		if seen["f"+strconv.Itoa(i)] != 1 || seen[strconv.Itoa(i)] != 1 {
+			t.Fatalf("field f%d or its value is not returned once", i)
+		}
+	}
+	seen = scanAll(t, memdb, func(cursor string) string { return "hscan hash " + cursor + " match f1 novalues" }, func(int) {})
+	if len(seen) != 1 || seen["f1"] != 1 {
+		t.Errorf("hscan with novalues returns %v, expect f1", seen)
+	}
+
+	// a listpack hash is returned in a single page
+	compactEncodings(t, 8, 8, 0)
+	execCommands(memdb, "hset small a 1 b 2 c 3")
+	res := hScanHash(memdb, [][]byte{[]byte("hscan"), []byte("small"), []byte("0"), []byte("count"), []byte("1")}).(*resp.ArrayData).Data()
+	if string(res[0].ByteData()) != "0" || len(res[1].(*resp.ArrayData).Data()) != 6 {
+		t.Errorf("hscan of a listpack hash replies %q", resp.MakeArrayData(res).ToBytes())
+	}
+}
+
+func TestScanMembersPage(t *testing.T) {
+	table := newBucketMap[void](0)
+	for i := 0; i < 10000; i++ {
+		table.Set("m"+strconv.Itoa(i), void{})
+	}
+	if table.bits == 0 || table.Len() != 10000 {
+		t.Fatalf("table of 10000 members has %d buckets and %d members", len(table.buckets), table.Len())
+	}
+	cursor, pages := uint64(0), 0
+	for {
+		members, next := scanMembers(table, cursor, 10)
+		pages++
+		if len(members) < 10 && next != 0 {
+			t.Fatalf("page from %d has %d members, expect 10", cursor, len(members))
+		}
+		end := uint64(math.MaxUint32) + 1
+		if next != 0 {
+			end = next
+		}
+		// the page holds exactly the members with hashes from cursor to the next cursor
+		inPage := 0
+		table.Range(func(member string, _ void) bool {
+			if h := uint64(scanHash(member)); h >= cursor && h < end {
+				inPage++
+			}
+			return true
+		})
+		if inPage != len(members) {
+			t.Fatalf("page from %d has %d members, expect %d", cursor, len(members), inPage)
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if pages < 1000 || pages > 1001 {
+		t.Errorf("scan of 10000 members with count 10 takes %d pages, expect 1000", pages)
+	}
+
+	for i := 0; i < 10000; i++ {
+		if !table.Delete("m" + strconv.Itoa(i)) {
+			t.Fatalf("m%d is lost by growing the buckets", i)
+		}
+	}
+	if members, next := scanMembers(table, 0, 10); table.Len() != 0 || len(members) != 0 || next != 0 {
+		t.Errorf("scan of an emptied table returns %v and cursor %d", members, next)
+	}
+}
@@ -0,0 +1,293 @@
+package memdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"math"
+)
+
+// serialize.go implements the binary encoding of values stored in MemDb.
+// It is shared by snapshot persistence and other features which need to serialize a single value.
+
+// value type flags used in the encoding
+const (
+	typeString byte = iota
+	typeList
+	typeSet
+	typeHash
+	typeZSet
+)
+
+// dumpVersion is the version of the DUMP payload format
+const dumpVersion = uint16(1)
+
+var (
+	errUnknownType = errors.New("unknown value type")
+	errBadPayload  = errors.New("DUMP payload version or checksum are wrong")
+	errBadLength   = errors.New("length is longer than the input left")
+)
+
+// byteReader is satisfied by both *bufio.Reader and *bytes.Reader
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// encoder writes primitive values in the serialization format.
+// The first write error is kept and all later writes are skipped.
+type encoder struct {
+	w   io.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func newEncoder(w io.Writer) *encoder {
+	return &encoder{w: w}
+}
+
+func (e *encoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(p)
+}
+
+func (e *encoder) writeByte(b byte) {
+	e.buf[0] = b
+	e.write(e.buf[:1])
+}
+
+func (e *encoder) writeUvarint(v uint64) {
+	n := binary.PutUvarint(e.buf[:], v)
+	e.write(e.buf[:n])
+}
+
+func (e *encoder) writeInt64(v int64) {
+	binary.BigEndian.PutUint64(e.buf[:8], uint64(v))
+	e.write(e.buf[:8])
+}
+
+func (e *encoder) writeBytes(p []byte) {
+	e.writeUvarint(uint64(len(p)))
+	e.write(p)
+}
+
+func (e *encoder) writeString(s string) {
+	e.writeUvarint(uint64(len(s)))
+	if e.err != nil {
+		return
+	}
+	_, e.err = io.WriteString(e.w, s)
+}
+
+// writeValue writes the type flag followed by the encoded value.
+func (e *encoder) writeValue(val any) {
+	switch v := val.(type) {
+	case []byte:
+		e.writeByte(typeString)
+		e.writeBytes(v)
+	case *List:
+		e.writeByte(typeList)
+		e.writeUvarint(uint64(v.Len))
+		v.ForEach(func(val []byte) bool {
+			e.writeBytes(val)
+			return true
+		})
+	case *Set:
+		e.writeByte(typeSet)
+		e.writeUvarint(uint64(v.Len()))
+		v.Range(func(member string) bool {
+			e.writeString(member)
+			return true
+		})
+	case *Hash:
+		e.writeByte(typeHash)
+		e.writeUvarint(uint64(v.Len()))
+		v.Range(func(field string, value []byte) bool {
+			e.writeString(field)
+			e.writeBytes(value)
+			return true
+		})
+	case *ZSet:
+		e.writeByte(typeZSet)
+		e.writeUvarint(uint64(v.Len()))
+		v.Range(func(member string, score float64) bool {
+			e.writeString(member)
+			e.writeInt64(int64(math.Float64bits(score)))
+			return true
+		})
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("%w: %T", errUnknownType, val)
+		}
+	}
+}
+
+// decoder reads primitive values in the serialization format.
+// left returns the number of bytes left in the input. Lengths and counts are checked against it before anything
+// is allocated, so that a forged length can't make the decoder allocate more than the input holds.
+type decoder struct {
+	r    byteReader
+	left func() int64
+}
+
+func newDecoder(r byteReader, left func() int64) *decoder {
+	return &decoder{r: r, left: left}
+}
+
+func (d *decoder) readByte() (byte, error) {
+	return d.r.ReadByte()
+}
+
+func (d *decoder) readUvarint() (uint64, error) {
+	return binary.ReadUvarint(d.r)
+}
+
+func (d *decoder) readInt64() (int64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(d.r, buf[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(buf[:])), nil
+}
+
+// readCount reads a length or a count of elements encoded in at least size bytes each
+func (d *decoder) readCount(size uint64) (uint64, error) {
+	n, err := d.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+	if left := d.left(); left < 0 || n > uint64(left)/size {
+		return 0, fmt.Errorf("%w: %d", errBadLength, n)
+	}
+	return n, nil
+}
+
+func (d *decoder) readBytes() ([]byte, error) {
+	n, err := d.readCount(1)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err = io.ReadFull(d.r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readValue reads a type flag and the value encoded after it.
+func (d *decoder) readValue() (any, error) {
+	valType, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch valType {
+	case typeString:
+		return d.readBytes()
+	case typeList:
+		n, err := d.readCount(1)
+		if err != nil {
+			return nil, err
+		}
+		list := NewList()
+		for i := uint64(0); i < n; i++ {
+			val, err := d.readBytes()
+			if err != nil {
+				return nil, err
+			}
+			list.RPush(val)
+		}
+		return list, nil
+	case typeSet:
+		n, err := d.readCount(1)
+		if err != nil {
+			return nil, err
+		}
+		set := NewSet()
+		for i := uint64(0); i < n; i++ {
+			member, err := d.readBytes()
+			if err != nil {
+				return nil, err
+			}
+			set.Add(string(member))
+		}
+		return set, nil
+	case typeHash:
+		n, err := d.readCount(2)
+		if err != nil {
+			return nil, err
+		}
+		hash := NewHash()
+		for i := uint64(0); i < n; i++ {
+			field, err := d.readBytes()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.readBytes()
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(string(field), value)
+		}
+		return hash, nil
+	case typeZSet:
+		// a member is at least its length and the 8 bytes of its score
+		n, err := d.readCount(9)
+		if err != nil {
+			return nil, err
+		}
+		zset := NewZSet()
+		for i := uint64(0); i < n; i++ {
+			member, err := d.readBytes()
+			if err != nil {
+				return nil, err
+			}
+			bits, err := d.readInt64()
+			if err != nil {
+				return nil, err
+			}
+			zset.Add(string(member), math.Float64frombits(uint64(bits)))
+		}
+		return zset, nil
+	}
+	return nil, fmt.Errorf("%w: %d", errUnknownType, valType)
+}
+
+// dumpValue serializes val to a DUMP payload: value | version(uint16) | crc64 of the previous bytes
+func dumpValue(val any) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	e := newEncoder(buf)
+	e.writeValue(val)
+	if e.err != nil {
+		return nil, e.err
+	}
+	var tail [10]byte
+	binary.BigEndian.PutUint16(tail[:2], dumpVersion)
+	buf.Write(tail[:2])
+	binary.BigEndian.PutUint64(tail[2:], crc64.Checksum(buf.Bytes(), crcTable))
+	buf.Write(tail[2:])
+	return buf.Bytes(), nil
+}
+
+// restoreValue deserializes a DUMP payload to a value
+func restoreValue(payload []byte) (any, error) {
+	if len(payload) < 10 {
+		return nil, errBadPayload
+	}
+	body := payload[:len(payload)-10]
+	version := binary.BigEndian.Uint16(payload[len(payload)-10:])
+	sum := binary.BigEndian.Uint64(payload[len(payload)-8:])
+	if version > dumpVersion || crc64.Checksum(payload[:len(payload)-8], crcTable) != sum {
+		return nil, errBadPayload
+	}
+	reader := bytes.NewReader(body)
+	val, err := newDecoder(reader, func() int64 { return int64(reader.Len()) }).readValue()
+	if err != nil || reader.Len() != 0 {
+		return nil, errBadPayload
+	}
+	return val, nil
+}
@@ -39,6 +39,9 @@ func sAddSet(m *MemDb, cmd [][]byte) resp.RedisData {
 	for i := 2; i < len(cmd); i++ {
 		res += sets.Add(string(cmd[i]))
 	}
+	if res > 0 {
+		m.notify(notifySet, "sadd", key)
+	}
 
 	return resp.MakeIntData(int64(res))
 }
@@ -209,6 +212,7 @@ func sDiffStoreSet(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 	if diffRes.Len() != 0 {
 		m.db.Set(desKey, diffRes)
+		m.notify(notifySet, "sdiffstore", desKey)
 	}
 	return resp.MakeIntData(int64(diffRes.Len()))
 }
@@ -340,6 +344,7 @@ func sInterStoreSet(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 	if interSet.Len() != 0 {
 		m.db.Set(desKey, interSet)
+		m.notify(notifySet, "sinterstore", desKey)
 	}
 	return resp.MakeIntData(int64(interSet.Len()))
 }
@@ -466,6 +471,13 @@ func sMoveSet(m *MemDb, cmd [][]byte) resp.RedisData {
 	if !desExist {
 		m.db.Set(desKey, desSet)
 	}
+	m.notify(notifySet, "srem", srcKey)
+	if srcSet.Len() == 0 {
+		m.db.Delete(srcKey)
+		m.DelTTL(srcKey)
+		m.notify(notifyGeneric, "del", srcKey)
+	}
+	m.notify(notifySet, "sadd", desKey)
 
 	return resp.MakeIntData(1)
 }
@@ -517,12 +529,14 @@ func sPopSet(m *MemDb, cmd [][]byte) resp.RedisData {
 		if set.Len() == 0 {
 			m.db.Delete(key)
 			m.DelTTL(key)
+			m.notify(notifyGeneric, "del", key)
 		}
 	}()
 
 	res := make([]resp.RedisData, 0)
 	if count == 1 {
 		val := set.Pop()
+		m.notify(notifySet, "spop", key)
 		return resp.MakeBulkData([]byte(val))
 	} else {
 		for i := 0; i < count; i++ {
@@ -533,6 +547,9 @@ func sPopSet(m *MemDb, cmd [][]byte) resp.RedisData {
 			res = append(res, resp.MakeBulkData([]byte(val)))
 		}
 	}
+	if len(res) > 0 {
+		m.notify(notifySet, "spop", key)
+	}
 	return resp.MakeArrayData(res)
 }
 
@@ -620,6 +637,7 @@ func sRemSet(m *MemDb, cmd [][]byte) resp.RedisData {
 		if set.Len() == 0 {
 			m.db.Delete(key)
 			m.DelTTL(key)
+			m.notify(notifyGeneric, "del", key)
 		}
 	}()
 
@@ -628,6 +646,9 @@ func sRemSet(m *MemDb, cmd [][]byte) resp.RedisData {
 		member := string(cmd[i])
 		res += set.Remove(member)
 	}
+	if res > 0 {
+		m.notify(notifySet, "srem", key)
+	}
 
 	return resp.MakeIntData(int64(res))
 }
@@ -753,29 +774,75 @@ func sUnionStoreSet(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 	if resSet.Len() != 0 {
 		m.db.Set(desKey, resSet)
+		m.notify(notifySet, "sunionstore", desKey)
 	}
 	return resp.MakeIntData(int64(resSet.Len()))
 }
 
-// TODO: sscan
-//func sScanSet(m *MemDb, cmd [][]byte) resp.RedisData {
-//	return nil
-//}
+// sScanSet handles SSCAN key cursor [MATCH pattern] [COUNT count], see scan.go for the cursor.
+// An intset is small, so it is returned in a single page.
+func sScanSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "sscan" {
+		logger.Error("sScanSet Function: cmdName is not sscan")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 3 {
+		return resp.MakeErrorData("wrong number of arguments for 'sscan' command")
+	}
+	cursor, errRes := parseCursor(cmd[2])
+	if errRes != nil {
+		return errRes
+	}
+	opts, errRes := parseScanOptions(cmd[3:], false, false)
+	if errRes != nil {
+		return errRes
+	}
+
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return scanReply(0, []resp.RedisData{})
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+	tem, ok := m.db.Get(key)
+	if !ok {
+		return scanReply(0, []resp.RedisData{})
+	}
+	set, ok := tem.(*Set)
+	if !ok {
+		return resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	var members []string
+	next := uint64(0)
+	if set.Encoding() == "intset" {
+		members = set.Members()
+	} else {
+		members, next = scanMembers(set.table, cursor, opts.count)
+	}
+	res := make([]resp.RedisData, 0, len(members))
+	for _, member := range members {
+		if opts.match(member) {
+			res = append(res, resp.MakeBulkData([]byte(member)))
+		}
+	}
+	return scanReply(next, res)
+}
 
 func RegisterSetCommands() {
-	RegisterCommand("sadd", sAddSet)
+	RegisterWriteCommand("sadd", sAddSet, 1, 1, 1)
 	RegisterCommand("scard", sCardSet)
 	RegisterCommand("sdiff", sDiffSet)
-	RegisterCommand("sdiffstore", sDiffStoreSet)
+	RegisterWriteCommand("sdiffstore", sDiffStoreSet, 1, 1, 1)
 	RegisterCommand("sinter", sInterSet)
-	RegisterCommand("sinterstore", sInterStoreSet)
+	RegisterWriteCommand("sinterstore", sInterStoreSet, 1, 1, 1)
 	RegisterCommand("sismember", sIsMemberSet)
 	RegisterCommand("smembers", sMembersSet)
-	RegisterCommand("smove", sMoveSet)
-	RegisterCommand("spop", sPopSet)
+	RegisterWriteCommand("smove", sMoveSet, 1, 2, 1)
+	RegisterWriteCommand("spop", sPopSet, 1, 1, 1)
 	RegisterCommand("srandmember", sRandMemberSet)
-	RegisterCommand("srem", sRemSet)
+	RegisterWriteCommand("srem", sRemSet, 1, 1, 1)
 	RegisterCommand("sunion", sUnionSet)
-	RegisterCommand("sunionstore", sUnionStoreSet)
-	//RegisterCommand("sscan", sScanSet)
+	RegisterWriteCommand("sunionstore", sUnionStoreSet, 1, 1, 1)
+	RegisterCommand("sscan", sScanSet)
 }
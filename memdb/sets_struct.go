@@ -1,80 +1,187 @@
 package memdb
 
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/VincentFF/thinredis/config"
+)
+
 type void struct{}
 
+// Set holds the members of a set.
+// A set of integers is an intset, a sorted slice of the integers, it is converted to a map
+// once a member is not an integer or it has more than set-max-intset-entries members.
+// A map is never converted back to an intset.
 type Set struct {
-	table map[string]void
+	intset []int64 // used when table is nil
+	table  *bucketMap[void]
 }
 
 func NewSet() *Set {
-	return &Set{make(map[string]void)}
+	if config.Configures.SetMaxIntsetEntries > 0 {
+		return &Set{intset: []int64{}}
+	}
+	return &Set{table: newBucketMap[void](0)}
+}
+
+// intsetMember returns the integer of key if key can be an intset member.
+// Only the canonical form is accepted, so that the member is formatted back to key.
+func intsetMember(key string) (int64, bool) {
+	val, err := strconv.ParseInt(key, 10, 64)
+	if err != nil || strconv.FormatInt(val, 10) != key {
+		return 0, false
+	}
+	return val, true
+}
+
+// search returns the position of val in the intset, and whether it is found
+func (s *Set) search(val int64) (int, bool) {
+	pos := sort.Search(len(s.intset), func(i int) bool { return s.intset[i] >= val })
+	return pos, pos < len(s.intset) && s.intset[pos] == val
+}
+
+// convert converts the intset to a map
+func (s *Set) convert() {
+	s.table = newBucketMap[void](len(s.intset) + 1)
+	for _, val := range s.intset {
+		s.table.Set(strconv.FormatInt(val, 10), void{})
+	}
+	s.intset = nil
 }
 
 func (s *Set) Add(key string) int {
-	if s.Has(key) {
-		return 0
+	if s.table == nil {
+		if val, ok := intsetMember(key); ok {
+			pos, found := s.search(val)
+			if found {
+				return 0
+			}
+			if len(s.intset) < config.Configures.SetMaxIntsetEntries {
+				s.intset = append(s.intset, 0)
+				copy(s.intset[pos+1:], s.intset[pos:])
+				s.intset[pos] = val
+				return 1
+			}
+		}
+		s.convert()
+	}
+	if s.table.Set(key, void{}) {
+		return 1
 	}
-	s.table[key] = void{}
-	return 1
+	return 0
 }
 
 func (s *Set) Remove(key string) int {
-	if s.Has(key) {
-		delete(s.table, key)
+	if s.table == nil {
+		val, ok := intsetMember(key)
+		if !ok {
+			return 0
+		}
+		pos, found := s.search(val)
+		if !found {
+			return 0
+		}
+		s.intset = append(s.intset[:pos], s.intset[pos+1:]...)
+		return 1
+	}
+	if s.table.Delete(key) {
 		return 1
 	}
 	return 0
 }
 
 func (s *Set) Len() int {
-	return len(s.table)
+	if s.table == nil {
+		return len(s.intset)
+	}
+	return s.table.Len()
 }
 
 func (s *Set) Has(key string) bool {
-	_, ok := s.table[key]
+	if s.table == nil {
+		val, ok := intsetMember(key)
+		if !ok {
+			return false
+		}
+		_, found := s.search(val)
+		return found
+	}
+	_, ok := s.table.Get(key)
 	return ok
 }
 
 func (s *Set) Pop() string {
-	for key := range s.table {
+	if s.table == nil {
+		if len(s.intset) == 0 {
+			return ""
+		}
+		key := strconv.FormatInt(s.intset[rand.Intn(len(s.intset))], 10)
 		s.Remove(key)
 		return key
 	}
-	return ""
+	if s.table.Len() == 0 {
+		return ""
+	}
+	key, _ := s.table.Random()
+	s.Remove(key)
+	return key
 }
 
 func (s *Set) Clear() {
-	s.table = make(map[string]void)
+	*s = *NewSet()
+}
+
+// Range calls fn for every member until fn returns false, the members of an intset are in order
+func (s *Set) Range(fn func(key string) bool) {
+	if s.table == nil {
+		for _, val := range s.intset {
+			if !fn(strconv.FormatInt(val, 10)) {
+				return
+			}
+		}
+		return
+	}
+	s.table.Range(func(key string, _ void) bool {
+		return fn(key)
+	})
 }
 
 func (s *Set) Members() []string {
-	res := make([]string, 0, len(s.table))
-	for key := range s.table {
+	res := make([]string, 0, s.Len())
+	s.Range(func(key string) bool {
 		res = append(res, key)
-	}
+		return true
+	})
 	return res
 }
 
-func (s *Set) Union(sets ...*Set) *Set {
+// collect returns a new set of the members of s, so that a result gets the compact encoding if it fits
+func (s *Set) collect() *Set {
 	res := NewSet()
-	for key := range s.table {
+	s.Range(func(key string) bool {
 		res.Add(key)
-	}
+		return true
+	})
+	return res
+}
+
+func (s *Set) Union(sets ...*Set) *Set {
+	res := s.collect()
 	for _, set := range sets {
-		for key := range set.table {
+		set.Range(func(key string) bool {
 			res.Add(key)
-		}
+			return true
+		})
 	}
 	return res
 }
 
 func (s *Set) Intersect(sets ...*Set) *Set {
-	res := NewSet()
-	for key := range s.table {
-		res.Add(key)
-	}
+	res := s.collect()
 	for _, set := range sets {
-		for key := range res.table {
+		for _, key := range res.Members() {
 			if !set.Has(key) {
 				res.Remove(key)
 			}
@@ -84,25 +191,23 @@ func (s *Set) Intersect(sets ...*Set) *Set {
 }
 
 func (s *Set) Difference(sets ...*Set) *Set {
-	res := NewSet()
-	for key := range s.table {
-		res.Add(key)
-	}
+	res := s.collect()
 	for _, set := range sets {
-		for key := range set.table {
+		set.Range(func(key string) bool {
 			res.Remove(key)
-		}
+			return true
+		})
 	}
 	return res
 }
 
 func (s *Set) IsSubset(set *Set) bool {
-	for key := range s.table {
-		if !set.Has(key) {
-			return false
-		}
-	}
-	return true
+	subset := true
+	s.Range(func(key string) bool {
+		subset = set.Has(key)
+		return subset
+	})
+	return subset
 }
 
 // Random returns a random member of the set.
@@ -112,26 +217,38 @@ func (s *Set) Random(count int) []string {
 	res := make([]string, 0)
 	if count == 0 || s.Len() == 0 {
 		return res
-	} else if count > 0 {
-		if count > s.Len() {
-			count = s.Len()
-		}
-		for key := range s.table {
-			res = append(res, key)
-			if len(res) == count {
-				break
+	}
+	if s.table == nil {
+		if count > 0 {
+			if count > s.Len() {
+				count = s.Len()
 			}
-		}
-	} else {
-		for {
-			for key := range s.table {
-				res = append(res, key)
-				if len(res) == -count {
-					return res
-				}
+			for _, i := range rand.Perm(s.Len())[:count] {
+				res = append(res, strconv.FormatInt(s.intset[i], 10))
+			}
+		} else {
+			for len(res) < -count {
+				res = append(res, strconv.FormatInt(s.intset[rand.Intn(s.Len())], 10))
 			}
-
 		}
+		return res
 	}
-	return res
+	return s.table.RandomKeys(count)
+}
+
+// Encoding returns intset or hashtable
+func (s *Set) Encoding() string {
+	if s.table == nil {
+		return "intset"
+	}
+	return "hashtable"
+}
+
+func (s *Set) Copy() *Set {
+	if s.table == nil {
+		res := &Set{intset: make([]int64, len(s.intset))}
+		copy(res.intset, s.intset)
+		return res
+	}
+	return &Set{table: s.table.Copy(func(val void) void { return val })}
 }
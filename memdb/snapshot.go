@@ -0,0 +1,313 @@
+package memdb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VincentFF/thinredis/config"
+	"github.com/VincentFF/thinredis/logger"
+	"github.com/VincentFF/thinredis/resp"
+)
+
+// snapshot.go implements point-in-time snapshot persistence and the SAVE, BGSAVE and LASTSAVE commands.
+//
+// Snapshot file layout:
+//
+//	magic "THINREDIS" | version byte
+//	opSelectDb uvarint(db index)                                      (for every non-empty db)
+//	opEntry int64(absolute expire time in ms, -1 if none) key value   (repeated)
+//	opEOF uint64(crc64 checksum of all previous bytes)
+//
+// Every key is serialized under its read lock, so each value is consistent,
+// but keys written during a BGSAVE may or may not be included.
+
+const (
+	snapshotMagic   = "THINREDIS"
+	snapshotVersion = byte(1)
+
+	opEntry    byte = 0xFB
+	opSelectDb byte = 0xFE
+	opEOF      byte = 0xFF
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+var errBadSnapshot = errors.New("bad snapshot file")
+
+var (
+	saveMu   sync.Mutex // only one save can run at a time
+	lastSave int64      // unix time of the last successful save
+)
+
+// crcReader updates a checksum with every byte consumed from a bufio.Reader, and counts them in read.
+type crcReader struct {
+	r    *bufio.Reader
+	crc  hash.Hash64
+	read int64
+}
+
+func (c *crcReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.crc.Write(p[:n])
+	c.read += int64(n)
+	return n, err
+}
+
+func (c *crcReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.crc.Write([]byte{b})
+		c.read++
+	}
+	return b, err
+}
+
+// writeSnapshot writes all keys of db with index dbIndex to e.
+func (m *MemDb) writeSnapshot(e *encoder, dbIndex int) {
+	e.writeByte(opSelectDb)
+	e.writeUvarint(uint64(dbIndex))
+	for _, key := range m.db.Keys() {
+		if !m.alive(key) {
+			continue
+		}
+		m.locks.RLock(key)
+		val, ok := m.db.Get(key)
+		if ok {
+			expireAt := int64(-1)
+			if ttl, ok := m.ttlKeys.Get(key); ok {
+				expireAt = ttl.(int64)
+			}
+			e.writeByte(opEntry)
+			e.writeInt64(expireAt)
+			e.writeString(key)
+			e.writeValue(val)
+		}
+		m.locks.RUnLock(key)
+		if e.err != nil {
+			return
+		}
+	}
+}
+
+// SaveSnapshot writes a snapshot of all databases of d to path.
+// The data is written to a temporary file first and renamed to path, so a crash never leaves a broken snapshot.
+func SaveSnapshot(path string, d *Databases) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "temp-*.tdb")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// remove the temp file if it is not renamed
+		_ = os.Remove(tmp.Name())
+	}()
+
+	crc := crc64.New(crcTable)
+	bufWriter := bufio.NewWriter(tmp)
+	e := newEncoder(io.MultiWriter(bufWriter, crc))
+	e.write([]byte(snapshotMagic))
+	e.writeByte(snapshotVersion)
+	for i, m := range d.list() {
+		if m.db.Len() > 0 {
+			m.writeSnapshot(e, i)
+		}
+	}
+	e.writeByte(opEOF)
+	if e.err != nil {
+		_ = tmp.Close()
+		return e.err
+	}
+	// the checksum itself is not part of the checksum
+	newEncoder(bufWriter).writeInt64(int64(crc.Sum64()))
+
+	if err = bufWriter.Flush(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&lastSave, time.Now().Unix())
+	return nil
+}
+
+// Save saves a snapshot of d to the configured path. Unlike SAVE it waits for a running BGSAVE to finish
+// instead of failing, it is used when the server shuts down.
+func (d *Databases) Save() error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	return SaveSnapshot(config.Configures.SnapshotPath(), d)
+}
+
+// LoadSnapshot loads keys from the snapshot file at path into the databases of d.
+// It is a no-op if the file does not exist. Keys that have already expired are skipped.
+func LoadSnapshot(path string, d *Databases) error {
+	fl, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			logger.Info("snapshot file ", path, " not exist, skip loading")
+			return nil
+		}
+		return err
+	}
+	defer func() {
+		if err := fl.Close(); err != nil {
+			logger.Error("close snapshot file error: ", err.Error())
+		}
+	}()
+
+	info, err := fl.Stat()
+	if err != nil {
+		return err
+	}
+	bufReader := bufio.NewReader(fl)
+	crc := crc64.New(crcTable)
+	reader := &crcReader{r: bufReader, crc: crc}
+	dec := newDecoder(reader, func() int64 { return info.Size() - reader.read })
+
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err = io.ReadFull(dec.r, header); err != nil {
+		return fmt.Errorf("%w: %s", errBadSnapshot, err.Error())
+	}
+	if !bytes.Equal(header[:len(snapshotMagic)], []byte(snapshotMagic)) {
+		return fmt.Errorf("%w: wrong magic string", errBadSnapshot)
+	}
+	if header[len(snapshotMagic)] != snapshotVersion {
+		return fmt.Errorf("%w: unsupported version %d", errBadSnapshot, header[len(snapshotMagic)])
+	}
+
+	now := time.Now().UnixMilli()
+	loaded := 0
+	m := d.Db(0)
+	for {
+		op, err := dec.readByte()
+		if err != nil {
+			return fmt.Errorf("%w: %s", errBadSnapshot, err.Error())
+		}
+		switch op {
+		case opSelectDb:
+			index, err := dec.readUvarint()
+			if err != nil {
+				return fmt.Errorf("%w: %s", errBadSnapshot, err.Error())
+			}
+			if index >= uint64(d.Len()) {
+				return fmt.Errorf("%w: db index %d out of range, %d databases are configured", errBadSnapshot, index, d.Len())
+			}
+			m = d.Db(int(index))
+		case opEntry:
+			expireAt, err := dec.readInt64()
+			if err != nil {
+				return fmt.Errorf("%w: %s", errBadSnapshot, err.Error())
+			}
+			key, err := dec.readBytes()
+			if err != nil {
+				return fmt.Errorf("%w: %s", errBadSnapshot, err.Error())
+			}
+			val, err := dec.readValue()
+			if err != nil {
+				return fmt.Errorf("%w: %s", errBadSnapshot, err.Error())
+			}
+			if expireAt >= 0 && expireAt <= now {
+				continue
+			}
+			m.db.Set(string(key), val)
+			if expireAt >= 0 {
+				m.SetTTL(string(key), expireAt)
+			}
+			m.accountKey(string(key))
+			loaded++
+		case opEOF:
+			sum := crc.Sum64()
+			// read the checksum without feeding it to crc
+			expected, err := newDecoder(bufReader, dec.left).readInt64()
+			if err != nil {
+				return fmt.Errorf("%w: %s", errBadSnapshot, err.Error())
+			}
+			if uint64(expected) != sum {
+				return fmt.Errorf("%w: checksum mismatch", errBadSnapshot)
+			}
+			logger.Info("load ", loaded, " keys from snapshot ", path)
+			return nil
+		default:
+			return fmt.Errorf("%w: unknown opcode %d", errBadSnapshot, op)
+		}
+	}
+}
+
+func saveSnapshot(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "save" {
+		logger.Error("saveSnapshot Function: cmdName is not save")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) != 1 {
+		return resp.MakeErrorData("wrong number of arguments for 'save' command")
+	}
+
+	if !saveMu.TryLock() {
+		return resp.MakeErrorData("error: background save already in progress")
+	}
+	defer saveMu.Unlock()
+	if err := SaveSnapshot(config.Configures.SnapshotPath(), m.dbs); err != nil {
+		logger.Error("save snapshot error: ", err.Error())
+		return resp.MakeErrorData("error: " + err.Error())
+	}
+	return resp.MakeStringData("OK")
+}
+
+func bgSaveSnapshot(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "bgsave" {
+		logger.Error("bgSaveSnapshot Function: cmdName is not bgsave")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) != 1 {
+		return resp.MakeErrorData("wrong number of arguments for 'bgsave' command")
+	}
+
+	if !saveMu.TryLock() {
+		return resp.MakeErrorData("error: background save already in progress")
+	}
+	go func() {
+		defer saveMu.Unlock()
+		path := config.Configures.SnapshotPath()
+		if err := SaveSnapshot(path, m.dbs); err != nil {
+			logger.Error("background save error: ", err.Error())
+			return
+		}
+		logger.Info("background save to ", path, " finished")
+	}()
+	return resp.MakeStringData("Background saving started")
+}
+
+func lastSaveSnapshot(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "lastsave" {
+		logger.Error("lastSaveSnapshot Function: cmdName is not lastsave")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) != 1 {
+		return resp.MakeErrorData("wrong number of arguments for 'lastsave' command")
+	}
+	return resp.MakeIntData(atomic.LoadInt64(&lastSave))
+}
+
+func RegisterSnapshotCommands() {
+	RegisterCommand("save", saveSnapshot)
+	RegisterCommand("bgsave", bgSaveSnapshot)
+	RegisterCommand("lastsave", lastSaveSnapshot)
+}
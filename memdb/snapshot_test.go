@@ -0,0 +1,86 @@
+package memdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VincentFF/thinredis/config"
+)
+
+func init() {
+	config.Configures = &config.Config{
+		ShardNum: 100,
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	m := NewMemDb()
+	setString(m, [][]byte{[]byte("set"), []byte("str"), []byte("v")})
+	setString(m, [][]byte{[]byte("set"), []byte("ttl"), []byte("v"), []byte("ex"), []byte("100")})
+	rPushList(m, [][]byte{[]byte("rpush"), []byte("list"), []byte("a"), []byte("b"), []byte("c")})
+	sAddSet(m, [][]byte{[]byte("sadd"), []byte("set"), []byte("a"), []byte("b")})
+	hSetHash(m, [][]byte{[]byte("hset"), []byte("hash"), []byte("f1"), []byte("v1"), []byte("f2"), []byte("v2")})
+	m.db.Set("expired", []byte("v"))
+	m.ttlKeys.Set("expired", time.Now().UnixMilli()-1)
+
+	path := filepath.Join(t.TempDir(), "dump.tdb")
+	if err := SaveSnapshot(path, m.dbs); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewMemDb()
+	if err := LoadSnapshot(path, loaded.dbs); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.db.Len() != 5 {
+		t.Errorf("load %d keys, expect 5", loaded.db.Len())
+	}
+	if val, ok := loaded.db.Get("str"); !ok || !bytes.Equal(val.([]byte), []byte("v")) {
+		t.Error("load string error")
+	}
+	if ttl, ok := loaded.ttlKeys.Get("ttl"); !ok || ttl.(int64)-time.Now().UnixMilli() > 100000 || ttl.(int64)-time.Now().UnixMilli() < 99000 {
+		t.Error("load ttl error")
+	}
+	if val, ok := loaded.db.Get("list"); !ok || len(val.(*List).Range(0, -1)) != 3 || !bytes.Equal(val.(*List).Range(-1, -1)[0], []byte("c")) {
+		t.Error("load list error")
+	}
+	if val, ok := loaded.db.Get("set"); !ok || !val.(*Set).Has("a") || !val.(*Set).Has("b") {
+		t.Error("load set error")
+	}
+	if val, ok := loaded.db.Get("hash"); !ok || !bytes.Equal(val.(*Hash).Get("f2"), []byte("v2")) {
+		t.Error("load hash error")
+	}
+
+	// a corrupted file must be rejected
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(snapshotMagic)+4] ^= 0xFF
+	if err = os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = LoadSnapshot(path, NewDatabases(1)); err == nil {
+		t.Error("load corrupted snapshot should fail")
+	}
+}
+
+func TestSnapshotForgedLength(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.tdb")
+	data := append([]byte(snapshotMagic), snapshotVersion, opSelectDb, 0, opEntry)
+	data = binary.BigEndian.AppendUint64(data, math.MaxUint64) // no expire time
+	data = binary.AppendUvarint(data, 1<<40)                   // key length
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadSnapshot(path, NewDatabases(1)); !errors.Is(err, errBadSnapshot) || !strings.Contains(err.Error(), errBadLength.Error()) {
+		t.Errorf("load snapshot with a forged key length returns %v", err)
+	}
+}
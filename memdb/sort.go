@@ -0,0 +1,311 @@
+package memdb
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/VincentFF/thinredis/logger"
+	"github.com/VincentFF/thinredis/resp"
+)
+
+// sort.go implements SORT and SORT_RO.
+// The elements of the source key are copied under its lock, then the weights of BY and the values of GET are looked up
+// key by key, so that no two keys are locked at the same time. A pattern is a key name with the first * replaced
+// by the element, and a pattern ending with ->field looks up the field of a hash. GET # returns the element itself.
+
+// sortOptions are the options of SORT key [BY pattern] [LIMIT offset count] [GET pattern ...] [ASC|DESC] [ALPHA] [STORE destination]
+type sortOptions struct {
+	by     string
+	noSort bool // BY pattern without *, the elements are not sorted
+	offset int
+	count  int // -1 if LIMIT is not given
+	gets   []string
+	desc   bool
+	alpha  bool
+	store  string
+}
+
+// parseSortOptions parses the options after the key of SORT, STORE is only allowed if allowStore is true
+func parseSortOptions(args [][]byte, allowStore bool) (*sortOptions, resp.RedisData) {
+	opts := &sortOptions{count: -1}
+	for i := 0; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		switch {
+		case option == "asc":
+			opts.desc = false
+		case option == "desc":
+			opts.desc = true
+		case option == "alpha":
+			opts.alpha = true
+		case option == "limit" && i+2 < len(args):
+			offset, err1 := strconv.Atoi(string(args[i+1]))
+			count, err2 := strconv.Atoi(string(args[i+2]))
+			if err1 != nil || err2 != nil {
+				return nil, resp.MakeErrorData("error: value is not an integer or out of range")
+			}
+			opts.offset, opts.count = offset, count
+			i += 2
+		case option == "by" && i+1 < len(args):
+			i++
+			opts.by = string(args[i])
+			opts.noSort = !strings.Contains(opts.by, "*")
+		case option == "get" && i+1 < len(args):
+			i++
+			opts.gets = append(opts.gets, string(args[i]))
+		case option == "store" && i+1 < len(args) && allowStore:
+			i++
+			opts.store = string(args[i])
+		default:
+			return nil, resp.MakeErrorData("error: syntax error")
+		}
+	}
+	return opts, nil
+}
+
+// sortStoreKeys returns the destination of SORT ... STORE destination, the only key SORT modifies
+func sortStoreKeys(cmd [][]byte) []string {
+	if len(cmd) < 2 {
+		return nil
+	}
+	opts, errData := parseSortOptions(cmd[2:], true)
+	if errData != nil || opts.store == "" {
+		return nil
+	}
+	return []string{opts.store}
+}
+
+// sortElements returns a copy of the elements of the list, set or sorted set at key, or a WRONGTYPE error.
+// The members of a set are ordered by their bytes, so that an unsorted result is the same when aof is replayed.
+// The members of a sorted set are in the order of their scores.
+func (m *MemDb) sortElements(key string) ([][]byte, resp.RedisData) {
+	if !m.CheckTTL(key) {
+		return nil, nil
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+	val, ok := m.db.Get(key)
+	if !ok {
+		return nil, nil
+	}
+	var elements [][]byte
+	switch v := val.(type) {
+	case *List:
+		elements = make([][]byte, 0, v.Len)
+		v.ForEach(func(element []byte) bool {
+			elements = append(elements, copyBytes(element))
+			return true
+		})
+	case *Set:
+		elements = make([][]byte, 0, v.Len())
+		v.Range(func(member string) bool {
+			elements = append(elements, []byte(member))
+			return true
+		})
+		sort.Slice(elements, func(i, j int) bool { return bytes.Compare(elements[i], elements[j]) < 0 })
+	case *ZSet:
+		elements = make([][]byte, 0, v.Len())
+		v.Range(func(member string, score float64) bool {
+			elements = append(elements, []byte(member))
+			return true
+		})
+	default:
+		return nil, resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return elements, nil
+}
+
+// sortLookup returns the value pattern refers to for element, or false if it doesn't exist
+func (m *MemDb) sortLookup(pattern string, element []byte) ([]byte, bool) {
+	if pattern == "#" {
+		return element, true
+	}
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return nil, false
+	}
+	keyPattern, field := pattern, ""
+	if arrow := strings.Index(pattern[star+1:], "->"); arrow >= 0 && star+1+arrow+2 < len(pattern) {
+		keyPattern, field = pattern[:star+1+arrow], pattern[star+1+arrow+2:]
+	}
+	key := keyPattern[:star] + string(element) + keyPattern[star+1:]
+
+	// looking up a weight or a GET value doesn't count as an access of its key
+	if !m.alive(key) {
+		return nil, false
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+	val, ok := m.db.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if field == "" {
+		str, ok := val.([]byte)
+		if !ok {
+			return nil, false
+		}
+		return copyBytes(str), true
+	}
+	hash, ok := val.(*Hash)
+	if !ok {
+		return nil, false
+	}
+	value := hash.Get(field)
+	if value == nil {
+		return nil, false
+	}
+	return copyBytes(value), true
+}
+
+type sortItem struct {
+	element []byte
+	score   float64
+	weight  []byte // the weight of an ALPHA sort by BY, nil if it doesn't exist
+}
+
+// sortItems sorts elements by opts. It returns an error if a weight of a numeric sort is not a number.
+func (m *MemDb) sortItems(elements [][]byte, opts *sortOptions) ([]sortItem, resp.RedisData) {
+	items := make([]sortItem, len(elements))
+	for i, element := range elements {
+		items[i].element = element
+		if opts.noSort {
+			continue
+		}
+		weight := element
+		if opts.by != "" {
+			weight, _ = m.sortLookup(opts.by, element)
+		}
+		if opts.alpha {
+			items[i].weight = weight
+			continue
+		}
+		// a missing weight counts as 0
+		if weight != nil {
+			score, err := strconv.ParseFloat(string(weight), 64)
+			if err != nil || math.IsNaN(score) {
+				return nil, resp.MakeErrorData("error: one or more scores can't be converted into double")
+			}
+			items[i].score = score
+		}
+	}
+
+	compare := func(a, b sortItem) int {
+		cmp := 0
+		switch {
+		case opts.alpha && opts.by != "":
+			switch {
+			case a.weight == nil && b.weight != nil:
+				cmp = -1
+			case a.weight != nil && b.weight == nil:
+				cmp = 1
+			default:
+				cmp = bytes.Compare(a.weight, b.weight)
+			}
+		case opts.alpha:
+			cmp = bytes.Compare(a.element, b.element)
+		case a.score < b.score:
+			cmp = -1
+		case a.score > b.score:
+			cmp = 1
+		}
+		// equal weights are ordered by the elements, so that the result is deterministic
+		if cmp == 0 {
+			cmp = bytes.Compare(a.element, b.element)
+		}
+		if opts.desc {
+			cmp = -cmp
+		}
+		return cmp
+	}
+	if !opts.noSort {
+		sort.Slice(items, func(i, j int) bool { return compare(items[i], items[j]) < 0 })
+	}
+	return items, nil
+}
+
+// sortKey handles SORT and SORT_RO. SORT ... STORE destination stores the result as a list.
+func sortKey(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "sort" && cmdName != "sort_ro" {
+		logger.Error("sortKey Function: cmdName is not sort or sort_ro")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 2 {
+		return resp.MakeErrorData("wrong number of arguments for '" + cmdName + "' command")
+	}
+	opts, errData := parseSortOptions(cmd[2:], cmdName == "sort")
+	if errData != nil {
+		return errData
+	}
+
+	key := string(cmd[1])
+	elements, errData := m.sortElements(key)
+	if errData != nil {
+		return errData
+	}
+	items, errData := m.sortItems(elements, opts)
+	if errData != nil {
+		return errData
+	}
+
+	start, end := opts.offset, len(items)
+	if start < 0 {
+		start = 0
+	}
+	if start > len(items) {
+		start = len(items)
+	}
+	if opts.count >= 0 && start+opts.count < end {
+		end = start + opts.count
+	}
+	items = items[start:end]
+
+	res := make([][]byte, 0, len(items))
+	for _, item := range items {
+		if len(opts.gets) == 0 {
+			res = append(res, item.element)
+			continue
+		}
+		for _, pattern := range opts.gets {
+			val, _ := m.sortLookup(pattern, item.element)
+			res = append(res, val)
+		}
+	}
+
+	if opts.store != "" {
+		return m.sortStore(opts.store, res)
+	}
+	data := make([]resp.RedisData, len(res))
+	for i, val := range res {
+		data[i] = resp.MakeBulkData(val)
+	}
+	return resp.MakeArrayData(data)
+}
+
+// sortStore replaces dst by a list of values, missing values are stored as empty strings.
+// dst is deleted if values is empty. It returns the length of the list.
+func (m *MemDb) sortStore(dst string, values [][]byte) resp.RedisData {
+	m.CheckTTL(dst)
+	m.locks.Lock(dst)
+	defer m.locks.UnLock(dst)
+	m.DelTTL(dst)
+	if len(values) == 0 {
+		if m.db.Delete(dst) > 0 {
+			m.notify(notifyGeneric, "del", dst)
+		}
+		return resp.MakeIntData(0)
+	}
+	list := NewList()
+	for _, val := range values {
+		if val == nil {
+			val = []byte{}
+		}
+		list.RPush(val)
+	}
+	m.db.Set(dst, list)
+	m.notify(notifyList, "sortstore", dst)
+	return resp.MakeIntData(int64(len(values)))
+}
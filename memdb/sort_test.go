@@ -0,0 +1,112 @@
+package memdb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VincentFF/thinredis/resp"
+)
+
+// sortReply executes a sort command on m and returns its reply as strings, nil values become "(nil)"
+func sortReply(t *testing.T, m *MemDb, cmd string) []string {
+	t.Helper()
+	res := m.ExecCommand(bytes.Fields([]byte(cmd)))
+	arr, ok := res.(*resp.ArrayData)
+	if !ok {
+		t.Fatalf("%s replies %q", cmd, res.ToBytes())
+	}
+	values := make([]string, 0)
+	for _, val := range arr.Data() {
+		if val.ByteData() == nil {
+			values = append(values, "(nil)")
+		} else {
+			values = append(values, string(val.ByteData()))
+		}
+	}
+	return values
+}
+
+func TestSort(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "rpush l 3 10 1 2", "sadd s b c a")
+	cases := map[string]string{
+		"sort l":                    "1 2 3 10",
+		"sort l desc":               "10 3 2 1",
+		"sort l alpha":              "1 10 2 3",
+		"sort l limit 1 2":          "2 3",
+		"sort l limit 3 -1":         "10",
+		"sort_ro s alpha desc":      "c b a",
+		"sort l by nosort":          "3 10 1 2",
+		"sort l limit 10 2":         "",
+		"sort none":                 "",
+		"sort_ro l limit -1 1 desc": "10",
+	}
+	for cmd, expect := range cases {
+		if got := strings.Join(sortReply(t, memdb, cmd), " "); got != expect {
+			t.Errorf("%s replies %q, expect %q", cmd, got, expect)
+		}
+	}
+
+	if res := memdb.ExecCommand(bytes.Fields([]byte("sort s"))); !bytes.HasPrefix(res.ToBytes(), []byte("-error: one or more scores")) {
+		t.Errorf("numeric sort of strings replies %q", res.ToBytes())
+	}
+	execCommands(memdb, "set str v")
+	if res := memdb.ExecCommand(bytes.Fields([]byte("sort str"))); !bytes.HasPrefix(res.ToBytes(), []byte("-WRONGTYPE")) {
+		t.Errorf("sort of a string replies %q", res.ToBytes())
+	}
+	if res := memdb.ExecCommand(bytes.Fields([]byte("sort_ro l store dst"))); !bytes.Equal(res.ToBytes(), []byte("-error: syntax error\r\n")) {
+		t.Errorf("sort_ro with store replies %q", res.ToBytes())
+	}
+}
+
+func TestSortByGet(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "sadd users 1 2 3",
+		"set weight_1 30", "set weight_2 10", "set weight_3 20",
+		"hset user_1 name alice age 7", "hset user_2 name bob", "hset user_3 name carol age 5")
+
+	if got := strings.Join(sortReply(t, memdb, "sort users by weight_*"), " "); got != "2 3 1" {
+		t.Errorf("sort by weights replies %q", got)
+	}
+	if got := strings.Join(sortReply(t, memdb, "sort users by user_*->age get # get user_*->name get nokey_*"), " "); got != "2 bob (nil) 3 carol (nil) 1 alice (nil)" {
+		t.Errorf("sort by hash fields with get replies %q", got)
+	}
+	if got := strings.Join(sortReply(t, memdb, "sort users by user_*->name alpha desc get user_*->name"), " "); got != "carol bob alice" {
+		t.Errorf("alpha sort by hash fields replies %q", got)
+	}
+
+	res := memdb.ExecCommand(bytes.Fields([]byte("sort users by weight_* get user_*->name store dst")))
+	if !bytes.Equal(res.ToBytes(), []byte(":3\r\n")) {
+		t.Errorf("sort with store replies %q", res.ToBytes())
+	}
+	if got := strings.Join(sortReply(t, memdb, "lrange dst 0 -1"), " "); got != "bob carol alice" {
+		t.Errorf("stored list is %q", got)
+	}
+	if _, ok := memdb.meta.Get("dst"); !ok {
+		t.Error("stored list is not accounted")
+	}
+	execCommands(memdb, "sort none store dst")
+	if _, ok := memdb.db.Get("dst"); ok {
+		t.Error("sort of an empty key should delete the destination")
+	}
+}
+
+func TestSortLookupIsNotAccess(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "rpush l 1 2", "set w_1 2", "set w_2 1", "hset h_1 f a", "hset h_2 f b")
+	for _, key := range []string{"w_1", "w_2", "h_1", "h_2"} {
+		tem, _ := memdb.meta.Get(key)
+		tem.(*keyMeta).access.Store(time.Now().UnixMilli() - 3600500)
+	}
+	if got := strings.Join(sortReply(t, memdb, "sort l by w_* get h_*->f"), " "); got != "b a" {
+		t.Fatalf("sort by weights replies %q, expect %q", got, "b a")
+	}
+	for _, key := range []string{"w_1", "w_2", "h_1", "h_2"} {
+		res := objectKey(memdb, [][]byte{[]byte("object"), []byte("idletime"), []byte(key)})
+		if !bytes.Equal(res.ToBytes(), []byte(":3600\r\n")) {
+			t.Errorf("idletime of %s is %q after sort, expect 3600", key, res.ToBytes())
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package memdb
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -12,6 +14,30 @@ import (
 
 // string.go file implements the string commands of redis
 
+var errInvalidExpire = errors.New("invalid expire time")
+
+// expireAtMilli converts the argument of expire option ex, px, exat or pxat to an absolute unix time in milliseconds
+func expireAtMilli(opt string, arg []byte) (int64, error) {
+	v, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || v <= 0 {
+		return 0, errInvalidExpire
+	}
+	if opt == "ex" || opt == "exat" {
+		if v, err = secondsToMilli(v); err != nil {
+			return 0, errInvalidExpire
+		}
+	}
+	if opt == "ex" || opt == "px" {
+		now := time.Now().UnixMilli()
+		if v > math.MaxInt64-now {
+			return 0, errInvalidExpire
+		}
+		v += now
+	}
+	return v, nil
+}
+
+// setString handles SET key value [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
 func setString(m *MemDb, cmd [][]byte) resp.RedisData {
 	cmdName := strings.ToLower(string(cmd[0]))
 	if cmdName != "set" {
@@ -21,15 +47,16 @@ func setString(m *MemDb, cmd [][]byte) resp.RedisData {
 	if len(cmd) < 3 {
 		return resp.MakeErrorData("error: commands is invalid")
 	}
+	key := string(cmd[1])
 
-	m.CheckTTL(string(cmd[1])) // check ttl first. if a key is expired, the key will be deleted.
+	m.CheckTTL(key) // check ttl first. if a key is expired, the key will be deleted.
 
 	// check option params
-	var err error
-	var nx, xx, get, ex, keepttl bool
-	var exval int64
+	var nx, xx, get, keepttl, expire bool
+	var expireAt int64
 	for i := 3; i < len(cmd); i++ {
-		switch strings.ToLower(string(cmd[i])) {
+		opt := strings.ToLower(string(cmd[i]))
+		switch opt {
 		case "nx":
 			nx = true
 		case "xx":
@@ -38,83 +65,60 @@ func setString(m *MemDb, cmd [][]byte) resp.RedisData {
 			get = true
 		case "keepttl":
 			keepttl = true
-		case "ex":
-			ex = true
+		case "ex", "px", "exat", "pxat":
 			i++
-			if i >= len(cmd) {
+			if expire || i >= len(cmd) {
 				return resp.MakeErrorData("error: commands is invalid")
 			}
-			exval, err = strconv.ParseInt(string(cmd[i]), 10, 64)
-			if err != nil {
-				return resp.MakeErrorData(fmt.Sprintf("error: commands is invalid, %s is not interger", string(cmd[i])))
+			var err error
+			if expireAt, err = expireAtMilli(opt, cmd[i]); err != nil {
+				return resp.MakeErrorData("error: invalid expire time in 'set' command")
 			}
+			expire = true
 		default:
 			return resp.MakeErrorData("Error unsupported option: " + string(cmd[i]))
 		}
 	}
 
-	if (nx && xx) || (ex && keepttl) {
+	if (nx && xx) || (expire && keepttl) {
 		return resp.MakeErrorData("error: commands is invalid")
 	}
 
-	// set key based on options
-	m.locks.Lock(string(cmd[1]))
-	defer m.locks.UnLock(string(cmd[1]))
+	m.locks.Lock(key)
+	defer m.locks.UnLock(key)
 
-	var res resp.RedisData
-	oldVal, oldOk := m.db.Get(string(cmd[1]))
-	// check if the value is string
+	oldVal, oldOk := m.db.Get(key)
+	// only GET needs the old value to be a string, SET overwrites a value of any type
 	var oldTypeVal []byte
-	var typeOk bool
-	if oldOk {
-		oldTypeVal, typeOk = oldVal.([]byte)
-		if !typeOk {
+	if oldOk && get {
+		var typeOk bool
+		if oldTypeVal, typeOk = oldVal.([]byte); !typeOk {
 			return resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
 		}
 	}
 
-	// set key and check if it satisfies nx or xx condition
-	// return the set result if the get command is not given
-	if nx || xx {
-		if nx {
-			if !oldOk {
-				m.db.Set(string(cmd[1]), cmd[2])
-			} else {
-				res = resp.MakeBulkData(nil)
-			}
-		} else {
-			if oldOk {
-				m.db.Set(string(cmd[1]), cmd[2])
-				res = resp.MakeStringData("OK")
-			} else {
-				res = resp.MakeBulkData(nil)
-			}
-		}
-	} else {
-		m.db.Set(string(cmd[1]), cmd[2])
-		res = resp.MakeStringData("OK")
-	}
-
-	// If a get command offered, return GET result
-	if get {
-		if !oldOk {
-			res = resp.MakeBulkData(nil)
-		} else {
-			res = resp.MakeBulkData(oldTypeVal)
+	// set key if it satisfies nx or xx condition
+	if (nx && oldOk) || (xx && !oldOk) {
+		if get {
+			return resp.MakeBulkData(oldTypeVal)
 		}
+		return resp.MakeBulkData(nil)
 	}
-
-	// set ttl after key is existed
-
+	m.db.Set(key, cmd[2])
 	if !keepttl {
-		m.DelTTL(string(cmd[1]))
+		m.DelTTL(key)
 	}
-
-	if ex {
-		m.SetTTL(string(cmd[1]), exval+time.Now().Unix())
+	m.notify(notifyString, "set", key)
+	if expire {
+		m.SetTTL(key, expireAt)
+		m.notify(notifyGeneric, "expire", key)
 	}
 
-	return res
+	// If a get option offered, return the old value
+	if get {
+		return resp.MakeBulkData(oldTypeVal)
+	}
+	return resp.MakeStringData("OK")
 }
 
 func getString(m *MemDb, cmd [][]byte) resp.RedisData {
@@ -145,6 +149,121 @@ func getString(m *MemDb, cmd [][]byte) resp.RedisData {
 	return resp.MakeBulkData(byteVal)
 }
 
+// getExString handles GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST]
+func getExString(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "getex" {
+		logger.Error("getExString func: cmdName != getex")
+		return resp.MakeErrorData("Server error")
+	}
+	if len(cmd) < 2 || len(cmd) > 4 {
+		return resp.MakeErrorData("error: commands is invalid")
+	}
+
+	var persist, expire bool
+	var expireAt int64
+	if len(cmd) > 2 {
+		opt := strings.ToLower(string(cmd[2]))
+		switch {
+		case opt == "persist" && len(cmd) == 3:
+			persist = true
+		case (opt == "ex" || opt == "px" || opt == "exat" || opt == "pxat") && len(cmd) == 4:
+			var err error
+			if expireAt, err = expireAtMilli(opt, cmd[3]); err != nil {
+				return resp.MakeErrorData("error: invalid expire time in 'getex' command")
+			}
+			expire = true
+		default:
+			return resp.MakeErrorData("error: commands is invalid")
+		}
+	}
+
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return resp.MakeBulkData(nil)
+	}
+
+	m.locks.Lock(key)
+	defer m.locks.UnLock(key)
+
+	val, ok := m.db.Get(key)
+	if !ok {
+		return resp.MakeBulkData(nil)
+	}
+	byteVal, ok := val.([]byte)
+	if !ok {
+		return resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	if persist {
+		if m.DelTTL(key) == 1 {
+			m.notify(notifyGeneric, "persist", key)
+		}
+	} else if expire {
+		m.SetTTL(key, expireAt)
+		m.notify(notifyGeneric, "expire", key)
+	}
+	return resp.MakeBulkData(byteVal)
+}
+
+// getDelString handles GETDEL key, which deletes key after getting its value
+func getDelString(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "getdel" {
+		logger.Error("getDelString func: cmdName != getdel")
+		return resp.MakeErrorData("Server error")
+	}
+	if len(cmd) != 2 {
+		return resp.MakeErrorData("error: commands is invalid")
+	}
+
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return resp.MakeBulkData(nil)
+	}
+
+	m.locks.Lock(key)
+	defer m.locks.UnLock(key)
+
+	val, ok := m.db.Get(key)
+	if !ok {
+		return resp.MakeBulkData(nil)
+	}
+	byteVal, ok := val.([]byte)
+	if !ok {
+		return resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	m.db.Delete(key)
+	m.DelTTL(key)
+	m.notify(notifyGeneric, "del", key)
+	return resp.MakeBulkData(byteVal)
+}
+
+// getSetString handles GETSET key value, which sets key to value and returns the old value
+func getSetString(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "getset" {
+		logger.Error("getSetString func: cmdName != getset")
+		return resp.MakeErrorData("Server error")
+	}
+	if len(cmd) != 3 {
+		return resp.MakeErrorData("error: commands is invalid")
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+
+	m.locks.Lock(key)
+	defer m.locks.UnLock(key)
+
+	var oldVal []byte
+	if val, ok := m.db.Get(key); ok {
+		if oldVal, ok = val.([]byte); !ok {
+			return resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+	}
+	m.db.Set(key, cmd[2])
+	m.DelTTL(key)
+	m.notify(notifyString, "set", key)
+	return resp.MakeBulkData(oldVal)
+}
+
 func getRangeString(m *MemDb, cmd [][]byte) resp.RedisData {
 	if strings.ToLower(string(cmd[0])) != "getrange" {
 		logger.Error("getRangeString func: cmdName != getrange")
@@ -241,6 +360,7 @@ func setRangeString(m *MemDb, cmd [][]byte) resp.RedisData {
 		newVal = append(newVal, cmd[3]...)
 	}
 	m.db.Set(key, newVal)
+	m.notify(notifyString, "setrange", key)
 	return resp.MakeIntData(int64(len(newVal)))
 }
 
@@ -298,24 +418,30 @@ func mSetString(m *MemDb, cmd [][]byte) resp.RedisData {
 	for i := 0; i < len(keys); i++ {
 		m.DelTTL(keys[i])
 		m.db.Set(keys[i], vals[i])
+		m.notify(notifyString, "set", keys[i])
 	}
 	return resp.MakeStringData("OK")
 }
 
+// setExString handles SETEX key seconds value and PSETEX key milliseconds value
 func setExString(m *MemDb, cmd [][]byte) resp.RedisData {
-	if strings.ToLower(string(cmd[0])) != "setex" {
-		logger.Error("setExString func: cmdName != setex")
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "setex" && cmdName != "psetex" {
+		logger.Error("setExString func: cmdName != setex or psetex")
 		return resp.MakeErrorData("Server error")
 	}
 	if len(cmd) != 4 {
 		return resp.MakeErrorData("error: commands is invalid")
 	}
 
-	ex, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	opt := "ex"
+	if cmdName == "psetex" {
+		opt = "px"
+	}
+	ttl, err := expireAtMilli(opt, cmd[2])
 	if err != nil {
-		return resp.MakeErrorData(fmt.Sprintf("error: %s is not a integer", string(cmd[2])))
+		return resp.MakeErrorData(fmt.Sprintf("error: invalid expire time in '%s' command", cmdName))
 	}
-	ttl := time.Now().Unix() + ex
 	key := string(cmd[1])
 	val := cmd[3]
 
@@ -323,6 +449,8 @@ func setExString(m *MemDb, cmd [][]byte) resp.RedisData {
 	defer m.locks.UnLock(key)
 	m.db.Set(key, val)
 	m.SetTTL(key, ttl)
+	m.notify(notifyString, "set", key)
+	m.notify(notifyGeneric, "expire", key)
 
 	return resp.MakeStringData("OK")
 }
@@ -343,6 +471,9 @@ func setNxString(m *MemDb, cmd [][]byte) resp.RedisData {
 	m.locks.Lock(key)
 	defer m.locks.UnLock(key)
 	res := m.db.SetIfNotExist(key, val)
+	if res == 1 {
+		m.notify(notifyString, "set", key)
+	}
 
 	return resp.MakeIntData(int64(res))
 }
@@ -388,6 +519,7 @@ func incrString(m *MemDb, cmd [][]byte) resp.RedisData {
 	val, ok := m.db.Get(key)
 	if !ok {
 		m.db.Set(key, []byte("1"))
+		m.notify(notifyString, "incrby", key)
 		return resp.MakeIntData(1)
 	}
 	typeVal, ok := val.([]byte)
@@ -400,6 +532,7 @@ func incrString(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 	intVal++
 	m.db.Set(key, []byte(strconv.FormatInt(intVal, 10)))
+	m.notify(notifyString, "incrby", key)
 	return resp.MakeIntData(intVal)
 }
 
@@ -423,6 +556,7 @@ func incrByString(m *MemDb, cmd [][]byte) resp.RedisData {
 	val, ok := m.db.Get(key)
 	if !ok {
 		m.db.Set(key, []byte(strconv.FormatInt(inc, 10)))
+		m.notify(notifyString, "incrby", key)
 		return resp.MakeIntData(inc)
 	}
 	typeVal, ok := val.([]byte)
@@ -435,6 +569,7 @@ func incrByString(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 	intVal += inc
 	m.db.Set(key, []byte(strconv.FormatInt(intVal, 10)))
+	m.notify(notifyString, "incrby", key)
 	return resp.MakeIntData(intVal)
 }
 
@@ -454,6 +589,7 @@ func decrString(m *MemDb, cmd [][]byte) resp.RedisData {
 	val, ok := m.db.Get(key)
 	if !ok {
 		m.db.Set(key, []byte("-1"))
+		m.notify(notifyString, "incrby", key)
 		return resp.MakeIntData(-1)
 	}
 	typeVal, ok := val.([]byte)
@@ -466,6 +602,7 @@ func decrString(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 	intVal--
 	m.db.Set(key, []byte(strconv.FormatInt(intVal, 10)))
+	m.notify(notifyString, "incrby", key)
 	return resp.MakeIntData(intVal)
 }
 
@@ -489,6 +626,7 @@ func decrByString(m *MemDb, cmd [][]byte) resp.RedisData {
 	val, ok := m.db.Get(key)
 	if !ok {
 		m.db.Set(key, []byte(strconv.FormatInt(-dec, 10)))
+		m.notify(notifyString, "incrby", key)
 		return resp.MakeIntData(-dec)
 	}
 	typeVal, ok := val.([]byte)
@@ -501,6 +639,7 @@ func decrByString(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 	intVal -= dec
 	m.db.Set(key, []byte(strconv.FormatInt(intVal, 10)))
+	m.notify(notifyString, "incrby", key)
 	return resp.MakeIntData(intVal)
 }
 
@@ -527,6 +666,7 @@ func incrByFloatString(m *MemDb, cmd [][]byte) resp.RedisData {
 	val, ok := m.db.Get(key)
 	if !ok {
 		m.db.Set(key, []byte(strconv.FormatFloat(inc, 'f', -1, 64)))
+		m.notify(notifyString, "incrbyfloat", key)
 		return resp.MakeBulkData([]byte(strconv.FormatFloat(inc, 'f', -1, 64)))
 	}
 	typeVal, ok := val.([]byte)
@@ -539,6 +679,7 @@ func incrByFloatString(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 	floatVal += inc
 	m.db.Set(key, []byte(strconv.FormatFloat(floatVal, 'f', -1, 64)))
+	m.notify(notifyString, "incrbyfloat", key)
 	return resp.MakeBulkData([]byte(strconv.FormatFloat(floatVal, 'f', -1, 64)))
 }
 
@@ -559,6 +700,7 @@ func appendString(m *MemDb, cmd [][]byte) resp.RedisData {
 	oldVal, ok := m.db.Get(key)
 	if !ok {
 		m.db.Set(key, val)
+		m.notify(notifyString, "append", key)
 		return resp.MakeIntData(int64(len(val)))
 	}
 	typeVal, ok := oldVal.([]byte)
@@ -567,23 +709,28 @@ func appendString(m *MemDb, cmd [][]byte) resp.RedisData {
 	}
 	newVal := append(typeVal, val...)
 	m.db.Set(key, newVal)
+	m.notify(notifyString, "append", key)
 	return resp.MakeIntData(int64(len(newVal)))
 }
 
 func RegisterStringCommands() {
-	RegisterCommand("set", setString)
+	RegisterWriteCommand("set", setString, 1, 1, 1)
 	RegisterCommand("get", getString)
 	RegisterCommand("getrange", getRangeString)
-	RegisterCommand("setrange", setRangeString)
+	RegisterWriteCommand("setrange", setRangeString, 1, 1, 1)
 	RegisterCommand("mget", mGetString)
-	RegisterCommand("mset", mSetString)
-	RegisterCommand("setex", setExString)
-	RegisterCommand("setnx", setNxString)
+	RegisterWriteCommand("mset", mSetString, 1, -1, 2)
+	RegisterWriteCommand("getex", getExString, 1, 1, 1)
+	RegisterWriteCommand("getdel", getDelString, 1, 1, 1)
+	RegisterWriteCommand("getset", getSetString, 1, 1, 1)
+	RegisterWriteCommand("setex", setExString, 1, 1, 1)
+	RegisterWriteCommand("psetex", setExString, 1, 1, 1)
+	RegisterWriteCommand("setnx", setNxString, 1, 1, 1)
 	RegisterCommand("strlen", strLenString)
-	RegisterCommand("incr", incrString)
-	RegisterCommand("incrby", incrByString)
-	RegisterCommand("decr", decrString)
-	RegisterCommand("decrby", decrByString)
-	RegisterCommand("incrbyfloat", incrByFloatString)
-	RegisterCommand("append", appendString)
+	RegisterWriteCommand("incr", incrString, 1, 1, 1)
+	RegisterWriteCommand("incrby", incrByString, 1, 1, 1)
+	RegisterWriteCommand("decr", decrString, 1, 1, 1)
+	RegisterWriteCommand("decrby", decrByString, 1, 1, 1)
+	RegisterWriteCommand("incrbyfloat", incrByFloatString, 1, 1, 1)
+	RegisterWriteCommand("append", appendString, 1, 1, 1)
 }
@@ -2,10 +2,12 @@ package memdb
 
 import (
 	"bytes"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/VincentFF/thinredis/config"
+	"github.com/VincentFF/thinredis/resp"
 )
 
 func init() {
@@ -37,7 +39,7 @@ func TestSetString(t *testing.T) {
 		t.Error("set value error")
 	}
 	ttl, ok := mem.ttlKeys.Get("a")
-	if !ok || ttl.(int64)-time.Now().Unix() > 100 || ttl.(int64)-time.Now().Unix() < 99 {
+	if !ok || ttl.(int64)-time.Now().UnixMilli() > 100000 || ttl.(int64)-time.Now().UnixMilli() < 99000 {
 		t.Error("set ttl error")
 	}
 
@@ -46,8 +48,101 @@ func TestSetString(t *testing.T) {
 	if !bytes.Equal(res.ToBytes(), []byte("$1\r\nb\r\n")) {
 		t.Error("set reply error")
 	}
+	// keepttl keeps the ttl set by ex above
 	_, ok = mem.ttlKeys.Get("a")
-	if ok {
+	if !ok {
 		t.Error("set keepttl error")
 	}
 }
+
+func TestSetStringExpireOptions(t *testing.T) {
+	mem := NewMemDb()
+
+	res := setString(mem, [][]byte{[]byte("set"), []byte("a"), []byte("a"), []byte("px"), []byte("1500")})
+	if !bytes.Equal(res.ToBytes(), []byte("+OK\r\n")) {
+		t.Error("set px reply error")
+	}
+	ttl, ok := mem.ttlKeys.Get("a")
+	if !ok || ttl.(int64)-time.Now().UnixMilli() > 1500 || ttl.(int64)-time.Now().UnixMilli() < 1400 {
+		t.Error("set px ttl error")
+	}
+
+	at := time.Now().Add(time.Hour).Unix()
+	setString(mem, [][]byte{[]byte("set"), []byte("a"), []byte("a"), []byte("exat"), []byte(strconv.FormatInt(at, 10))})
+	if ttl, ok = mem.ttlKeys.Get("a"); !ok || ttl.(int64) != at*1000 {
+		t.Error("set exat ttl error")
+	}
+	setString(mem, [][]byte{[]byte("set"), []byte("a"), []byte("a"), []byte("pxat"), []byte(strconv.FormatInt(at*1000+1, 10))})
+	if ttl, ok = mem.ttlKeys.Get("a"); !ok || ttl.(int64) != at*1000+1 {
+		t.Error("set pxat ttl error")
+	}
+
+	for _, opts := range [][]string{{"px", "0"}, {"ex", "-1"}, {"px", "100", "ex", "100"}, {"pxat", "100", "keepttl"}, {"nx", "xx"}} {
+		cmd := [][]byte{[]byte("set"), []byte("a"), []byte("b")}
+		for _, opt := range opts {
+			cmd = append(cmd, []byte(opt))
+		}
+		if _, isErr := setString(mem, cmd).(*resp.ErrorData); !isErr {
+			t.Errorf("set with %v should fail", opts)
+		}
+	}
+
+	// nx fails and keeps the old value and ttl
+	res = setString(mem, [][]byte{[]byte("set"), []byte("a"), []byte("b"), []byte("nx"), []byte("get")})
+	if val, _ := mem.db.Get("a"); !bytes.Equal(res.ToBytes(), []byte("$1\r\na\r\n")) || !bytes.Equal(val.([]byte), []byte("a")) {
+		t.Error("set nx get error")
+	}
+	if _, ok = mem.ttlKeys.Get("a"); !ok {
+		t.Error("failed set should keep ttl")
+	}
+
+	// set overwrites a value of any type unless get is given
+	mem.db.Set("list", NewList())
+	if _, isErr := setString(mem, [][]byte{[]byte("set"), []byte("list"), []byte("v"), []byte("get")}).(*resp.ErrorData); !isErr {
+		t.Error("set get on list should fail")
+	}
+	if res = setString(mem, [][]byte{[]byte("set"), []byte("list"), []byte("v")}); !bytes.Equal(res.ToBytes(), []byte("+OK\r\n")) {
+		t.Error("set should overwrite list")
+	}
+
+	res = setExString(mem, [][]byte{[]byte("psetex"), []byte("p"), []byte("2000"), []byte("v")})
+	ttl, ok = mem.ttlKeys.Get("p")
+	if !bytes.Equal(res.ToBytes(), []byte("+OK\r\n")) || !ok || ttl.(int64)-time.Now().UnixMilli() > 2000 || ttl.(int64)-time.Now().UnixMilli() < 1900 {
+		t.Error("psetex error")
+	}
+}
+
+func TestGetExDelSetString(t *testing.T) {
+	mem := NewMemDb()
+	mem.db.Set("a", []byte("a"))
+
+	res := getExString(mem, [][]byte{[]byte("getex"), []byte("a"), []byte("ex"), []byte("100")})
+	ttl, ok := mem.ttlKeys.Get("a")
+	if !bytes.Equal(res.ToBytes(), []byte("$1\r\na\r\n")) || !ok || ttl.(int64)-time.Now().UnixMilli() < 99000 {
+		t.Error("getex ex error")
+	}
+	getExString(mem, [][]byte{[]byte("getex"), []byte("a"), []byte("persist")})
+	if _, ok = mem.ttlKeys.Get("a"); ok {
+		t.Error("getex persist error")
+	}
+	if _, isErr := getExString(mem, [][]byte{[]byte("getex"), []byte("a"), []byte("persist"), []byte("1")}).(*resp.ErrorData); !isErr {
+		t.Error("getex persist with argument should fail")
+	}
+
+	res = getSetString(mem, [][]byte{[]byte("getset"), []byte("a"), []byte("b")})
+	if val, _ := mem.db.Get("a"); !bytes.Equal(res.ToBytes(), []byte("$1\r\na\r\n")) || !bytes.Equal(val.([]byte), []byte("b")) {
+		t.Error("getset error")
+	}
+	res = getSetString(mem, [][]byte{[]byte("getset"), []byte("new"), []byte("v")})
+	if !bytes.Equal(res.ToBytes(), []byte("$-1\r\n")) {
+		t.Error("getset new key error")
+	}
+
+	res = getDelString(mem, [][]byte{[]byte("getdel"), []byte("a")})
+	if _, ok = mem.db.Get("a"); !bytes.Equal(res.ToBytes(), []byte("$1\r\nb\r\n")) || ok {
+		t.Error("getdel error")
+	}
+	if res = getDelString(mem, [][]byte{[]byte("getdel"), []byte("a")}); !bytes.Equal(res.ToBytes(), []byte("$-1\r\n")) {
+		t.Error("getdel missing key error")
+	}
+}
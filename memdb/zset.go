@@ -0,0 +1,1015 @@
+package memdb
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/VincentFF/thinredis/logger"
+	"github.com/VincentFF/thinredis/resp"
+)
+
+// zset.go implements the sorted set commands of redis
+
+// parseScore parses a score, NaN is not a valid score
+func parseScore(b []byte) (float64, bool) {
+	score, err := strconv.ParseFloat(string(b), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+	return score, true
+}
+
+// parseScoreRange parses the min and max of a score range, a bound starting with ( is excluded
+func parseScoreRange(min, max []byte) (*scoreRange, bool) {
+	r := &scoreRange{}
+	var ok1, ok2 bool
+	if len(min) > 0 && min[0] == '(' {
+		r.minEx, min = true, min[1:]
+	}
+	if len(max) > 0 && max[0] == '(' {
+		r.maxEx, max = true, max[1:]
+	}
+	r.min, ok1 = parseScore(min)
+	r.max, ok2 = parseScore(max)
+	return r, ok1 && ok2
+}
+
+// zMembersReply replies members, followed by their scores if withScores is true
+func zMembersReply(members []ZMember, withScores bool) resp.RedisData {
+	res := make([]resp.RedisData, 0, len(members))
+	for _, member := range members {
+		res = append(res, resp.MakeBulkData([]byte(member.Member)))
+		if withScores {
+			res = append(res, resp.MakeBulkData([]byte(formatScore(member.Score))))
+		}
+	}
+	return resp.MakeArrayData(res)
+}
+
+// zaddFlags are the options of ZADD
+type zaddFlags struct {
+	nx, xx, gt, lt, ch, incr bool
+}
+
+// zAdd adds the members with their scores to the sorted set at key by flags.
+// With INCR, it replies the new score, or nil if the member is not updated.
+func (m *MemDb) zAdd(key string, flags zaddFlags, scores []float64, members [][]byte) resp.RedisData {
+	m.CheckTTL(key)
+	m.locks.Lock(key)
+	defer m.locks.UnLock(key)
+
+	var zset *ZSet
+	tem, ok := m.db.Get(key)
+	if ok {
+		zset, ok = tem.(*ZSet)
+		if !ok {
+			return resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+	} else {
+		if flags.xx {
+			if flags.incr {
+				return resp.MakeBulkData(nil)
+			}
+			return resp.MakeIntData(0)
+		}
+		zset = NewZSet()
+		m.db.Set(key, zset)
+	}
+
+	added, changed := 0, 0
+	var incrScore *float64
+	for i, member := range members {
+		score := scores[i]
+		cur, exists := zset.Score(string(member))
+		if exists {
+			if flags.nx {
+				continue
+			}
+			if flags.incr {
+				score += cur
+				if math.IsNaN(score) {
+					return resp.MakeErrorData("error: resulting score is not a number (NaN)")
+				}
+			}
+			if (flags.gt && score <= cur) || (flags.lt && score >= cur) {
+				continue
+			}
+			if score != cur {
+				zset.Add(string(member), score)
+				changed++
+			}
+		} else {
+			if flags.xx {
+				continue
+			}
+			zset.Add(string(member), score)
+			added++
+		}
+		incrScore = &score
+	}
+
+	if added+changed > 0 {
+		if flags.incr {
+			m.notify(notifyZset, "zincr", key)
+		} else {
+			m.notify(notifyZset, "zadd", key)
+		}
+	}
+	if flags.incr {
+		if incrScore == nil {
+			return resp.MakeBulkData(nil)
+		}
+		return resp.MakeBulkData([]byte(formatScore(*incrScore)))
+	}
+	if flags.ch {
+		return resp.MakeIntData(int64(added + changed))
+	}
+	return resp.MakeIntData(int64(added))
+}
+
+// zAddZSet handles ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func zAddZSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zadd" {
+		logger.Error("zAddZSet Function: cmdName is not zadd")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 4 {
+		return resp.MakeErrorData("wrong number of arguments for 'zadd' command")
+	}
+
+	var flags zaddFlags
+	pos := 2
+	for ; pos < len(cmd); pos++ {
+		switch strings.ToLower(string(cmd[pos])) {
+		case "nx":
+			flags.nx = true
+			continue
+		case "xx":
+			flags.xx = true
+			continue
+		case "gt":
+			flags.gt = true
+			continue
+		case "lt":
+			flags.lt = true
+			continue
+		case "ch":
+			flags.ch = true
+			continue
+		case "incr":
+			flags.incr = true
+			continue
+		}
+		break
+	}
+	pairs := cmd[pos:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return resp.MakeErrorData("error: syntax error")
+	}
+	if flags.nx && flags.xx {
+		return resp.MakeErrorData("error: XX and NX options at the same time are not compatible")
+	}
+	if (flags.gt && flags.lt) || (flags.nx && (flags.gt || flags.lt)) {
+		return resp.MakeErrorData("error: GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if flags.incr && len(pairs) > 2 {
+		return resp.MakeErrorData("error: INCR option supports a single increment-element pair")
+	}
+
+	scores := make([]float64, 0, len(pairs)/2)
+	members := make([][]byte, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		score, ok := parseScore(pairs[i])
+		if !ok {
+			return resp.MakeErrorData("error: value is not a valid float")
+		}
+		scores = append(scores, score)
+		members = append(members, pairs[i+1])
+	}
+	return m.zAdd(string(cmd[1]), flags, scores, members)
+}
+
+// zIncrByZSet handles ZINCRBY key increment member
+func zIncrByZSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zincrby" {
+		logger.Error("zIncrByZSet Function: cmdName is not zincrby")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) != 4 {
+		return resp.MakeErrorData("wrong number of arguments for 'zincrby' command")
+	}
+	incr, ok := parseScore(cmd[2])
+	if !ok {
+		return resp.MakeErrorData("error: value is not a valid float")
+	}
+	return m.zAdd(string(cmd[1]), zaddFlags{incr: true}, []float64{incr}, [][]byte{cmd[3]})
+}
+
+func zRemZSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zrem" {
+		logger.Error("zRemZSet Function: cmdName is not zrem")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 3 {
+		return resp.MakeErrorData("wrong number of arguments for 'zrem' command")
+	}
+
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return resp.MakeIntData(0)
+	}
+	m.locks.Lock(key)
+	defer m.locks.UnLock(key)
+
+	tem, ok := m.db.Get(key)
+	if !ok {
+		return resp.MakeIntData(0)
+	}
+	zset, ok := tem.(*ZSet)
+	if !ok {
+		return resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	res := 0
+	for i := 2; i < len(cmd); i++ {
+		res += zset.Remove(string(cmd[i]))
+	}
+	if res > 0 {
+		m.notify(notifyZset, "zrem", key)
+	}
+	if zset.Len() == 0 {
+		m.db.Delete(key)
+		m.DelTTL(key)
+		m.notify(notifyGeneric, "del", key)
+	}
+	return resp.MakeIntData(int64(res))
+}
+
+// getZSet returns the sorted set at key for reading, the caller must hold the read lock of key.
+// It returns nil if key doesn't exist, and a WRONGTYPE error if key holds another type.
+func (m *MemDb) getZSet(key string) (*ZSet, resp.RedisData) {
+	tem, ok := m.db.Get(key)
+	if !ok {
+		return nil, nil
+	}
+	zset, ok := tem.(*ZSet)
+	if !ok {
+		return nil, resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return zset, nil
+}
+
+func zScoreZSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zscore" {
+		logger.Error("zScoreZSet Function: cmdName is not zscore")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) != 3 {
+		return resp.MakeErrorData("wrong number of arguments for 'zscore' command")
+	}
+
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return resp.MakeBulkData(nil)
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+
+	zset, errData := m.getZSet(key)
+	if zset == nil {
+		if errData != nil {
+			return errData
+		}
+		return resp.MakeBulkData(nil)
+	}
+	score, ok := zset.Score(string(cmd[2]))
+	if !ok {
+		return resp.MakeBulkData(nil)
+	}
+	return resp.MakeBulkData([]byte(formatScore(score)))
+}
+
+func zMScoreZSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zmscore" {
+		logger.Error("zMScoreZSet Function: cmdName is not zmscore")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 3 {
+		return resp.MakeErrorData("wrong number of arguments for 'zmscore' command")
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+
+	zset, errData := m.getZSet(key)
+	if errData != nil {
+		return errData
+	}
+	res := make([]resp.RedisData, 0, len(cmd)-2)
+	for i := 2; i < len(cmd); i++ {
+		if zset == nil {
+			res = append(res, resp.MakeBulkData(nil))
+			continue
+		}
+		score, ok := zset.Score(string(cmd[i]))
+		if !ok {
+			res = append(res, resp.MakeBulkData(nil))
+			continue
+		}
+		res = append(res, resp.MakeBulkData([]byte(formatScore(score))))
+	}
+	return resp.MakeArrayData(res)
+}
+
+func zCardZSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zcard" {
+		logger.Error("zCardZSet Function: cmdName is not zcard")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) != 2 {
+		return resp.MakeErrorData("wrong number of arguments for 'zcard' command")
+	}
+
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return resp.MakeIntData(0)
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+
+	zset, errData := m.getZSet(key)
+	if zset == nil {
+		if errData != nil {
+			return errData
+		}
+		return resp.MakeIntData(0)
+	}
+	return resp.MakeIntData(int64(zset.Len()))
+}
+
+// zCountZSet handles ZCOUNT key min max
+func zCountZSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zcount" {
+		logger.Error("zCountZSet Function: cmdName is not zcount")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) != 4 {
+		return resp.MakeErrorData("wrong number of arguments for 'zcount' command")
+	}
+	r, ok := parseScoreRange(cmd[2], cmd[3])
+	if !ok {
+		return resp.MakeErrorData("error: min or max is not a float")
+	}
+
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return resp.MakeIntData(0)
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+
+	zset, errData := m.getZSet(key)
+	if zset == nil {
+		if errData != nil {
+			return errData
+		}
+		return resp.MakeIntData(0)
+	}
+	return resp.MakeIntData(int64(zset.Count(r)))
+}
+
+// zRankZSet handles ZRANK and ZREVRANK key member [WITHSCORE]
+func zRankZSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "zrank" && cmdName != "zrevrank" {
+		logger.Error("zRankZSet Function: cmdName is not zrank or zrevrank")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) != 3 && len(cmd) != 4 {
+		return resp.MakeErrorData("wrong number of arguments for '" + cmdName + "' command")
+	}
+	withScore := len(cmd) == 4
+	if withScore && strings.ToLower(string(cmd[3])) != "withscore" {
+		return resp.MakeErrorData("error: syntax error")
+	}
+
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return resp.MakeBulkData(nil)
+	}
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+
+	zset, errData := m.getZSet(key)
+	if zset == nil {
+		if errData != nil {
+			return errData
+		}
+		return resp.MakeBulkData(nil)
+	}
+	member := string(cmd[2])
+	rank, ok := zset.Rank(member, cmdName == "zrevrank")
+	if !ok {
+		return resp.MakeBulkData(nil)
+	}
+	if withScore {
+		score, _ := zset.Score(member)
+		return resp.MakeArrayData([]resp.RedisData{resp.MakeIntData(int64(rank)), resp.MakeBulkData([]byte(formatScore(score)))})
+	}
+	return resp.MakeIntData(int64(rank))
+}
+
+// zrangeOptions are the options of ZRANGE key start stop [BYSCORE] [REV] [LIMIT offset count] [WITHSCORES]
+type zrangeOptions struct {
+	byScore    bool
+	rev        bool
+	limit      bool
+	offset     int
+	count      int
+	withScores bool
+}
+
+func parseZRangeOptions(args [][]byte) (*zrangeOptions, resp.RedisData) {
+	opts := &zrangeOptions{count: -1}
+	for i := 0; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		switch {
+		case option == "byscore":
+			opts.byScore = true
+		case option == "rev":
+			opts.rev = true
+		case option == "withscores":
+			opts.withScores = true
+		case option == "limit" && i+2 < len(args):
+			offset, err1 := strconv.Atoi(string(args[i+1]))
+			count, err2 := strconv.Atoi(string(args[i+2]))
+			if err1 != nil || err2 != nil {
+				return nil, resp.MakeErrorData("error: value is not an integer or out of range")
+			}
+			opts.limit, opts.offset, opts.count = true, offset, count
+			i += 2
+		default:
+			return nil, resp.MakeErrorData("error: syntax error")
+		}
+	}
+	if opts.limit && !opts.byScore {
+		return nil, resp.MakeErrorData("error: syntax error, LIMIT is only supported in combination with BYSCORE")
+	}
+	return opts, nil
+}
+
+// zRange returns the members of zset in the range of start and stop by opts.
+// With BYSCORE and REV, start is the max score and stop is the min score.
+func zRange(zset *ZSet, start, stop []byte, opts *zrangeOptions) ([]ZMember, resp.RedisData) {
+	if opts.byScore {
+		min, max := start, stop
+		if opts.rev {
+			min, max = stop, start
+		}
+		r, ok := parseScoreRange(min, max)
+		if !ok {
+			return nil, resp.MakeErrorData("error: min or max is not a float")
+		}
+		if opts.offset < 0 {
+			return []ZMember{}, nil
+		}
+		return zset.RangeByScore(r, opts.rev, opts.offset, opts.count), nil
+	}
+	startRank, err1 := strconv.Atoi(string(start))
+	stopRank, err2 := strconv.Atoi(string(stop))
+	if err1 != nil || err2 != nil {
+		return nil, resp.MakeErrorData("error: value is not an integer or out of range")
+	}
+	return zset.RangeByRank(startRank, stopRank, opts.rev), nil
+}
+
+// zRangeZSet handles ZRANGE key start stop [BYSCORE] [REV] [LIMIT offset count] [WITHSCORES]
+func zRangeZSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zrange" {
+		logger.Error("zRangeZSet Function: cmdName is not zrange")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 4 {
+		return resp.MakeErrorData("wrong number of arguments for 'zrange' command")
+	}
+	opts, errData := parseZRangeOptions(cmd[4:])
+	if errData != nil {
+		return errData
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+
+	zset, errData := m.getZSet(key)
+	if errData != nil {
+		return errData
+	}
+	if zset == nil {
+		zset = NewZSet()
+	}
+	members, errData := zRange(zset, cmd[2], cmd[3], opts)
+	if errData != nil {
+		return errData
+	}
+	return zMembersReply(members, opts.withScores)
+}
+
+// zPopZSet handles ZPOPMIN and ZPOPMAX key [count]
+func zPopZSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "zpopmin" && cmdName != "zpopmax" {
+		logger.Error("zPopZSet Function: cmdName is not zpopmin or zpopmax")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) != 2 && len(cmd) != 3 {
+		return resp.MakeErrorData("wrong number of arguments for '" + cmdName + "' command")
+	}
+	count := 1
+	if len(cmd) == 3 {
+		var err error
+		count, err = strconv.Atoi(string(cmd[2]))
+		if err != nil || count < 0 {
+			return resp.MakeErrorData("error: value is out of range, must be positive")
+		}
+	}
+
+	key := string(cmd[1])
+	if !m.CheckTTL(key) {
+		return resp.MakeEmptyArrayData()
+	}
+	m.locks.Lock(key)
+	defer m.locks.UnLock(key)
+
+	zset, errData := m.getZSet(key)
+	if zset == nil {
+		if errData != nil {
+			return errData
+		}
+		return resp.MakeEmptyArrayData()
+	}
+	members := zset.Pop(count, cmdName == "zpopmax")
+	if len(members) > 0 {
+		m.notify(notifyZset, cmdName, key)
+	}
+	if zset.Len() == 0 {
+		m.db.Delete(key)
+		m.DelTTL(key)
+		m.notify(notifyGeneric, "del", key)
+	}
+	return zMembersReply(members, true)
+}
+
+// zRandMemberZSet handles ZRANDMEMBER key [count [WITHSCORES]]
+func zRandMemberZSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zrandmember" {
+		logger.Error("zRandMemberZSet Function: cmdName is not zrandmember")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 2 || len(cmd) > 4 {
+		return resp.MakeErrorData("wrong number of arguments for 'zrandmember' command")
+	}
+	count := 1
+	if len(cmd) >= 3 {
+		var err error
+		count, err = strconv.Atoi(string(cmd[2]))
+		if err != nil {
+			return resp.MakeErrorData("error: value is not an integer or out of range")
+		}
+	}
+	withScores := len(cmd) == 4
+	if withScores && strings.ToLower(string(cmd[3])) != "withscores" {
+		return resp.MakeErrorData("error: syntax error")
+	}
+
+	key := string(cmd[1])
+	m.CheckTTL(key)
+	m.locks.RLock(key)
+	defer m.locks.RUnLock(key)
+
+	zset, errData := m.getZSet(key)
+	if errData != nil {
+		return errData
+	}
+	if len(cmd) == 2 {
+		if zset == nil {
+			return resp.MakeBulkData(nil)
+		}
+		return resp.MakeBulkData([]byte(zset.Random(1)[0].Member))
+	}
+	if zset == nil {
+		return resp.MakeEmptyArrayData()
+	}
+	return zMembersReply(zset.Random(count), withScores)
+}
+
+// zsetSource is an input of the sorted set aggregation commands, a sorted set or a set whose members score 1.
+// Both are nil for a key that doesn't exist.
+type zsetSource struct {
+	zset *ZSet
+	set  *Set
+}
+
+func (src *zsetSource) len() int {
+	switch {
+	case src.zset != nil:
+		return src.zset.Len()
+	case src.set != nil:
+		return src.set.Len()
+	}
+	return 0
+}
+
+func (src *zsetSource) score(member string) (float64, bool) {
+	switch {
+	case src.zset != nil:
+		return src.zset.Score(member)
+	case src.set != nil && src.set.Has(member):
+		return 1, true
+	}
+	return 0, false
+}
+
+func (src *zsetSource) forEach(fn func(member string, score float64) bool) {
+	switch {
+	case src.zset != nil:
+		src.zset.Range(fn)
+	case src.set != nil:
+		src.set.Range(func(member string) bool { return fn(member, 1) })
+	}
+}
+
+// zsetSources returns the sources at keys, the caller must hold the locks of keys
+func (m *MemDb) zsetSources(keys []string) ([]*zsetSource, resp.RedisData) {
+	sources := make([]*zsetSource, len(keys))
+	for i, key := range keys {
+		sources[i] = &zsetSource{}
+		tem, ok := m.db.Get(key)
+		if !ok {
+			continue
+		}
+		switch v := tem.(type) {
+		case *ZSet:
+			sources[i].zset = v
+		case *Set:
+			sources[i].set = v
+		default:
+			return nil, resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+	}
+	return sources, nil
+}
+
+const (
+	aggregateSum = iota
+	aggregateMin
+	aggregateMax
+)
+
+// zsetOpArgs are the arguments of ZUNION, ZINTER and ZDIFF after their destination:
+// numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+type zsetOpArgs struct {
+	keys       []string
+	weights    []float64
+	aggregate  int
+	withScores bool
+}
+
+// parseZSetOpArgs parses args of cmdName. WEIGHTS and AGGREGATE are allowed by union and intersection,
+// WITHSCORES is allowed if the command replies the members.
+func parseZSetOpArgs(cmdName string, args [][]byte, allowWeights, allowWithScores bool) (*zsetOpArgs, resp.RedisData) {
+	if len(args) == 0 {
+		return nil, resp.MakeErrorData("wrong number of arguments for '" + cmdName + "' command")
+	}
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return nil, resp.MakeErrorData("error: value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return nil, resp.MakeErrorData("error: at least 1 input key is needed for '" + cmdName + "' command")
+	}
+	if numKeys > len(args)-1 {
+		return nil, resp.MakeErrorData("error: syntax error")
+	}
+
+	opArgs := &zsetOpArgs{keys: make([]string, numKeys), weights: make([]float64, numKeys)}
+	for i := 0; i < numKeys; i++ {
+		opArgs.keys[i] = string(args[i+1])
+		opArgs.weights[i] = 1
+	}
+	for i := numKeys + 1; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		switch {
+		case option == "weights" && allowWeights && i+numKeys < len(args):
+			for j := 0; j < numKeys; j++ {
+				weight, ok := parseScore(args[i+1+j])
+				if !ok {
+					return nil, resp.MakeErrorData("error: weight value is not a float")
+				}
+				opArgs.weights[j] = weight
+			}
+			i += numKeys
+		case option == "aggregate" && allowWeights && i+1 < len(args):
+			i++
+			switch strings.ToLower(string(args[i])) {
+			case "sum":
+				opArgs.aggregate = aggregateSum
+			case "min":
+				opArgs.aggregate = aggregateMin
+			case "max":
+				opArgs.aggregate = aggregateMax
+			default:
+				return nil, resp.MakeErrorData("error: syntax error")
+			}
+		case option == "withscores" && allowWithScores:
+			opArgs.withScores = true
+		default:
+			return nil, resp.MakeErrorData("error: syntax error")
+		}
+	}
+	return opArgs, nil
+}
+
+// weightScore returns score times weight, inf times 0 is 0
+func weightScore(score, weight float64) float64 {
+	res := score * weight
+	if math.IsNaN(res) {
+		return 0
+	}
+	return res
+}
+
+// aggregateScore aggregates score into acc, the sum of inf and -inf is 0
+func aggregateScore(aggregate int, acc, score float64) float64 {
+	switch aggregate {
+	case aggregateMin:
+		return math.Min(acc, score)
+	case aggregateMax:
+		return math.Max(acc, score)
+	}
+	res := acc + score
+	if math.IsNaN(res) {
+		return 0
+	}
+	return res
+}
+
+func zsetUnion(sources []*zsetSource, opArgs *zsetOpArgs) *ZSet {
+	scores := make(map[string]float64)
+	for i, src := range sources {
+		src.forEach(func(member string, score float64) bool {
+			score = weightScore(score, opArgs.weights[i])
+			if acc, ok := scores[member]; ok {
+				score = aggregateScore(opArgs.aggregate, acc, score)
+			}
+			scores[member] = score
+			return true
+		})
+	}
+	res := NewZSet()
+	for member, score := range scores {
+		res.Add(member, score)
+	}
+	return res
+}
+
+// zsetInter returns the intersection of sources, it stops once the result has limit members if limit is positive
+func zsetInter(sources []*zsetSource, opArgs *zsetOpArgs, limit int) *ZSet {
+	// iterate the smallest source and look the members up in the others
+	smallest := 0
+	for i, src := range sources {
+		if src.len() < sources[smallest].len() {
+			smallest = i
+		}
+	}
+	res := NewZSet()
+	sources[smallest].forEach(func(member string, _ float64) bool {
+		var acc float64
+		for i, src := range sources {
+			score, ok := src.score(member)
+			if !ok {
+				return true
+			}
+			score = weightScore(score, opArgs.weights[i])
+			if i == 0 {
+				acc = score
+			} else {
+				acc = aggregateScore(opArgs.aggregate, acc, score)
+			}
+		}
+		res.Add(member, acc)
+		return limit <= 0 || res.Len() < limit
+	})
+	return res
+}
+
+func zsetDiff(sources []*zsetSource) *ZSet {
+	res := NewZSet()
+	sources[0].forEach(func(member string, score float64) bool {
+		for _, src := range sources[1:] {
+			if _, ok := src.score(member); ok {
+				return true
+			}
+		}
+		res.Add(member, score)
+		return true
+	})
+	return res
+}
+
+// zsetOp computes the union, intersection or difference of the sources at keys by cmdName.
+// The caller must hold the locks of keys.
+func (m *MemDb) zsetOp(cmdName string, opArgs *zsetOpArgs) (*ZSet, resp.RedisData) {
+	sources, errData := m.zsetSources(opArgs.keys)
+	if errData != nil {
+		return nil, errData
+	}
+	switch strings.TrimSuffix(cmdName, "store") {
+	case "zunion":
+		return zsetUnion(sources, opArgs), nil
+	case "zinter":
+		return zsetInter(sources, opArgs, 0), nil
+	}
+	return zsetDiff(sources), nil
+}
+
+// zStore replaces dst by zset, dst is deleted if zset is empty. The caller must hold the lock of dst.
+func (m *MemDb) zStore(dst string, zset *ZSet, event string) resp.RedisData {
+	m.DelTTL(dst)
+	if zset.Len() == 0 {
+		if m.db.Delete(dst) > 0 {
+			m.notify(notifyGeneric, "del", dst)
+		}
+		return resp.MakeIntData(0)
+	}
+	m.db.Set(dst, zset)
+	m.notify(notifyZset, event, dst)
+	return resp.MakeIntData(int64(zset.Len()))
+}
+
+// zSetOpZSet handles ZUNION, ZINTER and ZDIFF numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES].
+// ZDIFF doesn't accept WEIGHTS and AGGREGATE.
+func zSetOpZSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "zunion" && cmdName != "zinter" && cmdName != "zdiff" {
+		logger.Error("zSetOpZSet Function: cmdName is not zunion, zinter or zdiff")
+		return resp.MakeErrorData("server error")
+	}
+	opArgs, errData := parseZSetOpArgs(cmdName, cmd[1:], cmdName != "zdiff", true)
+	if errData != nil {
+		return errData
+	}
+
+	for _, key := range opArgs.keys {
+		m.CheckTTL(key)
+	}
+	m.locks.RLockMulti(opArgs.keys)
+	defer m.locks.RUnLockMulti(opArgs.keys)
+
+	zset, errData := m.zsetOp(cmdName, opArgs)
+	if errData != nil {
+		return errData
+	}
+	return zMembersReply(zset.RangeByRank(0, -1, false), opArgs.withScores)
+}
+
+// zSetOpStoreZSet handles ZUNIONSTORE, ZINTERSTORE and ZDIFFSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX].
+// The sources and the destination are locked together, so the result is computed and stored atomically.
+func zSetOpStoreZSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "zunionstore" && cmdName != "zinterstore" && cmdName != "zdiffstore" {
+		logger.Error("zSetOpStoreZSet Function: cmdName is not zunionstore, zinterstore or zdiffstore")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 4 {
+		return resp.MakeErrorData("wrong number of arguments for '" + cmdName + "' command")
+	}
+	opArgs, errData := parseZSetOpArgs(cmdName, cmd[2:], cmdName != "zdiffstore", false)
+	if errData != nil {
+		return errData
+	}
+
+	dst := string(cmd[1])
+	keys := append([]string{dst}, opArgs.keys...)
+	for _, key := range keys {
+		m.CheckTTL(key)
+	}
+	m.locks.LockMulti(keys)
+	defer m.locks.UnLockMulti(keys)
+
+	zset, errData := m.zsetOp(cmdName, opArgs)
+	if errData != nil {
+		return errData
+	}
+	return m.zStore(dst, zset, cmdName)
+}
+
+// zInterCardZSet handles ZINTERCARD numkeys key [key ...] [LIMIT limit]
+func zInterCardZSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zintercard" {
+		logger.Error("zInterCardZSet Function: cmdName is not zintercard")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 3 {
+		return resp.MakeErrorData("wrong number of arguments for 'zintercard' command")
+	}
+	// LIMIT follows the keys, the other arguments are parsed like ZINTER
+	args, limit := cmd[1:], 0
+	if numKeys, err := strconv.Atoi(string(args[0])); err == nil && numKeys > 0 && len(args) == numKeys+3 &&
+		strings.ToLower(string(args[numKeys+1])) == "limit" {
+		limit, err = strconv.Atoi(string(args[numKeys+2]))
+		if err != nil {
+			return resp.MakeErrorData("error: value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return resp.MakeErrorData("error: LIMIT can't be negative")
+		}
+		args = args[:numKeys+1]
+	}
+	opArgs, errData := parseZSetOpArgs("zintercard", args, false, false)
+	if errData != nil {
+		return errData
+	}
+
+	for _, key := range opArgs.keys {
+		m.CheckTTL(key)
+	}
+	m.locks.RLockMulti(opArgs.keys)
+	defer m.locks.RUnLockMulti(opArgs.keys)
+
+	sources, errData := m.zsetSources(opArgs.keys)
+	if errData != nil {
+		return errData
+	}
+	return resp.MakeIntData(int64(zsetInter(sources, opArgs, limit).Len()))
+}
+
+// zRangeStoreZSet handles ZRANGESTORE destination source start stop [BYSCORE] [REV] [LIMIT offset count]
+func zRangeStoreZSet(m *MemDb, cmd [][]byte) resp.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zrangestore" {
+		logger.Error("zRangeStoreZSet Function: cmdName is not zrangestore")
+		return resp.MakeErrorData("server error")
+	}
+	if len(cmd) < 5 {
+		return resp.MakeErrorData("wrong number of arguments for 'zrangestore' command")
+	}
+	opts, errData := parseZRangeOptions(cmd[5:])
+	if errData != nil {
+		return errData
+	}
+	if opts.withScores {
+		return resp.MakeErrorData("error: syntax error")
+	}
+
+	dst, src := string(cmd[1]), string(cmd[2])
+	keys := []string{dst, src}
+	m.CheckTTL(dst)
+	m.CheckTTL(src)
+	m.locks.LockMulti(keys)
+	defer m.locks.UnLockMulti(keys)
+
+	zset, errData := m.getZSet(src)
+	if errData != nil {
+		return errData
+	}
+	if zset == nil {
+		zset = NewZSet()
+	}
+	members, errData := zRange(zset, cmd[3], cmd[4], opts)
+	if errData != nil {
+		return errData
+	}
+	res := NewZSet()
+	for _, member := range members {
+		res.Add(member.Member, member.Score)
+	}
+	return m.zStore(dst, res, "zrangestore")
+}
+
+func RegisterZSetCommands() {
+	RegisterWriteCommand("zadd", zAddZSet, 1, 1, 1)
+	RegisterCommand("zcard", zCardZSet)
+	RegisterCommand("zcount", zCountZSet)
+	RegisterCommand("zdiff", zSetOpZSet)
+	RegisterWriteCommand("zdiffstore", zSetOpStoreZSet, 1, 1, 1)
+	RegisterWriteCommand("zincrby", zIncrByZSet, 1, 1, 1)
+	RegisterCommand("zinter", zSetOpZSet)
+	RegisterCommand("zintercard", zInterCardZSet)
+	RegisterWriteCommand("zinterstore", zSetOpStoreZSet, 1, 1, 1)
+	RegisterCommand("zmscore", zMScoreZSet)
+	RegisterWriteCommand("zpopmax", zPopZSet, 1, 1, 1)
+	RegisterWriteCommand("zpopmin", zPopZSet, 1, 1, 1)
+	RegisterCommand("zrandmember", zRandMemberZSet)
+	RegisterCommand("zrange", zRangeZSet)
+	RegisterWriteCommand("zrangestore", zRangeStoreZSet, 1, 1, 1)
+	RegisterCommand("zrank", zRankZSet)
+	RegisterWriteCommand("zrem", zRemZSet, 1, 1, 1)
+	RegisterCommand("zrevrank", zRankZSet)
+	RegisterCommand("zscore", zScoreZSet)
+	RegisterCommand("zunion", zSetOpZSet)
+	RegisterWriteCommand("zunionstore", zSetOpStoreZSet, 1, 1, 1)
+}
@@ -0,0 +1,429 @@
+package memdb
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+)
+
+const (
+	zsetMaxLevel    = 32
+	zsetLevelFactor = 0.25 // probability of a node to have one more level
+)
+
+// ZMember is a member of a sorted set and its score
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+type zsetLevel struct {
+	forward *zsetNode
+	span    int // number of nodes between this node and forward, used to compute ranks
+}
+
+type zsetNode struct {
+	ZMember
+	backward *zsetNode
+	levels   []zsetLevel
+}
+
+// ZSet is a sorted set. The members are ordered by score, then by member, in a skiplist,
+// and a map from member to score makes the lookup of a member O(1).
+type ZSet struct {
+	header *zsetNode
+	tail   *zsetNode
+	level  int
+	dict   map[string]float64
+}
+
+func NewZSet() *ZSet {
+	return &ZSet{
+		header: &zsetNode{levels: make([]zsetLevel, zsetMaxLevel)},
+		level:  1,
+		dict:   make(map[string]float64),
+	}
+}
+
+func randomZsetLevel() int {
+	level := 1
+	for level < zsetMaxLevel && rand.Float64() < zsetLevelFactor {
+		level++
+	}
+	return level
+}
+
+// before reports whether n is ordered before (score, member)
+func (n *zsetNode) before(score float64, member string) bool {
+	return n.Score < score || (n.Score == score && n.Member < member)
+}
+
+func (z *ZSet) Len() int {
+	return len(z.dict)
+}
+
+func (z *ZSet) Score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// insert adds a member which is not in the skiplist
+func (z *ZSet) insert(member string, score float64) {
+	var update [zsetMaxLevel]*zsetNode
+	var rank [zsetMaxLevel]int
+	node := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		if i < z.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for node.levels[i].forward != nil && node.levels[i].forward.before(score, member) {
+			rank[i] += node.levels[i].span
+			node = node.levels[i].forward
+		}
+		update[i] = node
+	}
+
+	level := randomZsetLevel()
+	if level > z.level {
+		for i := z.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = z.header
+			update[i].levels[i].span = len(z.dict)
+		}
+		z.level = level
+	}
+	node = &zsetNode{ZMember: ZMember{Member: member, Score: score}, levels: make([]zsetLevel, level)}
+	for i := 0; i < level; i++ {
+		node.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = node
+		node.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < z.level; i++ {
+		update[i].levels[i].span++
+	}
+	if update[0] != z.header {
+		node.backward = update[0]
+	}
+	if node.levels[0].forward != nil {
+		node.levels[0].forward.backward = node
+	} else {
+		z.tail = node
+	}
+}
+
+// delete removes a member with score from the skiplist
+func (z *ZSet) delete(member string, score float64) {
+	var update [zsetMaxLevel]*zsetNode
+	node := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for node.levels[i].forward != nil && node.levels[i].forward.before(score, member) {
+			node = node.levels[i].forward
+		}
+		update[i] = node
+	}
+	node = node.levels[0].forward
+	if node == nil || node.Member != member {
+		return
+	}
+	for i := 0; i < z.level; i++ {
+		if update[i].levels[i].forward == node {
+			update[i].levels[i].span += node.levels[i].span - 1
+			update[i].levels[i].forward = node.levels[i].forward
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+	if node.levels[0].forward != nil {
+		node.levels[0].forward.backward = node.backward
+	} else {
+		z.tail = node.backward
+	}
+	for z.level > 1 && z.header.levels[z.level-1].forward == nil {
+		z.level--
+	}
+}
+
+// Add sets the score of member, it returns true if member is new
+func (z *ZSet) Add(member string, score float64) bool {
+	old, ok := z.dict[member]
+	if ok {
+		if old == score {
+			return false
+		}
+		delete(z.dict, member)
+		z.delete(member, old)
+	}
+	// insert reads the length of the skiplist from dict, so member is added to dict after it
+	z.insert(member, score)
+	z.dict[member] = score
+	return !ok
+}
+
+func (z *ZSet) Remove(member string) int {
+	score, ok := z.dict[member]
+	if !ok {
+		return 0
+	}
+	delete(z.dict, member)
+	z.delete(member, score)
+	return 1
+}
+
+// Rank returns the 0 based rank of member ordered from the lowest score, or from the highest score if reverse is true
+func (z *ZSet) Rank(member string, reverse bool) (int, bool) {
+	score, ok := z.dict[member]
+	if !ok {
+		return 0, false
+	}
+	rank := 0
+	node := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for node.levels[i].forward != nil && (node.levels[i].forward.before(score, member) || node.levels[i].forward.Member == member) {
+			rank += node.levels[i].span
+			node = node.levels[i].forward
+		}
+	}
+	// rank is 1 based here, node is the node of member
+	if reverse {
+		return z.Len() - rank, true
+	}
+	return rank - 1, true
+}
+
+// byRank returns the node at the 0 based rank, or nil if rank is out of range
+func (z *ZSet) byRank(rank int) *zsetNode {
+	if rank < 0 || rank >= z.Len() {
+		return nil
+	}
+	traversed := 0
+	node := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for node.levels[i].forward != nil && traversed+node.levels[i].span <= rank+1 {
+			traversed += node.levels[i].span
+			node = node.levels[i].forward
+		}
+		if traversed == rank+1 {
+			return node
+		}
+	}
+	return nil
+}
+
+// RangeByRank returns the members between the 0 based ranks start and stop inclusive.
+// Negative ranks count from the end. If reverse is true, ranks are counted from the highest score.
+func (z *ZSet) RangeByRank(start, stop int, reverse bool) []ZMember {
+	n := z.Len()
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return []ZMember{}
+	}
+	res := make([]ZMember, 0, stop-start+1)
+	if reverse {
+		for node := z.byRank(n - 1 - start); len(res) < stop-start+1; node = node.backward {
+			res = append(res, node.ZMember)
+		}
+		return res
+	}
+	for node := z.byRank(start); len(res) < stop-start+1; node = node.levels[0].forward {
+		res = append(res, node.ZMember)
+	}
+	return res
+}
+
+// scoreRange is a range of scores, a bound is excluded if its ex flag is set
+type scoreRange struct {
+	min, max     float64
+	minEx, maxEx bool
+}
+
+func (r *scoreRange) aboveMin(score float64) bool {
+	if r.minEx {
+		return score > r.min
+	}
+	return score >= r.min
+}
+
+func (r *scoreRange) belowMax(score float64) bool {
+	if r.maxEx {
+		return score < r.max
+	}
+	return score <= r.max
+}
+
+// empty reports whether no score can be in r
+func (r *scoreRange) empty() bool {
+	return r.min > r.max || (r.min == r.max && (r.minEx || r.maxEx))
+}
+
+// firstInRange returns the node of the lowest score in r, or nil
+func (z *ZSet) firstInRange(r *scoreRange) *zsetNode {
+	if r.empty() {
+		return nil
+	}
+	node := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for node.levels[i].forward != nil && !r.aboveMin(node.levels[i].forward.Score) {
+			node = node.levels[i].forward
+		}
+	}
+	node = node.levels[0].forward
+	if node == nil || !r.belowMax(node.Score) {
+		return nil
+	}
+	return node
+}
+
+// lastInRange returns the node of the highest score in r, or nil
+func (z *ZSet) lastInRange(r *scoreRange) *zsetNode {
+	if r.empty() {
+		return nil
+	}
+	node := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for node.levels[i].forward != nil && r.belowMax(node.levels[i].forward.Score) {
+			node = node.levels[i].forward
+		}
+	}
+	if node == z.header || !r.aboveMin(node.Score) {
+		return nil
+	}
+	return node
+}
+
+// RangeByScore returns the members with scores in r, in ascending order or in descending order if reverse is true.
+// offset members are skipped and at most count members are returned, count < 0 means no limit.
+func (z *ZSet) RangeByScore(r *scoreRange, reverse bool, offset, count int) []ZMember {
+	res := make([]ZMember, 0)
+	var node *zsetNode
+	if reverse {
+		node = z.lastInRange(r)
+	} else {
+		node = z.firstInRange(r)
+	}
+	for ; node != nil && offset > 0; offset-- {
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.levels[0].forward
+		}
+	}
+	for node != nil && count != 0 {
+		if reverse && !r.aboveMin(node.Score) || !reverse && !r.belowMax(node.Score) {
+			break
+		}
+		res = append(res, node.ZMember)
+		count--
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.levels[0].forward
+		}
+	}
+	return res
+}
+
+// Count returns the number of members with scores in r
+func (z *ZSet) Count(r *scoreRange) int {
+	first := z.firstInRange(r)
+	if first == nil {
+		return 0
+	}
+	last := z.lastInRange(r)
+	firstRank, _ := z.Rank(first.Member, false)
+	lastRank, _ := z.Rank(last.Member, false)
+	return lastRank - firstRank + 1
+}
+
+// Pop removes and returns at most count members with the lowest scores, or the highest scores if max is true
+func (z *ZSet) Pop(count int, max bool) []ZMember {
+	res := make([]ZMember, 0)
+	for len(res) < count && z.Len() > 0 {
+		node := z.header.levels[0].forward
+		if max {
+			node = z.tail
+		}
+		res = append(res, node.ZMember)
+		z.Remove(node.Member)
+	}
+	return res
+}
+
+// Random returns random members of the sorted set.
+// if count > 0, return min(len(zset), count) distinct members
+// if count < 0, return exactly -count members which may repeat
+func (z *ZSet) Random(count int) []ZMember {
+	n := z.Len()
+	res := make([]ZMember, 0)
+	if count == 0 || n == 0 {
+		return res
+	}
+	if count < 0 {
+		for len(res) < -count {
+			res = append(res, z.byRank(rand.Intn(n)).ZMember)
+		}
+		return res
+	}
+	if count >= n {
+		return z.RangeByRank(0, -1, false)
+	}
+	picked := make(map[int]struct{}, count)
+	for len(res) < count {
+		rank := rand.Intn(n)
+		if _, ok := picked[rank]; ok {
+			continue
+		}
+		picked[rank] = struct{}{}
+		res = append(res, z.byRank(rank).ZMember)
+	}
+	return res
+}
+
+// Range calls fn for every member in ascending order until fn returns false
+func (z *ZSet) Range(fn func(member string, score float64) bool) {
+	for node := z.header.levels[0].forward; node != nil; node = node.levels[0].forward {
+		if !fn(node.Member, node.Score) {
+			return
+		}
+	}
+}
+
+func (z *ZSet) Clear() {
+	*z = *NewZSet()
+}
+
+// Encoding returns skiplist, the only encoding of a sorted set
+func (z *ZSet) Encoding() string {
+	return "skiplist"
+}
+
+func (z *ZSet) Copy() *ZSet {
+	res := NewZSet()
+	z.Range(func(member string, score float64) bool {
+		res.Add(member, score)
+		return true
+	})
+	return res
+}
+
+// formatScore formats a score the way it is replied, infinities are inf and -inf
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
@@ -0,0 +1,268 @@
+package memdb
+
+import (
+	"bytes"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestZSetStruct(t *testing.T) {
+	zset := NewZSet()
+	expect := make(map[string]float64)
+	for i := 0; i < 2000; i++ {
+		member := strconv.Itoa(rand.Intn(300))
+		if rand.Intn(3) == 0 {
+			if zset.Remove(member) != 0 {
+				delete(expect, member)
+			}
+			continue
+		}
+		score := float64(rand.Intn(50))
+		if _, ok := expect[member]; zset.Add(member, score) == ok {
+			t.Fatalf("add of %s reports a wrong new member", member)
+		}
+		expect[member] = score
+	}
+
+	sorted := make([]ZMember, 0, len(expect))
+	for member, score := range expect {
+		sorted = append(sorted, ZMember{Member: member, Score: score})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Score < sorted[j].Score || sorted[i].Score == sorted[j].Score && sorted[i].Member < sorted[j].Member
+	})
+	if zset.Len() != len(sorted) {
+		t.Fatalf("zset has %d members, expect %d", zset.Len(), len(sorted))
+	}
+	members := zset.RangeByRank(0, -1, false)
+	for i, member := range sorted {
+		if members[i] != member {
+			t.Fatalf("member at rank %d is %v, expect %v", i, members[i], member)
+		}
+		if rank, ok := zset.Rank(member.Member, false); !ok || rank != i {
+			t.Fatalf("rank of %s is %d, expect %d", member.Member, rank, i)
+		}
+		if rank, _ := zset.Rank(member.Member, true); rank != len(sorted)-1-i {
+			t.Fatalf("reverse rank of %s is %d, expect %d", member.Member, rank, len(sorted)-1-i)
+		}
+	}
+	if reversed := zset.RangeByRank(0, 0, true); len(reversed) != 1 || reversed[0] != sorted[len(sorted)-1] {
+		t.Errorf("reverse range starts with %v", reversed)
+	}
+
+	r := &scoreRange{min: 10, max: 20, maxEx: true}
+	count := 0
+	for _, member := range sorted {
+		if member.Score >= 10 && member.Score < 20 {
+			count++
+		}
+	}
+	if zset.Count(r) != count || len(zset.RangeByScore(r, false, 0, -1)) != count || len(zset.RangeByScore(r, true, 0, -1)) != count {
+		t.Errorf("count of [10, 20) is %d, expect %d", zset.Count(r), count)
+	}
+	if distinct := zset.Random(10); len(distinct) != 10 {
+		t.Errorf("random returns %d distinct members, expect 10", len(distinct))
+	}
+	if popped := zset.Pop(2, true); len(popped) != 2 || popped[0] != sorted[len(sorted)-1] || popped[1] != sorted[len(sorted)-2] {
+		t.Errorf("pop max returns %v", popped)
+	}
+}
+
+func TestZAdd(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "zadd z 1 a 2 b 3 c")
+	cases := []struct {
+		cmd, expect string
+	}{
+		{"zadd z nx 10 a 4 d", ":1\r\n"},
+		{"zadd z xx ch 5 a 5 e", ":1\r\n"},
+		{"zadd z gt ch 4 a 6 b", ":1\r\n"},
+		{"zadd z lt 7 c", ":0\r\n"},
+		{"zadd z incr 2.5 c", "$3\r\n5.5\r\n"},
+		{"zadd z nx incr 1 c", "$-1\r\n"},
+		{"zincrby z -inf d", "$4\r\n-inf\r\n"},
+		{"zadd z nx xx 1 a", "-error: XX and NX options at the same time are not compatible\r\n"},
+		{"zadd z gt lt 1 a", "-error: GT, LT, and/or NX options at the same time are not compatible\r\n"},
+		{"zadd z incr 1 a 2 b", "-error: INCR option supports a single increment-element pair\r\n"},
+		{"zadd z nan a", "-error: value is not a valid float\r\n"},
+		{"zadd z 1", "-wrong number of arguments for 'zadd' command\r\n"},
+		{"zadd z 1 a 2", "-error: syntax error\r\n"},
+		{"zadd none xx 1 a", ":0\r\n"},
+		{"zscore z b", "$1\r\n6\r\n"},
+		{"zmscore z a x", "*2\r\n$1\r\n5\r\n$-1\r\n"},
+		{"zcard z", ":4\r\n"},
+		{"zcount z (5 +inf", ":2\r\n"},
+		{"zcount z -inf (5", ":1\r\n"},
+		{"zrank z c", ":2\r\n"},
+		{"zrevrank z c withscore", "*2\r\n:1\r\n$3\r\n5.5\r\n"},
+		{"zrank z x", "$-1\r\n"},
+		{"zrem z a x", ":1\r\n"},
+		{"type z", "+zset\r\n"},
+		{"exists none", ":0\r\n"},
+	}
+	for _, c := range cases {
+		if res := memdb.ExecCommand(bytes.Fields([]byte(c.cmd))); !bytes.Equal(res.ToBytes(), []byte(c.expect)) {
+			t.Errorf("%s replies %q, expect %q", c.cmd, res.ToBytes(), c.expect)
+		}
+	}
+
+	execCommands(memdb, "zrem z b c d")
+	if memdb.db.Len() != 0 {
+		t.Error("empty sorted set is not deleted")
+	}
+	execCommands(memdb, "set str v")
+	if res := memdb.ExecCommand(bytes.Fields([]byte("zadd str 1 a"))); !bytes.HasPrefix(res.ToBytes(), []byte("-WRONGTYPE")) {
+		t.Errorf("zadd to a string replies %q", res.ToBytes())
+	}
+}
+
+func TestZRange(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "zadd z 1 a 2 b 2 c 3 d 4 e")
+	// checked in order, the pops change the set
+	cases := []struct {
+		cmd, expect string
+	}{
+		{"zrange z 0 -1", "a b c d e"},
+		{"zrange z 1 2 withscores", "b 2 c 2"},
+		{"zrange z 0 1 rev", "e d"},
+		{"zrange z -2 10", "d e"},
+		{"zrange z 3 1", ""},
+		{"zrange z 2 (4 byscore", "b c d"},
+		{"zrange z (4 2 byscore rev", "d c b"},
+		{"zrange z -inf +inf byscore limit 1 2", "b c"},
+		{"zrange z +inf -inf byscore rev limit 0 1", "e"},
+		{"zrange z -inf +inf byscore limit 2 -1", "c d e"},
+		{"zrange z (2 (3 byscore", ""},
+		{"zrange none 0 -1", ""},
+		{"zpopmin z 2", "a 1 b 2"},
+		{"zpopmax z", "e 4"},
+		{"zrandmember z 10 withscores", "c 2 d 3"},
+		{"sort z by nosort", "c d"},
+	}
+	for _, c := range cases {
+		if got := strings.Join(sortReply(t, memdb, c.cmd), " "); got != c.expect {
+			t.Errorf("%s replies %q, expect %q", c.cmd, got, c.expect)
+		}
+	}
+	if got := sortReply(t, memdb, "zrandmember z -5"); len(got) != 5 {
+		t.Errorf("zrandmember with a negative count returns %d members, expect 5", len(got))
+	}
+	if res := memdb.ExecCommand(bytes.Fields([]byte("zrange z 0 -1 limit 0 1"))); !bytes.HasPrefix(res.ToBytes(), []byte("-error: syntax error")) {
+		t.Errorf("zrange by rank with limit replies %q", res.ToBytes())
+	}
+	if res := memdb.ExecCommand(bytes.Fields([]byte("zrange z a b byscore"))); !bytes.Equal(res.ToBytes(), []byte("-error: min or max is not a float\r\n")) {
+		t.Errorf("zrange with a bad score replies %q", res.ToBytes())
+	}
+}
+
+func TestZSetPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := NewAof(path, FsyncNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMemDb()
+	m.dbs.SetAof(aof)
+	for i := 0; i < 300; i++ {
+		execCommands(m, "zadd z "+strconv.Itoa(i%7)+".25 m"+strconv.Itoa(i))
+	}
+	execCommands(m, "zincrby z -inf m1", "zpopmax z 3", "zrem z m5", "zadd t 1 a", "copy z c", "zunionstore u 2 z t weights 2 1")
+	if err = aof.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	execCommands(m, "zpopmin z")
+	if err = aof.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewMemDb()
+	if err = LoadAof(path, loaded.dbs); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"z", "t", "c", "u"} {
+		expect := m.ExecCommand(bytes.Fields([]byte("zrange " + key + " 0 -1 withscores"))).ToBytes()
+		if got := loaded.ExecCommand(bytes.Fields([]byte("zrange " + key + " 0 -1 withscores"))).ToBytes(); !bytes.Equal(got, expect) {
+			t.Errorf("sorted set %s is different after replay", key)
+		}
+		dump := m.ExecCommand(bytes.Fields([]byte("dump " + key))).ByteData()
+		val, _ := m.db.Get(key)
+		restored, err := restoreValue(dump)
+		if err != nil || restored.(*ZSet).Len() != val.(*ZSet).Len() {
+			t.Errorf("dump of %s is not restored: %v", key, err)
+		}
+	}
+}
+
+func TestZSetOp(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "zadd a 1 x 2 y 3 z", "zadd b 10 y 20 z 30 w", "sadd s z w", "set str v")
+	cases := []struct {
+		cmd, expect string
+	}{
+		{"zunion 2 a b withscores", "x 1 y 12 z 23 w 30"},
+		{"zunion 2 a b weights 2 -1 aggregate max withscores", "w -30 x 2 y 4 z 6"},
+		{"zinter 3 a b s withscores", "z 24"},
+		{"zinter 2 a b aggregate min withscores", "y 2 z 3"},
+		{"zinter 2 a none", ""},
+		{"zdiff 2 a s withscores", "x 1 y 2"},
+		{"zdiff 1 none", ""},
+		{"zunion 2 s none withscores", "w 1 z 1"},
+	}
+	for _, c := range cases {
+		if got := strings.Join(sortReply(t, memdb, c.cmd), " "); got != c.expect {
+			t.Errorf("%s replies %q, expect %q", c.cmd, got, c.expect)
+		}
+	}
+
+	replies := []struct {
+		cmd, expect string
+	}{
+		{"zintercard 2 a b", ":2\r\n"},
+		{"zintercard 2 a b limit 1", ":1\r\n"},
+		{"zintercard 2 a b limit -1", "-error: LIMIT can't be negative\r\n"},
+		{"zunionstore str 2 a b", ":4\r\n"},
+		{"type str", "+zset\r\n"},
+		{"zinterstore a 2 a s", ":1\r\n"},
+		{"zscore a z", "$1\r\n4\r\n"},
+		{"zdiffstore a 2 a s", ":0\r\n"},
+		{"exists a", ":0\r\n"},
+		{"zrangestore r b (30 0 byscore rev limit 0 1", ":1\r\n"},
+		{"zrange r 0 -1", "*1\r\n$1\r\nz\r\n"},
+		{"zrangestore r b 0 -1 withscores", "-error: syntax error\r\n"},
+		{"zrangestore r s 0 -1", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"zunion 0 a", "-error: at least 1 input key is needed for 'zunion' command\r\n"},
+		{"zunion 3 a b", "-error: syntax error\r\n"},
+		{"zdiff 2 a b weights 1 2", "-error: syntax error\r\n"},
+		{"zinter 2 a b weights 1 x", "-error: weight value is not a float\r\n"},
+		{"zinter 2 b r aggregate avg", "-error: syntax error\r\n"},
+	}
+	for _, c := range replies {
+		if res := memdb.ExecCommand(bytes.Fields([]byte(c.cmd))); !bytes.Equal(res.ToBytes(), []byte(c.expect)) {
+			t.Errorf("%s replies %q, expect %q", c.cmd, res.ToBytes(), c.expect)
+		}
+	}
+}
+
+func TestZSetOpStoreConcurrent(t *testing.T) {
+	memdb := NewMemDb()
+	execCommands(memdb, "zadd a 1 x", "zadd b 1 x")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			execCommands(memdb, "zunionstore d 2 a b", "zinterstore a 2 d b")
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		execCommands(memdb, "zincrby b 1 x", "zadd a 1 y")
+	}
+	<-done
+	if res := memdb.ExecCommand(bytes.Fields([]byte("zscore b x"))).ByteData(); string(res) != "201" {
+		t.Errorf("score of x in b is %s, expect 201", res)
+	}
+}
@@ -0,0 +1,241 @@
+package pubsub
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/VincentFF/thinredis/resp"
+	"github.com/VincentFF/thinredis/util"
+)
+
+// pubsub package implements the publish/subscribe messaging of redis.
+// Hub records which subscribers listen to which channels and patterns, and delivers published messages to them.
+
+// Subscriber receives the messages published to the channels and patterns it subscribes.
+// Push is called while the Hub is locked, so it must not block.
+type Subscriber interface {
+	Push(data []byte)
+}
+
+// subscription holds the channels and patterns of a subscriber
+type subscription struct {
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+func (s *subscription) count() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+type Hub struct {
+	mu          sync.RWMutex
+	channels    map[string]map[Subscriber]struct{}
+	patterns    map[string]map[Subscriber]struct{}
+	subscribers map[Subscriber]*subscription
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		channels:    make(map[string]map[Subscriber]struct{}),
+		patterns:    make(map[string]map[Subscriber]struct{}),
+		subscribers: make(map[Subscriber]*subscription),
+	}
+}
+
+func reply(kind string, name []byte, count int) resp.RedisData {
+	return resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte(kind)),
+		resp.MakeBulkData(name),
+		resp.MakeIntData(int64(count)),
+	})
+}
+
+func (h *Hub) subscriptionOf(s Subscriber) *subscription {
+	sub, ok := h.subscribers[s]
+	if !ok {
+		sub = &subscription{channels: make(map[string]struct{}), patterns: make(map[string]struct{})}
+		h.subscribers[s] = sub
+	}
+	return sub
+}
+
+// add subscribes s to names, which are channels or patterns according to isPattern, and returns a reply for each name
+func (h *Hub) add(s Subscriber, names []string, isPattern bool) []resp.RedisData {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	kind, table := "subscribe", h.channels
+	if isPattern {
+		kind, table = "psubscribe", h.patterns
+	}
+	sub := h.subscriptionOf(s)
+	owned := sub.channels
+	if isPattern {
+		owned = sub.patterns
+	}
+	res := make([]resp.RedisData, 0, len(names))
+	for _, name := range names {
+		if _, ok := table[name]; !ok {
+			table[name] = make(map[Subscriber]struct{})
+		}
+		table[name][s] = struct{}{}
+		owned[name] = struct{}{}
+		res = append(res, reply(kind, []byte(name), sub.count()))
+	}
+	return res
+}
+
+// remove unsubscribes s from names, or from all its channels or patterns if names is empty,
+// and returns a reply for each name
+func (h *Hub) remove(s Subscriber, names []string, isPattern bool) []resp.RedisData {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	kind, table := "unsubscribe", h.channels
+	if isPattern {
+		kind, table = "punsubscribe", h.patterns
+	}
+	sub, ok := h.subscribers[s]
+	if !ok {
+		if len(names) == 0 {
+			return []resp.RedisData{reply(kind, nil, 0)}
+		}
+		res := make([]resp.RedisData, 0, len(names))
+		for _, name := range names {
+			res = append(res, reply(kind, []byte(name), 0))
+		}
+		return res
+	}
+	owned := sub.channels
+	if isPattern {
+		owned = sub.patterns
+	}
+	if len(names) == 0 {
+		for name := range owned {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return []resp.RedisData{reply(kind, nil, sub.count())}
+		}
+	}
+
+	res := make([]resp.RedisData, 0, len(names))
+	for _, name := range names {
+		if _, ok := owned[name]; ok {
+			delete(owned, name)
+			delete(table[name], s)
+			if len(table[name]) == 0 {
+				delete(table, name)
+			}
+		}
+		res = append(res, reply(kind, []byte(name), sub.count()))
+	}
+	if sub.count() == 0 {
+		delete(h.subscribers, s)
+	}
+	return res
+}
+
+// Subscribe subscribes s to channels and returns the reply for each channel
+func (h *Hub) Subscribe(s Subscriber, channels []string) []resp.RedisData {
+	return h.add(s, channels, false)
+}
+
+// Unsubscribe unsubscribes s from channels, or from all its channels if channels is empty
+func (h *Hub) Unsubscribe(s Subscriber, channels []string) []resp.RedisData {
+	return h.remove(s, channels, false)
+}
+
+// PSubscribe subscribes s to glob-style patterns and returns the reply for each pattern
+func (h *Hub) PSubscribe(s Subscriber, patterns []string) []resp.RedisData {
+	return h.add(s, patterns, true)
+}
+
+// PUnsubscribe unsubscribes s from patterns, or from all its patterns if patterns is empty
+func (h *Hub) PUnsubscribe(s Subscriber, patterns []string) []resp.RedisData {
+	return h.remove(s, patterns, true)
+}
+
+// UnsubscribeAll removes all subscriptions of s, it is called when s is disconnected
+func (h *Hub) UnsubscribeAll(s Subscriber) {
+	h.remove(s, nil, false)
+	h.remove(s, nil, true)
+}
+
+// Count returns the number of channels and patterns s subscribes
+func (h *Hub) Count(s Subscriber) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if sub, ok := h.subscribers[s]; ok {
+		return sub.count()
+	}
+	return 0
+}
+
+// Publish sends message to the subscribers of channel and the subscribers of patterns matching channel.
+// It returns the number of subscribers which receive the message.
+func (h *Hub) Publish(channel string, message []byte) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.subscribers) == 0 {
+		return 0
+	}
+
+	received := 0
+	if subs, ok := h.channels[channel]; ok {
+		data := resp.MakeArrayData([]resp.RedisData{
+			resp.MakeBulkData([]byte("message")),
+			resp.MakeBulkData([]byte(channel)),
+			resp.MakeBulkData(message),
+		}).ToBytes()
+		for s := range subs {
+			s.Push(data)
+			received++
+		}
+	}
+	for pattern, subs := range h.patterns {
+		if !util.PattenMatch(pattern, channel) {
+			continue
+		}
+		data := resp.MakeArrayData([]resp.RedisData{
+			resp.MakeBulkData([]byte("pmessage")),
+			resp.MakeBulkData([]byte(pattern)),
+			resp.MakeBulkData([]byte(channel)),
+			resp.MakeBulkData(message),
+		}).ToBytes()
+		for s := range subs {
+			s.Push(data)
+			received++
+		}
+	}
+	return received
+}
+
+// Channels returns the active channels matching pattern, or all active channels if pattern is empty
+func (h *Hub) Channels(pattern string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	res := make([]string, 0)
+	for channel := range h.channels {
+		if pattern == "" || util.PattenMatch(pattern, channel) {
+			res = append(res, channel)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// NumSub returns the number of subscribers of channel, patterns are not counted
+func (h *Hub) NumSub(channel string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.channels[channel])
+}
+
+// NumPat returns the number of patterns subscribed by all subscribers
+func (h *Hub) NumPat() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.patterns)
+}
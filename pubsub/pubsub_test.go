@@ -0,0 +1,63 @@
+package pubsub
+
+import (
+	"bytes"
+	"testing"
+)
+
+type recorder struct {
+	messages [][]byte
+}
+
+func (r *recorder) Push(data []byte) {
+	r.messages = append(r.messages, data)
+}
+
+func TestSubscribe(t *testing.T) {
+	h := NewHub()
+	a, b := &recorder{}, &recorder{}
+
+	res := h.Subscribe(a, []string{"news", "sport"})
+	if len(res) != 2 || !bytes.Equal(res[1].ToBytes(), []byte("*3\r\n$9\r\nsubscribe\r\n$5\r\nsport\r\n:2\r\n")) {
+		t.Error("subscribe reply error")
+	}
+	h.PSubscribe(b, []string{"n*"})
+	if h.Count(a) != 2 || h.Count(b) != 1 {
+		t.Error("subscription count error")
+	}
+
+	if n := h.Publish("news", []byte("hi")); n != 2 {
+		t.Errorf("publish news reaches %d subscribers, expect 2", n)
+	}
+	if n := h.Publish("weather", []byte("rain")); n != 0 {
+		t.Errorf("publish weather reaches %d subscribers, expect 0", n)
+	}
+	if len(a.messages) != 1 || !bytes.Equal(a.messages[0], []byte("*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$2\r\nhi\r\n")) {
+		t.Error("message to channel subscriber error")
+	}
+	if len(b.messages) != 1 || !bytes.Equal(b.messages[0], []byte("*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$2\r\nhi\r\n")) {
+		t.Error("message to pattern subscriber error")
+	}
+
+	channels := h.Channels("")
+	if len(channels) != 2 || channels[0] != "news" || channels[1] != "sport" {
+		t.Error("channels error")
+	}
+	if h.NumSub("news") != 1 || h.NumSub("weather") != 0 || h.NumPat() != 1 {
+		t.Error("numsub or numpat error")
+	}
+
+	res = h.Unsubscribe(a, nil)
+	if len(res) != 2 || !bytes.Equal(res[1].ToBytes(), []byte("*3\r\n$11\r\nunsubscribe\r\n$5\r\nsport\r\n:0\r\n")) {
+		t.Error("unsubscribe all reply error")
+	}
+	res = h.Unsubscribe(a, nil)
+	if len(res) != 1 || !bytes.Equal(res[0].ToBytes(), []byte("*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n")) {
+		t.Error("unsubscribe without subscription reply error")
+	}
+
+	h.UnsubscribeAll(b)
+	if h.Count(b) != 0 || h.NumPat() != 0 || h.Publish("news", []byte("bye")) != 0 {
+		t.Error("unsubscribe all subscriptions error")
+	}
+}
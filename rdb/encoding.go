@@ -0,0 +1,308 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// encoding.go decodes the compact encodings Redis uses inside RDB strings:
+// lzf compressed strings, ziplist, listpack, intset and zipmap.
+
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	// outLen is read from the file, out grows with the decompressed bytes
+	out := make([]byte, 0, prealloc(outLen))
+	for ip := 0; ip < len(in); {
+		ctrl := int(in[ip])
+		ip++
+		if ctrl < 32 {
+			// literal run of ctrl+1 bytes
+			ctrl++
+			if ip+ctrl > len(in) {
+				return nil, fmt.Errorf("%w: invalid lzf literal", ErrBadFormat)
+			}
+			out = append(out, in[ip:ip+ctrl]...)
+			ip += ctrl
+			continue
+		}
+		// back reference
+		length := ctrl >> 5
+		if length == 7 {
+			if ip >= len(in) {
+				return nil, fmt.Errorf("%w: invalid lzf reference", ErrBadFormat)
+			}
+			length += int(in[ip])
+			ip++
+		}
+		if ip >= len(in) {
+			return nil, fmt.Errorf("%w: invalid lzf reference", ErrBadFormat)
+		}
+		ref := len(out) - ((ctrl & 0x1F) << 8) - int(in[ip]) - 1
+		ip++
+		if ref < 0 {
+			return nil, fmt.Errorf("%w: invalid lzf reference", ErrBadFormat)
+		}
+		// the reference may overlap the bytes being written, so copy byte by byte
+		for i := 0; i < length+2; i++ {
+			out = append(out, out[ref+i])
+		}
+	}
+	if len(out) != outLen {
+		return nil, fmt.Errorf("%w: lzf decompressed length %d, expect %d", ErrBadFormat, len(out), outLen)
+	}
+	return out, nil
+}
+
+func intBytes(v int64) []byte {
+	return []byte(strconv.FormatInt(v, 10))
+}
+
+// parseZiplist decodes a ziplist:
+// zlbytes(uint32) zltail(uint32) zllen(uint16) entry... 0xFF
+// entry: prevlen encoding data
+func parseZiplist(buf []byte) ([][]byte, error) {
+	if len(buf) < 11 {
+		return nil, fmt.Errorf("%w: ziplist is too short", ErrBadFormat)
+	}
+	res := make([][]byte, 0, binary.LittleEndian.Uint16(buf[8:10]))
+	pos := 10
+	for {
+		if pos >= len(buf) {
+			return nil, fmt.Errorf("%w: ziplist without end", ErrBadFormat)
+		}
+		if buf[pos] == 0xFF {
+			return res, nil
+		}
+		// skip prevlen
+		if buf[pos] == 0xFE {
+			pos += 5
+		} else {
+			pos++
+		}
+		if pos >= len(buf) {
+			return nil, fmt.Errorf("%w: ziplist entry is truncated", ErrBadFormat)
+		}
+
+		enc := buf[pos]
+		var length, dataLen int
+		var isInt bool
+		switch {
+		case enc>>6 == 0:
+			length, dataLen = 1, int(enc&0x3F)
+		case enc>>6 == 1:
+			if pos+1 >= len(buf) {
+				return nil, fmt.Errorf("%w: ziplist entry is truncated", ErrBadFormat)
+			}
+			length, dataLen = 2, int(enc&0x3F)<<8|int(buf[pos+1])
+		case enc>>6 == 2:
+			if pos+4 >= len(buf) {
+				return nil, fmt.Errorf("%w: ziplist entry is truncated", ErrBadFormat)
+			}
+			length, dataLen = 5, int(binary.BigEndian.Uint32(buf[pos+1:pos+5]))
+		case enc == 0xC0:
+			length, dataLen, isInt = 1, 2, true
+		case enc == 0xD0:
+			length, dataLen, isInt = 1, 4, true
+		case enc == 0xE0:
+			length, dataLen, isInt = 1, 8, true
+		case enc == 0xF0:
+			length, dataLen, isInt = 1, 3, true
+		case enc == 0xFE:
+			length, dataLen, isInt = 1, 1, true
+		case enc >= 0xF1 && enc <= 0xFD:
+			// 4 bit immediate integer between 0 and 12
+			res = append(res, intBytes(int64(enc&0x0F)-1))
+			pos++
+			continue
+		default:
+			return nil, fmt.Errorf("%w: unknown ziplist encoding %#x", ErrBadFormat, enc)
+		}
+		pos += length
+		if pos+dataLen > len(buf) {
+			return nil, fmt.Errorf("%w: ziplist entry is truncated", ErrBadFormat)
+		}
+		data := buf[pos : pos+dataLen]
+		pos += dataLen
+		if !isInt {
+			res = append(res, append([]byte{}, data...))
+			continue
+		}
+		var v int64
+		switch dataLen {
+		case 1:
+			v = int64(int8(data[0]))
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(data)))
+		case 3:
+			v = int64(int32(uint32(data[0])<<8|uint32(data[1])<<16|uint32(data[2])<<24) >> 8)
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(data)))
+		case 8:
+			v = int64(binary.LittleEndian.Uint64(data))
+		}
+		res = append(res, intBytes(v))
+	}
+}
+
+// listpackBacklenSize returns the size of the backlen field of an entry with given length
+func listpackBacklenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// parseListpack decodes a listpack:
+// total bytes(uint32) element number(uint16) entry... 0xFF
+// entry: encoding data backlen
+func parseListpack(buf []byte) ([][]byte, error) {
+	if len(buf) < 7 {
+		return nil, fmt.Errorf("%w: listpack is too short", ErrBadFormat)
+	}
+	res := make([][]byte, 0, binary.LittleEndian.Uint16(buf[4:6]))
+	pos := 6
+	for {
+		if pos >= len(buf) {
+			return nil, fmt.Errorf("%w: listpack without end", ErrBadFormat)
+		}
+		enc := buf[pos]
+		if enc == 0xFF {
+			return res, nil
+		}
+
+		var headLen, dataLen, intBits int
+		var uval uint64
+		switch {
+		case enc>>7 == 0:
+			// 7 bit unsigned integer
+			headLen, uval, intBits = 1, uint64(enc&0x7F), 64
+		case enc>>6 == 2:
+			headLen, dataLen = 1, int(enc&0x3F)
+		case enc>>5 == 6:
+			if pos+1 >= len(buf) {
+				return nil, fmt.Errorf("%w: listpack entry is truncated", ErrBadFormat)
+			}
+			headLen, uval, intBits = 2, uint64(enc&0x1F)<<8|uint64(buf[pos+1]), 13
+		case enc>>4 == 14:
+			if pos+1 >= len(buf) {
+				return nil, fmt.Errorf("%w: listpack entry is truncated", ErrBadFormat)
+			}
+			headLen, dataLen = 2, int(enc&0x0F)<<8|int(buf[pos+1])
+		case enc == 0xF0:
+			if pos+4 >= len(buf) {
+				return nil, fmt.Errorf("%w: listpack entry is truncated", ErrBadFormat)
+			}
+			headLen, dataLen = 5, int(binary.LittleEndian.Uint32(buf[pos+1:pos+5]))
+		case enc >= 0xF1 && enc <= 0xF4:
+			size := [...]int{2, 3, 4, 8}[enc-0xF1]
+			if pos+size >= len(buf) {
+				return nil, fmt.Errorf("%w: listpack entry is truncated", ErrBadFormat)
+			}
+			for i := size; i > 0; i-- {
+				uval = uval<<8 | uint64(buf[pos+i])
+			}
+			headLen, intBits = 1+size, size*8
+		default:
+			return nil, fmt.Errorf("%w: unknown listpack encoding %#x", ErrBadFormat, enc)
+		}
+
+		entryLen := headLen + dataLen
+		if pos+entryLen > len(buf) {
+			return nil, fmt.Errorf("%w: listpack entry is truncated", ErrBadFormat)
+		}
+		if intBits > 0 {
+			// convert the two's complement value of intBits bits to int64
+			v := int64(uval)
+			if intBits < 64 && uval >= 1<<(intBits-1) {
+				v = int64(uval) - 1<<intBits
+			}
+			res = append(res, intBytes(v))
+		} else {
+			res = append(res, append([]byte{}, buf[pos+headLen:pos+entryLen]...))
+		}
+		pos += entryLen + listpackBacklenSize(entryLen)
+	}
+}
+
+// parseIntset decodes an intset:
+// encoding(uint32: 2, 4 or 8) length(uint32) integer...
+func parseIntset(buf []byte) ([][]byte, error) {
+	if len(buf) < 8 {
+		return nil, fmt.Errorf("%w: intset is too short", ErrBadFormat)
+	}
+	size := int(binary.LittleEndian.Uint32(buf[0:4]))
+	n := int(binary.LittleEndian.Uint32(buf[4:8]))
+	if (size != 2 && size != 4 && size != 8) || len(buf) < 8+size*n {
+		return nil, fmt.Errorf("%w: invalid intset", ErrBadFormat)
+	}
+	res := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		data := buf[8+i*size : 8+(i+1)*size]
+		var v int64
+		switch size {
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(data)))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(data)))
+		case 8:
+			v = int64(binary.LittleEndian.Uint64(data))
+		}
+		res = append(res, intBytes(v))
+	}
+	return res, nil
+}
+
+// parseZipmap decodes the zipmap used by old Redis versions for small hashes:
+// zmlen(1 byte) (len key len free value free-bytes)... 0xFF
+func parseZipmap(buf []byte) (map[string][]byte, error) {
+	readLen := func(pos int) (int, int, error) {
+		if pos >= len(buf) {
+			return 0, 0, fmt.Errorf("%w: zipmap is truncated", ErrBadFormat)
+		}
+		if buf[pos] < 254 {
+			return int(buf[pos]), pos + 1, nil
+		}
+		if buf[pos] == 254 && pos+4 < len(buf) {
+			return int(binary.LittleEndian.Uint32(buf[pos+1 : pos+5])), pos + 5, nil
+		}
+		return 0, 0, fmt.Errorf("%w: invalid zipmap length", ErrBadFormat)
+	}
+
+	hash := make(map[string][]byte)
+	pos := 1
+	for {
+		if pos >= len(buf) {
+			return nil, fmt.Errorf("%w: zipmap without end", ErrBadFormat)
+		}
+		if buf[pos] == 0xFF {
+			return hash, nil
+		}
+		var keyLen, valLen int
+		var err error
+		if keyLen, pos, err = readLen(pos); err != nil {
+			return nil, err
+		}
+		if pos+keyLen > len(buf) {
+			return nil, fmt.Errorf("%w: zipmap is truncated", ErrBadFormat)
+		}
+		key := string(buf[pos : pos+keyLen])
+		pos += keyLen
+		if valLen, pos, err = readLen(pos); err != nil {
+			return nil, err
+		}
+		if pos+1+valLen > len(buf) {
+			return nil, fmt.Errorf("%w: zipmap is truncated", ErrBadFormat)
+		}
+		free := int(buf[pos])
+		pos++
+		hash[key] = append([]byte{}, buf[pos:pos+valLen]...)
+		pos += valLen + free
+	}
+}
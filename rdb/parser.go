@@ -0,0 +1,604 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"math"
+	"strconv"
+)
+
+// rdb package parses the RDB files produced by Redis.
+// Check https://rdb.fnordig.de/file_format.html and rdb.h in Redis source for the format details.
+
+// ValueType is the type of a value in an RDB file
+type ValueType byte
+
+const (
+	TypeString            ValueType = 0
+	TypeList              ValueType = 1
+	TypeSet               ValueType = 2
+	TypeZSet              ValueType = 3
+	TypeHash              ValueType = 4
+	TypeZSet2             ValueType = 5
+	TypeModulePreGA       ValueType = 6
+	TypeModule2           ValueType = 7
+	TypeHashZipmap        ValueType = 9
+	TypeListZiplist       ValueType = 10
+	TypeSetIntset         ValueType = 11
+	TypeZSetZiplist       ValueType = 12
+	TypeHashZiplist       ValueType = 13
+	TypeListQuicklist     ValueType = 14
+	TypeStreamListpacks   ValueType = 15
+	TypeHashListpack      ValueType = 16
+	TypeZSetListpack      ValueType = 17
+	TypeListQuicklist2    ValueType = 18
+	TypeStreamListpacks2  ValueType = 19
+	TypeSetListpack       ValueType = 20
+	TypeStreamListpacks3  ValueType = 21
+	TypeHashMetadataPreGA ValueType = 22
+	TypeHashListpackExPre ValueType = 23
+	TypeHashMetadata      ValueType = 24
+	TypeHashListpackEx    ValueType = 25
+)
+
+// Name returns the redis type name of t
+func (t ValueType) Name() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeList, TypeListZiplist, TypeListQuicklist, TypeListQuicklist2:
+		return "list"
+	case TypeSet, TypeSetIntset, TypeSetListpack:
+		return "set"
+	case TypeZSet, TypeZSet2, TypeZSetZiplist, TypeZSetListpack:
+		return "zset"
+	case TypeHash, TypeHashZipmap, TypeHashZiplist, TypeHashListpack:
+		return "hash"
+	case TypeHashMetadataPreGA, TypeHashListpackExPre, TypeHashMetadata, TypeHashListpackEx:
+		return "hash with field expiration"
+	case TypeStreamListpacks, TypeStreamListpacks2, TypeStreamListpacks3:
+		return "stream"
+	case TypeModulePreGA, TypeModule2:
+		return "module"
+	}
+	return "unknown(" + strconv.Itoa(int(t)) + ")"
+}
+
+const (
+	opSlotInfo      = 0xF4
+	opFunction2     = 0xF5
+	opFunctionPreGA = 0xF6
+	opModuleAux     = 0xF7
+	opIdle          = 0xF8
+	opFreq          = 0xF9
+	opAux           = 0xFA
+	opResizeDb      = 0xFB
+	opExpireTimeMs  = 0xFC
+	opExpireTime    = 0xFD
+	opSelectDb      = 0xFE
+	opEOF           = 0xFF
+)
+
+// special string encodings
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLzf   = 3
+)
+
+// quicklist node containers
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+const maxVersion = 12
+
+var ErrBadFormat = errors.New("bad rdb format")
+
+// UnsupportedTypeError is returned when a key holds a type which can not be represented
+type UnsupportedTypeError struct {
+	Key  string
+	Type ValueType
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("key %q holds a %s value which is not supported", e.Key, e.Type.Name())
+}
+
+// ZSetMember is a member of a sorted set and its score
+type ZSetMember struct {
+	Member []byte
+	Score  float64
+}
+
+// Entry is a key:value pair read from the RDB file.
+// Value is []byte for strings, [][]byte for lists and sets, map[string][]byte for hashes and []ZSetMember for sorted sets.
+type Entry struct {
+	DB       int
+	Key      string
+	Type     ValueType
+	Value    any
+	ExpireAt int64 // absolute unix time in milliseconds, -1 if the key has no ttl
+}
+
+// crcTable is used for the crc-64-jones checksum used by Redis
+var crcTable = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+// crc64Jones updates crc with p. Unlike hash/crc64, Redis does not invert the crc before and after the update.
+func crc64Jones(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crcTable[byte(crc)^b] ^ (crc >> 8)
+	}
+	return crc
+}
+
+type parser struct {
+	r       *bufio.Reader
+	crc     uint64
+	version int
+}
+
+func (p *parser) read(buf []byte) error {
+	if _, err := io.ReadFull(p.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	p.crc = crc64Jones(p.crc, buf)
+	return nil
+}
+
+// maxPrealloc bounds the memory allocated ahead of reading from a length in the file, so that a corrupt length
+// fails with ErrBadFormat when the file ends instead of allocating gigabytes first.
+// Strings are read in chunks of maxPrealloc bytes and slices of elements start with at most maxPrealloc elements.
+const maxPrealloc = 1024
+
+// prealloc returns the capacity to allocate for n elements read from the file
+func prealloc(n int) int {
+	if n > maxPrealloc {
+		return maxPrealloc
+	}
+	return n
+}
+
+// readBytes reads n bytes, growing the buffer by the chunks read
+func (p *parser) readBytes(n int) ([]byte, error) {
+	buf := make([]byte, 0, prealloc(n))
+	for len(buf) < n {
+		chunk := prealloc(n - len(buf))
+		buf = append(buf, make([]byte, chunk)...)
+		if err := p.read(buf[len(buf)-chunk:]); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func (p *parser) readByte() (byte, error) {
+	var buf [1]byte
+	err := p.read(buf[:])
+	return buf[0], err
+}
+
+// readLength reads a length encoded value.
+// If the value is a special encoded string, encoded is true and the encoding type is returned.
+func (p *parser) readLength() (length uint64, encoded bool, err error) {
+	b, err := p.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F), false, nil
+	case 1:
+		next, err := p.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3F)<<8 | uint64(next), false, nil
+	case 2:
+		switch b {
+		case 0x80:
+			var buf [4]byte
+			if err = p.read(buf[:]); err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf[:])), false, nil
+		case 0x81:
+			var buf [8]byte
+			if err = p.read(buf[:]); err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf[:]), false, nil
+		}
+		return 0, false, fmt.Errorf("%w: unknown length encoding %#x", ErrBadFormat, b)
+	}
+	return uint64(b & 0x3F), true, nil
+}
+
+func (p *parser) readLen() (int, error) {
+	length, encoded, err := p.readLength()
+	if err != nil {
+		return 0, err
+	}
+	if encoded || length > math.MaxInt32 {
+		return 0, fmt.Errorf("%w: invalid length", ErrBadFormat)
+	}
+	return int(length), nil
+}
+
+func (p *parser) readString() ([]byte, error) {
+	length, encoded, err := p.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		if length > math.MaxInt32 {
+			return nil, fmt.Errorf("%w: string is too long", ErrBadFormat)
+		}
+		return p.readBytes(int(length))
+	}
+	switch length {
+	case encInt8:
+		b, err := p.readByte()
+		return []byte(strconv.FormatInt(int64(int8(b)), 10)), err
+	case encInt16:
+		var buf [2]byte
+		err := p.read(buf[:])
+		return []byte(strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(buf[:]))), 10)), err
+	case encInt32:
+		var buf [4]byte
+		err := p.read(buf[:])
+		return []byte(strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(buf[:]))), 10)), err
+	case encLzf:
+		clen, err := p.readLen()
+		if err != nil {
+			return nil, err
+		}
+		ulen, err := p.readLen()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := p.readBytes(clen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, ulen)
+	}
+	return nil, fmt.Errorf("%w: unknown string encoding %d", ErrBadFormat, length)
+}
+
+// Parse reads an RDB file from reader and calls handle for every key.
+// It stops and returns the error if handle returns an error.
+func Parse(reader io.Reader, handle func(e *Entry) error) error {
+	p := &parser{r: bufio.NewReader(reader)}
+
+	header := make([]byte, 9)
+	if err := p.read(header); err != nil {
+		return fmt.Errorf("%w: %s", ErrBadFormat, err.Error())
+	}
+	if string(header[:5]) != "REDIS" {
+		return fmt.Errorf("%w: wrong magic string", ErrBadFormat)
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 || version > maxVersion {
+		return fmt.Errorf("%w: unsupported version %s", ErrBadFormat, string(header[5:]))
+	}
+	p.version = version
+
+	db := 0
+	expireAt := int64(-1)
+	for {
+		op, err := p.readByte()
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrBadFormat, err.Error())
+		}
+		switch op {
+		case opEOF:
+			if p.version < 5 {
+				return nil
+			}
+			sum := p.crc
+			var buf [8]byte
+			if _, err = io.ReadFull(p.r, buf[:]); err != nil {
+				return fmt.Errorf("%w: %s", ErrBadFormat, err.Error())
+			}
+			// zero checksum means checksum is disabled
+			expected := binary.LittleEndian.Uint64(buf[:])
+			if expected != 0 && expected != sum {
+				return fmt.Errorf("%w: checksum mismatch", ErrBadFormat)
+			}
+			return nil
+		case opSelectDb:
+			if db, err = p.readLen(); err != nil {
+				return fmt.Errorf("%w: %s", ErrBadFormat, err.Error())
+			}
+		case opResizeDb:
+			if _, err = p.readLen(); err != nil {
+				return fmt.Errorf("%w: %s", ErrBadFormat, err.Error())
+			}
+			if _, err = p.readLen(); err != nil {
+				return fmt.Errorf("%w: %s", ErrBadFormat, err.Error())
+			}
+		case opAux:
+			// auxiliary fields like redis-ver are not used
+			if _, err = p.readString(); err != nil {
+				return fmt.Errorf("%w: %s", ErrBadFormat, err.Error())
+			}
+			if _, err = p.readString(); err != nil {
+				return fmt.Errorf("%w: %s", ErrBadFormat, err.Error())
+			}
+		case opExpireTime:
+			var buf [4]byte
+			if err = p.read(buf[:]); err != nil {
+				return fmt.Errorf("%w: %s", ErrBadFormat, err.Error())
+			}
+			expireAt = int64(binary.LittleEndian.Uint32(buf[:])) * 1000
+		case opExpireTimeMs:
+			var buf [8]byte
+			if err = p.read(buf[:]); err != nil {
+				return fmt.Errorf("%w: %s", ErrBadFormat, err.Error())
+			}
+			expireAt = int64(binary.LittleEndian.Uint64(buf[:]))
+		case opFreq:
+			if _, err = p.readByte(); err != nil {
+				return fmt.Errorf("%w: %s", ErrBadFormat, err.Error())
+			}
+		case opIdle:
+			if _, err = p.readLen(); err != nil {
+				return fmt.Errorf("%w: %s", ErrBadFormat, err.Error())
+			}
+		case opSlotInfo:
+			for i := 0; i < 3; i++ {
+				if _, err = p.readLen(); err != nil {
+					return fmt.Errorf("%w: %s", ErrBadFormat, err.Error())
+				}
+			}
+		case opFunction2:
+			// functions are not supported, skip the library code
+			if _, err = p.readString(); err != nil {
+				return fmt.Errorf("%w: %s", ErrBadFormat, err.Error())
+			}
+		case opFunctionPreGA, opModuleAux:
+			return fmt.Errorf("%w: opcode %#x is not supported", ErrBadFormat, op)
+		default:
+			key, err := p.readString()
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrBadFormat, err.Error())
+			}
+			valType := ValueType(op)
+			value, err := p.readValue(valType)
+			if err != nil {
+				var typeErr *UnsupportedTypeError
+				if errors.As(err, &typeErr) {
+					typeErr.Key = string(key)
+					return typeErr
+				}
+				return fmt.Errorf("%w: read key %q: %s", ErrBadFormat, string(key), err.Error())
+			}
+			entry := &Entry{DB: db, Key: string(key), Type: valType, Value: value, ExpireAt: expireAt}
+			if err = handle(entry); err != nil {
+				return err
+			}
+			expireAt = -1
+		}
+	}
+}
+
+func (p *parser) readStrings(n int) ([][]byte, error) {
+	res := make([][]byte, 0, prealloc(n))
+	for i := 0; i < n; i++ {
+		val, err := p.readString()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, val)
+	}
+	return res, nil
+}
+
+func pairsToHash(pairs [][]byte) (map[string][]byte, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("%w: hash has odd number of elements", ErrBadFormat)
+	}
+	hash := make(map[string][]byte, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		hash[string(pairs[i])] = pairs[i+1]
+	}
+	return hash, nil
+}
+
+// readDouble reads a score of TypeZSet, a length byte followed by the score as a string.
+// 253, 254 and 255 stand for nan, +inf and -inf.
+func (p *parser) readDouble() (float64, error) {
+	n, err := p.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf := make([]byte, n)
+	if err = p.read(buf); err != nil {
+		return 0, err
+	}
+	score, err := strconv.ParseFloat(string(buf), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad score %q", ErrBadFormat, buf)
+	}
+	return score, nil
+}
+
+// readBinaryDouble reads a score of TypeZSet2, a little endian float64
+func (p *parser) readBinaryDouble() (float64, error) {
+	var buf [8]byte
+	if err := p.read(buf[:]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf[:])), nil
+}
+
+func pairsToZSet(pairs [][]byte) ([]ZSetMember, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("%w: sorted set has odd number of elements", ErrBadFormat)
+	}
+	zset := make([]ZSetMember, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := strconv.ParseFloat(string(pairs[i+1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad score %q", ErrBadFormat, pairs[i+1])
+		}
+		zset = append(zset, ZSetMember{Member: pairs[i], Score: score})
+	}
+	return zset, nil
+}
+
+func (p *parser) readValue(valType ValueType) (any, error) {
+	switch valType {
+	case TypeString:
+		return p.readString()
+	case TypeList, TypeSet:
+		n, err := p.readLen()
+		if err != nil {
+			return nil, err
+		}
+		return p.readStrings(n)
+	case TypeHash:
+		n, err := p.readLen()
+		if err != nil {
+			return nil, err
+		}
+		pairs, err := p.readStrings(n * 2)
+		if err != nil {
+			return nil, err
+		}
+		return pairsToHash(pairs)
+	case TypeZSet, TypeZSet2:
+		n, err := p.readLen()
+		if err != nil {
+			return nil, err
+		}
+		zset := make([]ZSetMember, 0, prealloc(n))
+		for i := 0; i < n; i++ {
+			member, err := p.readString()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if valType == TypeZSet {
+				score, err = p.readDouble()
+			} else {
+				score, err = p.readBinaryDouble()
+			}
+			if err != nil {
+				return nil, err
+			}
+			zset = append(zset, ZSetMember{Member: member, Score: score})
+		}
+		return zset, nil
+	case TypeZSetZiplist, TypeZSetListpack:
+		buf, err := p.readString()
+		if err != nil {
+			return nil, err
+		}
+		var pairs [][]byte
+		if valType == TypeZSetZiplist {
+			pairs, err = parseZiplist(buf)
+		} else {
+			pairs, err = parseListpack(buf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return pairsToZSet(pairs)
+	case TypeHashZipmap:
+		buf, err := p.readString()
+		if err != nil {
+			return nil, err
+		}
+		return parseZipmap(buf)
+	case TypeListZiplist:
+		buf, err := p.readString()
+		if err != nil {
+			return nil, err
+		}
+		return parseZiplist(buf)
+	case TypeSetIntset:
+		buf, err := p.readString()
+		if err != nil {
+			return nil, err
+		}
+		return parseIntset(buf)
+	case TypeHashZiplist:
+		buf, err := p.readString()
+		if err != nil {
+			return nil, err
+		}
+		pairs, err := parseZiplist(buf)
+		if err != nil {
+			return nil, err
+		}
+		return pairsToHash(pairs)
+	case TypeSetListpack:
+		buf, err := p.readString()
+		if err != nil {
+			return nil, err
+		}
+		return parseListpack(buf)
+	case TypeHashListpack:
+		buf, err := p.readString()
+		if err != nil {
+			return nil, err
+		}
+		pairs, err := parseListpack(buf)
+		if err != nil {
+			return nil, err
+		}
+		return pairsToHash(pairs)
+	case TypeListQuicklist, TypeListQuicklist2:
+		n, err := p.readLen()
+		if err != nil {
+			return nil, err
+		}
+		list := make([][]byte, 0)
+		for i := 0; i < n; i++ {
+			container := uint64(quicklistNodePacked)
+			if valType == TypeListQuicklist2 {
+				if container, _, err = p.readLength(); err != nil {
+					return nil, err
+				}
+			}
+			buf, err := p.readString()
+			if err != nil {
+				return nil, err
+			}
+			var elems [][]byte
+			switch {
+			case container == quicklistNodePlain:
+				elems = [][]byte{buf}
+			case valType == TypeListQuicklist:
+				elems, err = parseZiplist(buf)
+			default:
+				elems, err = parseListpack(buf)
+			}
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elems...)
+		}
+		return list, nil
+	}
+	return nil, &UnsupportedTypeError{Type: valType}
+}
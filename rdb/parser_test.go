@@ -0,0 +1,204 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// rdbString encodes a short string with 6 bit length
+func rdbString(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func withChecksum(data []byte) []byte {
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], crc64Jones(0, data))
+	return append(data, sum[:]...)
+}
+
+func TestCrc64Jones(t *testing.T) {
+	if sum := crc64Jones(0, []byte("123456789")); sum != 0xe9c6d914c4b8d9ca {
+		t.Errorf("crc64 is %#x, expect 0xe9c6d914c4b8d9ca", sum)
+	}
+}
+
+func TestParse(t *testing.T) {
+	future := time.Now().Add(time.Hour).UnixMilli()
+	var expire [8]byte
+	binary.LittleEndian.PutUint64(expire[:], uint64(future))
+
+	data := []byte("REDIS0011")
+	data = append(data, opAux)
+	data = append(data, rdbString("redis-ver")...)
+	data = append(data, rdbString("7.2.0")...)
+	data = append(data, opSelectDb, 0, opResizeDb, 6, 1)
+
+	// plain, integer and lzf compressed strings
+	data = append(data, byte(TypeString))
+	data = append(data, rdbString("s")...)
+	data = append(data, rdbString("hello")...)
+	data = append(data, byte(TypeString))
+	data = append(data, rdbString("i")...)
+	data = append(data, 0xC0, 0x85)
+	data = append(data, byte(TypeString))
+	data = append(data, rdbString("lzf")...)
+	data = append(data, 0xC3, 5, 10, 0x01, 'a', 'b', 0xC0, 0x01)
+
+	// string with ttl
+	data = append(data, opExpireTimeMs)
+	data = append(data, expire[:]...)
+	data = append(data, byte(TypeString))
+	data = append(data, rdbString("e")...)
+	data = append(data, rdbString("v")...)
+
+	// quicklist with a listpack node ["a", 5, -3] and a plain node
+	listpack := []byte{0, 0, 0, 0, 3, 0, 0x81, 'a', 2, 0x05, 1, 0xDF, 0xFD, 2, 0xFF}
+	binary.LittleEndian.PutUint32(listpack, uint32(len(listpack)))
+	data = append(data, byte(TypeListQuicklist2))
+	data = append(data, rdbString("list")...)
+	data = append(data, 2, quicklistNodePacked, byte(len(listpack)))
+	data = append(data, listpack...)
+	data = append(data, quicklistNodePlain)
+	data = append(data, rdbString("plain")...)
+
+	// intset [1, -2]
+	intset := []byte{2, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0xFE, 0xFF}
+	data = append(data, byte(TypeSetIntset))
+	data = append(data, rdbString("set")...)
+	data = append(data, byte(len(intset)))
+	data = append(data, intset...)
+
+	// ziplist hash {"f": "v", "n": 7}
+	ziplist := []byte{0, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0x01, 'f', 3, 0x01, 'v', 3, 0x01, 'n', 3, 0xF8, 0xFF}
+	data = append(data, byte(TypeHashZiplist))
+	data = append(data, rdbString("hash")...)
+	data = append(data, byte(len(ziplist)))
+	data = append(data, ziplist...)
+	data = append(data, opEOF)
+
+	entries := make(map[string]*Entry)
+	err := Parse(bytes.NewReader(withChecksum(data)), func(e *Entry) error {
+		entries[e.Key] = e
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectStrings := map[string]string{"s": "hello", "i": "-123", "lzf": "ababababab", "e": "v"}
+	for key, expect := range expectStrings {
+		if e, ok := entries[key]; !ok || string(e.Value.([]byte)) != expect {
+			t.Errorf("string %s is not %s", key, expect)
+		}
+	}
+	if entries["e"].ExpireAt != future || entries["s"].ExpireAt != -1 {
+		t.Error("expire time error")
+	}
+	if list := entries["list"].Value.([][]byte); len(list) != 4 || string(list[0]) != "a" || string(list[1]) != "5" ||
+		string(list[2]) != "-3" || string(list[3]) != "plain" {
+		t.Errorf("list is %q", list)
+	}
+	if set := entries["set"].Value.([][]byte); len(set) != 2 || string(set[0]) != "1" || string(set[1]) != "-2" {
+		t.Errorf("set is %q", set)
+	}
+	if hash := entries["hash"].Value.(map[string][]byte); len(hash) != 2 || string(hash["f"]) != "v" || string(hash["n"]) != "7" {
+		t.Errorf("hash is %q", hash)
+	}
+
+	// wrong checksum
+	broken := withChecksum(data)
+	broken[len(broken)-1] ^= 0xFF
+	if err = Parse(bytes.NewReader(broken), func(e *Entry) error { return nil }); !errors.Is(err, ErrBadFormat) {
+		t.Error("parse rdb with wrong checksum should fail")
+	}
+}
+
+func TestParseZSet(t *testing.T) {
+	var score [8]byte
+	binary.LittleEndian.PutUint64(score[:], math.Float64bits(1.5))
+
+	data := []byte("REDIS0011")
+	// binary scores
+	data = append(data, byte(TypeZSet2))
+	data = append(data, rdbString("zset2")...)
+	data = append(data, 1)
+	data = append(data, rdbString("member")...)
+	data = append(data, score[:]...)
+	// string scores, 254 is +inf
+	data = append(data, byte(TypeZSet))
+	data = append(data, rdbString("zset")...)
+	data = append(data, 2)
+	data = append(data, rdbString("a")...)
+	data = append(data, rdbString("-2.5")...)
+	data = append(data, rdbString("b")...)
+	data = append(data, 254)
+	// listpack ["x", 3]
+	listpack := []byte{0, 0, 0, 0, 2, 0, 0x81, 'x', 2, 0x03, 1, 0xFF}
+	binary.LittleEndian.PutUint32(listpack, uint32(len(listpack)))
+	data = append(data, byte(TypeZSetListpack))
+	data = append(data, rdbString("zsetlp")...)
+	data = append(data, byte(len(listpack)))
+	data = append(data, listpack...)
+	data = append(data, opEOF)
+
+	entries := make(map[string][]ZSetMember)
+	err := Parse(bytes.NewReader(withChecksum(data)), func(e *Entry) error {
+		entries[e.Key] = e.Value.([]ZSetMember)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zset := entries["zset2"]; len(zset) != 1 || string(zset[0].Member) != "member" || zset[0].Score != 1.5 {
+		t.Errorf("zset2 is %v", zset)
+	}
+	if zset := entries["zset"]; len(zset) != 2 || zset[0].Score != -2.5 || !math.IsInf(zset[1].Score, 1) {
+		t.Errorf("zset is %v", zset)
+	}
+	if zset := entries["zsetlp"]; len(zset) != 1 || string(zset[0].Member) != "x" || zset[0].Score != 3 {
+		t.Errorf("listpack zset is %v", zset)
+	}
+}
+
+func TestParseUnsupportedType(t *testing.T) {
+	data := []byte("REDIS0011")
+	data = append(data, byte(TypeStreamListpacks3))
+	data = append(data, rdbString("stream")...)
+	data = append(data, opEOF)
+
+	err := Parse(bytes.NewReader(withChecksum(data)), func(e *Entry) error { return nil })
+	var typeErr *UnsupportedTypeError
+	if !errors.As(err, &typeErr) || typeErr.Key != "stream" || typeErr.Type.Name() != "stream" {
+		t.Errorf("parse unsupported type error: %v", err)
+	}
+}
+
+func TestParseCorruptLength(t *testing.T) {
+	// 0x80 is followed by a 32 bit big endian length
+	huge := []byte{0x80, 0x7F, 0xFF, 0xFF, 0xFF}
+	cases := map[string][]byte{
+		"string": append([]byte{byte(TypeString), 1, 'k'}, huge...),
+		"list":   append([]byte{byte(TypeList), 1, 'k'}, huge...),
+		"hash":   append([]byte{byte(TypeHash), 1, 'k'}, huge...),
+		"zset":   append([]byte{byte(TypeZSet2), 1, 'k'}, huge...),
+		"lzf":    append(append([]byte{byte(TypeString), 1, 'k', 0xC3}, huge...), huge...),
+	}
+	for name, value := range cases {
+		data := append([]byte("REDIS0011"), value...)
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		err := Parse(bytes.NewReader(data), func(e *Entry) error { return nil })
+		runtime.ReadMemStats(&after)
+		if !errors.Is(err, ErrBadFormat) {
+			t.Errorf("parse %s with a corrupt length returns %v", name, err)
+		}
+		if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+			t.Errorf("parse %s with a corrupt length allocates %d bytes", name, alloc)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
 
 	"github.com/VincentFF/thinredis/logger"
@@ -41,8 +42,8 @@ func parse(reader io.Reader, ch chan<- *ParsedRes) {
 		var msg []byte
 		msg, err = readLine(bufReader, state)
 		if err != nil {
-			// read ended, stop reading.
-			if err == io.EOF {
+			// read ended or the connection is closed by server, stop reading.
+			if err == io.EOF || errors.Is(err, net.ErrClosed) {
 				ch <- &ParsedRes{
 					Err: err,
 				}
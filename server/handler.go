@@ -1,38 +1,175 @@
 package server
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 
 	"github.com/VincentFF/thinredis/logger"
 	"github.com/VincentFF/thinredis/memdb"
+	"github.com/VincentFF/thinredis/pubsub"
 	"github.com/VincentFF/thinredis/resp"
 )
 
+// pushBufferSize is the number of pub/sub messages buffered for a client.
+// A client which falls behind by more messages is disconnected.
+const pushBufferSize = 1024
+
+// readBufferSize is the size of the buffered reader of a client connection
+const readBufferSize = 4096
+
+// client is a client connection. Replies and pushed pub/sub messages are written to conn under mu.
+type client struct {
+	conn     net.Conn
+	mu       sync.Mutex
+	pushCh   chan []byte
+	pushOnce sync.Once
+	pushDone chan struct{}
+	pending  atomic.Int64 // bytes of the queued pub/sub messages
+	db       int          // index of the selected database
+}
+
+func newClient(conn net.Conn) *client {
+	return &client{
+		conn:     conn,
+		pushCh:   make(chan []byte, pushBufferSize),
+		pushDone: make(chan struct{}),
+	}
+}
+
+func (c *client) write(res ...resp.RedisData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeLocked(res...)
+}
+
+// writeLocked writes res to conn, c.mu must be held
+func (c *client) writeLocked(res ...resp.RedisData) {
+	for _, r := range res {
+		if r == nil {
+			continue
+		}
+		_, err := c.conn.Write(r.ToBytes())
+		if err != nil {
+			logger.Error("write response to ", c.conn.RemoteAddr().String(), " error: ", err.Error())
+		}
+	}
+}
+
+// Push queues a pub/sub message for c, it implements pubsub.Subscriber.
+// Messages are written by another goroutine, so a slow client never blocks the publisher.
+func (c *client) Push(data []byte) {
+	c.pushOnce.Do(func() {
+		go func() {
+			defer close(c.pushDone)
+			for data := range c.pushCh {
+				c.mu.Lock()
+				_, err := c.conn.Write(data)
+				c.mu.Unlock()
+				c.pending.Add(-int64(len(data)))
+				if err != nil {
+					logger.Error("push message to ", c.conn.RemoteAddr().String(), " error: ", err.Error())
+				}
+			}
+		}()
+	})
+	select {
+	case c.pushCh <- data:
+		c.pending.Add(int64(len(data)))
+	default:
+		logger.Warning("pub/sub buffer of ", c.conn.RemoteAddr().String(), " is full, close the connection")
+		_ = c.conn.Close()
+	}
+}
+
+// memory returns the estimated memory used by the buffers of c
+func (c *client) memory() int64 {
+	return readBufferSize + pushBufferSize*int64(unsafe.Sizeof([]byte(nil))) + c.pending.Load()
+}
+
+// closePush stops the push goroutine after the queued messages are written.
+// It must be called after c is unsubscribed from everything, so that Push is not called any more.
+func (c *client) closePush() {
+	started := true
+	c.pushOnce.Do(func() { started = false })
+	close(c.pushCh)
+	if started {
+		<-c.pushDone
+	}
+}
+
 // Handler handles all client requests to the server
-// It holds a MemDb instance to exchange data with clients
+// It holds the databases to exchange data with clients, each client executes commands in the database it selected
+// conns holds all client connections, so that they can be closed on shutdown
+// running counts the commands being executed, no new command is executed when closing is true.
+// drained is the WaitGroup of the running commands a shutdown waits for, every attempt creates its own.
 type Handler struct {
-	memDb *memdb.MemDb
+	dbs        *memdb.Databases
+	hub        *pubsub.Hub
+	mu         sync.Mutex
+	conns      map[net.Conn]*client
+	closing    bool
+	running    int
+	drained    *sync.WaitGroup
+	shutdownCh chan *shutdownRequest
+}
+
+// shutdownRequest asks the server to shut down. save is one of "", "save" and "nosave".
+// The server replies nil on reply if it is shutting down, or the error that stops the shutdown.
+type shutdownRequest struct {
+	save  string
+	reply chan error
 }
 
-func NewHandler() *Handler {
-	return &Handler{
-		memDb: memdb.NewMemDb(),
+func NewHandler(dbs *memdb.Databases) *Handler {
+	h := &Handler{
+		dbs:        dbs,
+		hub:        dbs.Hub(),
+		conns:      make(map[net.Conn]*client),
+		shutdownCh: make(chan *shutdownRequest),
 	}
+	dbs.SetClientsMemory(h.clientsMemory)
+	return h
+}
+
+// clientsMemory returns the number of clients and the memory used by their buffers
+func (h *Handler) clientsMemory() (int, int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	size := int64(0)
+	for _, c := range h.conns {
+		size += c.memory()
+	}
+	return len(h.conns), size
 }
 
 func (h *Handler) Handle(conn net.Conn) {
+	c := newClient(conn)
 	defer func() {
+		h.mu.Lock()
+		delete(h.conns, conn)
+		h.mu.Unlock()
+		h.hub.UnsubscribeAll(c)
+		c.closePush()
 		err := conn.Close()
-		if err != nil {
+		if err != nil && !errors.Is(err, net.ErrClosed) {
 			logger.Error(err)
 		}
 	}()
+	h.mu.Lock()
+	h.conns[conn] = c
+	h.mu.Unlock()
 
 	ch := resp.ParseStream(conn)
 	for parsedRes := range ch {
 		if parsedRes.Err != nil {
-			if parsedRes.Err == io.EOF {
+			if parsedRes.Err == io.EOF || errors.Is(parsedRes.Err, net.ErrClosed) {
 				logger.Info("Close connection ", conn.RemoteAddr().String())
 			} else {
 				logger.Panic("Handle connection ", conn.RemoteAddr().String(), " panic: ", parsedRes.Err.Error())
@@ -51,20 +188,185 @@ func (h *Handler) Handle(conn net.Conn) {
 		}
 
 		cmd := arrayData.TOCommand()
-		res := h.memDb.ExecCommand(cmd)
-		if res != nil {
-			_, err := conn.Write(res.ToBytes())
-			if err != nil {
-				logger.Error("write response to ", conn.RemoteAddr().String(), " error: ", err.Error())
+		if len(cmd) == 0 {
+			continue
+		}
+		cmdName := strings.ToLower(string(cmd[0]))
+		switch cmdName {
+		case "shutdown":
+			c.write(h.shutdown(cmd))
+			continue
+		case "subscribe", "unsubscribe", "psubscribe", "punsubscribe":
+			h.subscribe(c, cmdName, cmd)
+			continue
+		}
+		// a client subscribing channels can only (un)subscribe and ping
+		if h.hub.Count(c) > 0 {
+			if cmdName == "ping" && len(cmd) <= 2 {
+				msg := []byte{}
+				if len(cmd) == 2 {
+					msg = cmd[1]
+				}
+				c.write(resp.MakeArrayData([]resp.RedisData{resp.MakeBulkData([]byte("pong")), resp.MakeBulkData(msg)}))
+			} else {
+				c.write(resp.MakeErrorData(fmt.Sprintf("error: Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context", cmdName)))
 			}
+			continue
+		}
+		if cmdName == "select" {
+			c.write(h.selectDb(c, cmd))
+			continue
+		}
+
+		if !h.begin() {
+			c.write(resp.MakeErrorData("error: server is shutting down"))
+			continue
+		}
+		res := h.dbs.ExecCommand(c.db, cmd)
+		if res != nil {
+			c.write(res)
 		} else {
-			errData := resp.MakeErrorData("unknown error")
-			_, err := conn.Write(errData.ToBytes())
-			if err != nil {
-				logger.Error("write response to ", conn.RemoteAddr().String(), " error: ", err.Error())
-			}
+			c.write(resp.MakeErrorData("unknown error"))
 		}
+		h.end()
+	}
+}
 
+// subscribe handles SUBSCRIBE, UNSUBSCRIBE, PSUBSCRIBE and PUNSUBSCRIBE.
+// The replies are written before any message published to the new subscriptions.
+func (h *Handler) subscribe(c *client, cmdName string, cmd [][]byte) {
+	names := make([]string, 0, len(cmd)-1)
+	for _, name := range cmd[1:] {
+		names = append(names, string(name))
+	}
+	if len(names) == 0 && (cmdName == "subscribe" || cmdName == "psubscribe") {
+		c.write(resp.MakeErrorData(fmt.Sprintf("wrong number of arguments for '%s' command", cmdName)))
+		return
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch cmdName {
+	case "subscribe":
+		c.writeLocked(h.hub.Subscribe(c, names)...)
+	case "unsubscribe":
+		c.writeLocked(h.hub.Unsubscribe(c, names)...)
+	case "psubscribe":
+		c.writeLocked(h.hub.PSubscribe(c, names)...)
+	case "punsubscribe":
+		c.writeLocked(h.hub.PUnsubscribe(c, names)...)
+	}
+}
+
+// selectDb handles SELECT index, the following commands of c are executed in the database at index
+func (h *Handler) selectDb(c *client, cmd [][]byte) resp.RedisData {
+	if len(cmd) != 2 {
+		return resp.MakeErrorData("wrong number of arguments for 'select' command")
+	}
+	index, err := h.dbs.ParseIndex(cmd[1])
+	if err != nil {
+		return resp.MakeErrorData("error: " + err.Error())
+	}
+	c.db = index
+	return resp.MakeStringData("OK")
+}
+
+// shutdown handles SHUTDOWN [NOSAVE|SAVE]. It returns nil if the server is shutting down,
+// the connection is closed by the server without a reply then.
+func (h *Handler) shutdown(cmd [][]byte) resp.RedisData {
+	req := &shutdownRequest{reply: make(chan error, 1)}
+	if len(cmd) > 2 {
+		return resp.MakeErrorData("wrong number of arguments for 'shutdown' command")
+	}
+	if len(cmd) == 2 {
+		req.save = strings.ToLower(string(cmd[1]))
+		if req.save != "save" && req.save != "nosave" {
+			return resp.MakeErrorData("error: syntax error")
+		}
+	}
+	// the server doesn't wait for requests while it is shutting down
+	select {
+	case h.shutdownCh <- req:
+	default:
+		return resp.MakeErrorData("error: server is shutting down")
+	}
+	if err := <-req.reply; err != nil {
+		return resp.MakeErrorData("error: " + err.Error())
+	}
+	return nil
+}
+
+// begin marks a command as running. It returns false if the server is shutting down.
+func (h *Handler) begin() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closing {
+		return false
+	}
+	h.running++
+	return true
+}
+
+// end marks a command begun by begin as finished
+func (h *Handler) end() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running--
+	if h.drained != nil {
+		h.drained.Done()
+	}
+}
+
+// drain stops executing new commands and waits for the running commands to finish.
+// It returns false if they are not finished in timeout.
+func (h *Handler) drain(timeout time.Duration) bool {
+	h.mu.Lock()
+	h.closing = true
+	if h.running == 0 {
+		h.mu.Unlock()
+		return true
+	}
+	h.drained = &sync.WaitGroup{}
+	h.drained.Add(h.running)
+	drained := h.drained
+	h.mu.Unlock()
+	return waitTimeout(drained, timeout)
+}
+
+// resume executes commands again after a failed shutdown.
+// The commands still running are released from drained, the next shutdown counts them again with its own WaitGroup.
+func (h *Handler) resume() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.drained != nil {
+		h.drained.Add(-h.running)
+		h.drained = nil
+	}
+	h.closing = false
+}
+
+// closeConns closes all client connections
+func (h *Handler) closeConns() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for conn := range h.conns {
+		if err := conn.Close(); err != nil {
+			logger.Error("close connection ", conn.RemoteAddr().String(), " error: ", err.Error())
+		}
+	}
+}
+
+// waitTimeout waits for wg and returns false if wg is not done in timeout
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
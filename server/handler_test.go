@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/VincentFF/thinredis/config"
+	"github.com/VincentFF/thinredis/memdb"
+)
+
+func init() {
+	config.Configures = &config.Config{ShardNum: 100}
+	memdb.RegisterStringCommands()
+}
+
+// testClient is a client connected to a Handler through a loopback connection
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func connect(t *testing.T, h *Handler) *testClient {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = clientConn.Close() })
+	serverConn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go h.Handle(serverConn)
+	return &testClient{conn: clientConn, reader: bufio.NewReader(clientConn)}
+}
+
+// send sends a command and returns the first line of the reply, or io.EOF if the connection is closed
+func (c *testClient) send(args ...string) (string, error) {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"+arg+"\r\n"...)
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return "", err
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return line[:len(line)-2], nil
+}
+
+// newTestServer returns the config, databases, handler and listener of a server persisting to a temporary dir
+func newTestServer(t *testing.T, appendOnly bool) (*config.Config, *memdb.Databases, *Handler, net.Listener) {
+	t.Helper()
+	cfg := &config.Config{ShardNum: 100, Dir: t.TempDir(), DbFilename: "dump.tdb", AppendOnly: appendOnly}
+	config.Configures = cfg
+	t.Cleanup(func() { config.Configures = &config.Config{ShardNum: 100} })
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	dbs := memdb.NewDatabases(1)
+	return cfg, dbs, NewHandler(dbs), listener
+}
+
+func listening(listener net.Listener) bool {
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+func TestDrainWaitsForRunningCommands(t *testing.T) {
+	_, _, h, _ := newTestServer(t, false)
+	c := connect(t, h)
+	if !h.begin() {
+		t.Fatal("a command can't begin before shutdown")
+	}
+	drained := make(chan bool)
+	go func() { drained <- h.drain(time.Second) }()
+
+	time.Sleep(20 * time.Millisecond)
+	if reply, err := c.send("set", "a", "1"); err != nil || reply != "-error: server is shutting down" {
+		t.Errorf("a command during shutdown replies %q, %v", reply, err)
+	}
+	select {
+	case <-drained:
+		t.Fatal("drain returns before the running command is finished")
+	default:
+	}
+	h.end()
+	if !<-drained {
+		t.Error("drain times out after the running command is finished")
+	}
+}
+
+func TestShutdownAbortsOnTimeout(t *testing.T) {
+	cfg, dbs, h, listener := newTestServer(t, false)
+	c := connect(t, h)
+	h.begin()
+	if err := shutdown(cfg, dbs, h, listener, 20*time.Millisecond, ""); err == nil {
+		t.Fatal("shutdown succeeds while a command is running")
+	}
+	if !listening(listener) {
+		t.Error("the listener is closed by an aborted shutdown")
+	}
+	if _, err := os.Stat(cfg.SnapshotPath()); err == nil {
+		t.Error("a snapshot is saved by an aborted shutdown")
+	}
+
+	// the server keeps serving after the abort
+	h.resume()
+	if reply, err := c.send("set", "a", "1"); err != nil || reply != "+OK" {
+		t.Errorf("a command after an aborted shutdown replies %q, %v", reply, err)
+	}
+
+	h.end()
+	if err := shutdown(cfg, dbs, h, listener, 20*time.Millisecond, ""); err != nil {
+		t.Fatalf("shutdown fails after the running command is finished: %v", err)
+	}
+	if listening(listener) {
+		t.Error("the listener is not closed by shutdown")
+	}
+	if _, err := c.send("get", "a"); !errors.Is(err, io.EOF) {
+		t.Errorf("the connection is not closed by shutdown: %v", err)
+	}
+}
+
+func TestResumeCountsOldCommands(t *testing.T) {
+	_, _, h, _ := newTestServer(t, false)
+	h.begin()
+	if h.drain(10 * time.Millisecond) {
+		t.Fatal("drain doesn't wait for the running command")
+	}
+	h.resume()
+	// the command begun before the aborted shutdown is still waited for by the next one
+	if h.drain(10 * time.Millisecond) {
+		t.Fatal("drain after resume doesn't wait for the command begun before")
+	}
+	h.resume()
+	h.begin()
+	h.end()
+	h.end()
+	if !h.drain(10 * time.Millisecond) {
+		t.Error("drain times out after all commands are finished")
+	}
+}
+
+func TestSecondShutdownIsRejected(t *testing.T) {
+	cfg, dbs, h, listener := newTestServer(t, false)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		waitShutdown(cfg, dbs, h, listener, time.Second)
+	}()
+
+	h.begin()
+	first := connect(t, h)
+	firstReply := make(chan error)
+	go func() {
+		_, err := first.send("shutdown")
+		firstReply <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// the first shutdown is waiting for the running command
+	second := connect(t, h)
+	start := time.Now()
+	if reply, err := second.send("shutdown"); err != nil || reply != "-error: server is shutting down" {
+		t.Errorf("a second shutdown replies %q, %v", reply, err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("a second shutdown waits for the first one")
+	}
+
+	h.end()
+	if err := <-firstReply; !errors.Is(err, io.EOF) {
+		t.Errorf("the connection of the first shutdown is not closed: %v", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("the server doesn't stop")
+	}
+}
+
+func TestShutdownSavesSnapshot(t *testing.T) {
+	tests := []struct {
+		appendOnly bool
+		save       string
+		saved      bool
+	}{
+		{appendOnly: false, save: "", saved: true},
+		{appendOnly: true, save: "", saved: false},
+		{appendOnly: true, save: "save", saved: true},
+		{appendOnly: false, save: "nosave", saved: false},
+	}
+	for _, test := range tests {
+		cfg, dbs, h, listener := newTestServer(t, test.appendOnly)
+		if err := shutdown(cfg, dbs, h, listener, time.Second, test.save); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(cfg.SnapshotPath()); (err == nil) != test.saved {
+			t.Errorf("appendonly %v, shutdown %q: snapshot saved is %v, expect %v", test.appendOnly, test.save, err == nil, test.saved)
+		}
+	}
+}
@@ -1,37 +1,54 @@
 package server
 
 import (
+	"errors"
 	"net"
+	"os"
+	"os/signal"
 	"strconv"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/VincentFF/thinredis/config"
 	"github.com/VincentFF/thinredis/logger"
+	"github.com/VincentFF/thinredis/memdb"
 )
 
-// Start starts a simple redis server
-func Start(cfg *config.Config) error {
+// Start starts a simple redis server serving dbs
+// It returns after the server is shut down by the SHUTDOWN command, SIGINT or SIGTERM
+func Start(cfg *config.Config, dbs *memdb.Databases) error {
 	listener, err := net.Listen("tcp", cfg.Host+":"+strconv.Itoa(cfg.Port))
 	if err != nil {
 		logger.Panic(err)
 		return err
 	}
-	defer func() {
-		err := listener.Close()
-		if err != nil {
-			logger.Error(err)
-		}
-	}()
 
 	logger.Info("Server Listen at ", cfg.Host, ":", cfg.Port)
 
 	var sg sync.WaitGroup
-	handler := NewHandler()
+	handler := NewHandler(dbs)
+	timeout := time.Duration(cfg.ShutdownTimeout) * time.Second
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		waitShutdown(cfg, dbs, handler, listener, timeout)
+	}()
+
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			logger.Error(err)
-			break
+			if errors.Is(err, net.ErrClosed) {
+				// the listener is closed by shutdown, wait for persistence to finish
+				<-stopped
+				err = nil
+			} else {
+				logger.Error(err)
+			}
+			if !waitTimeout(&sg, timeout) {
+				logger.Warning("some connections are not closed in ", timeout)
+			}
+			return err
 		}
 
 		logger.Info(conn.RemoteAddr().String(), " connected")
@@ -41,6 +58,57 @@ func Start(cfg *config.Config) error {
 			handler.Handle(conn)
 		}()
 	}
-	sg.Wait()
+}
+
+// waitShutdown waits for a SHUTDOWN command or a SIGINT/SIGTERM signal and shuts down the server.
+// A failed shutdown is reported to the client and the server keeps serving.
+func waitShutdown(cfg *config.Config, dbs *memdb.Databases, handler *Handler, listener net.Listener, timeout time.Duration) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	for {
+		var req *shutdownRequest
+		select {
+		case sig := <-sigCh:
+			logger.Info("receive signal ", sig.String(), ", shutting down")
+			req = &shutdownRequest{reply: make(chan error, 1)}
+		case req = <-handler.shutdownCh:
+			logger.Info("receive shutdown command, shutting down")
+		}
+
+		err := shutdown(cfg, dbs, handler, listener, timeout, req.save)
+		req.reply <- err
+		if err == nil {
+			return
+		}
+		logger.Error("shutdown error: ", err.Error())
+		handler.resume()
+	}
+}
+
+// shutdown waits for the running commands, persists dbs, and closes the listener and all client connections.
+// By default a snapshot is saved only if the append only file is disabled, "save" always saves one and "nosave" never does.
+// The append only file is always flushed. Nothing is closed if the running commands are not finished in timeout,
+// as they may still write to dbs and the append only file, or if saving the snapshot fails.
+func shutdown(cfg *config.Config, dbs *memdb.Databases, handler *Handler, listener net.Listener, timeout time.Duration, save string) error {
+	if !handler.drain(timeout) {
+		return errors.New("commands are still running after " + timeout.String())
+	}
+	if save == "save" || (save == "" && !cfg.AppendOnly) {
+		if err := dbs.Save(); err != nil {
+			return err
+		}
+		logger.Info("snapshot is saved before shutdown")
+	}
+
+	if err := listener.Close(); err != nil {
+		logger.Error(err)
+	}
+	handler.closeConns()
+	if err := dbs.Close(); err != nil {
+		logger.Error("close aof error: ", err.Error())
+	}
+	logger.Info("Server is shut down")
 	return nil
 }